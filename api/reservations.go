@@ -4,6 +4,7 @@ package api
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -14,16 +15,42 @@ type Reservation struct {
 	Start        time.Time `json:"start"`
 	End          time.Time `json:"end"`
 	Loan         bool      `json:"loan"`
+	OpenEnded    bool      `json:"openEnded,omitempty"` // no end until ended
 	Share        bool      `json:"share"`
+	NoShare      bool      `json:"noShare,omitempty"`  // overrides a resource share default
+	Flexible     bool      `json:"flexible,omitempty"` // end may be trimmed for a later booking
 	Notes        string    `json:"notes,omitempty"`
+	Links        []string  `json:"links,omitempty"` // tickets, runbooks
+	Tags         []string  `json:"tags,omitempty"`  // categories, e.g. ci or demo
 	Name         string    `json:"name"`
 	Initials     string    `json:"initials"`
 	Email        string    `json:"email"`
+	Owner        string    `json:"owner,omitempty"`     // verified email of the creator
+	CheckedIn    bool      `json:"checkedIn,omitempty"` // holder reported use
+	NoShow       bool      `json:"noShow,omitempty"`    // ended unused
+}
+
+// Blackout is a recurring window, in server local time, during which a
+// resource can not be reserved. End before Start spans midnight.
+type Blackout struct {
+	ID       int            `json:"id"`
+	Resource string         `json:"resource"`
+	Days     []time.Weekday `json:"days"`
+	Start    string         `json:"start"` // 15:04
+	End      string         `json:"end"`   // 15:04
+	Reason   string         `json:"reason,omitempty"`
 }
 
 const (
-	V3mail = "/v3/mailverify"
-	V3api  = "/v3/reservations/"
+	V3mail     = "/v3/mailverify"
+	V3api      = "/v3/reservations/"
+	V3blackout = "/v3/blackouts/"
+	V3version  = "/v3/version"
+
+	// identifies the requesting user for ownership checks
+	UserHeader = "X-Reserve-User"
+	// verified email of the requesting user, the canonical owner identity
+	OwnerHeader = "X-Reserve-Owner"
 )
 
 func (r *Reservation) String() string {
@@ -33,3 +60,11 @@ func (r *Reservation) String() string {
 		return fmt.Sprintf("%d %s from %s to %s %s", r.ID, r.Resource, r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Name)
 	}
 }
+
+func (b *Blackout) String() string {
+	days := make([]string, 0, len(b.Days))
+	for _, d := range b.Days {
+		days = append(days, d.String()[:3])
+	}
+	return fmt.Sprintf("%d %s %s %s-%s", b.ID, b.Resource, strings.Join(days, ","), b.Start, b.End)
+}
@@ -0,0 +1,118 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package api
+
+import (
+	"strings"
+)
+
+// orderings for reservation lists, shared by the server and the client
+
+type ByID []*Reservation
+
+func (b ByID) Len() int      { return len(b) }
+func (b ByID) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b ByID) Less(i, j int) bool {
+	return b[i].ID < b[j].ID
+}
+
+type ByDate []*Reservation
+
+func (b ByDate) Len() int      { return len(b) }
+func (b ByDate) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b ByDate) Less(i, j int) bool {
+	return b[i].Start.Before(b[j].Start)
+}
+
+// loans have no end and sort last, in order of start
+type ByEnd []*Reservation
+
+func (b ByEnd) Len() int      { return len(b) }
+func (b ByEnd) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b ByEnd) Less(i, j int) bool {
+	if b[i].Loan || b[j].Loan {
+		if b[i].Loan && b[j].Loan {
+			return b[i].Start.Before(b[j].Start)
+		}
+		return b[j].Loan
+	}
+	return b[i].End.Before(b[j].End)
+}
+
+// loans have no duration and sort last, in order of start
+type ByDuration []*Reservation
+
+func (b ByDuration) Len() int      { return len(b) }
+func (b ByDuration) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b ByDuration) Less(i, j int) bool {
+	if b[i].Loan || b[j].Loan {
+		if b[i].Loan && b[j].Loan {
+			return b[i].Start.Before(b[j].Start)
+		}
+		return b[j].Loan
+	}
+	return b[i].End.Sub(b[i].Start) < b[j].End.Sub(b[j].Start)
+}
+
+type ByName []*Reservation
+
+func (b ByName) Len() int           { return len(b) }
+func (b ByName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b ByName) Less(i, j int) bool { return strings.Compare(b[i].Name, b[j].Name) < 0 }
+
+type ByResource []*Reservation
+
+func (b ByResource) Len() int      { return len(b) }
+func (b ByResource) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b ByResource) Less(i, j int) bool {
+	less := func(i, j int, prefix string) (bool, bool) {
+		if strings.HasPrefix(b[i].Resource, prefix) && !strings.HasPrefix(b[j].Resource, prefix) {
+			return true, true
+		}
+		if !strings.HasPrefix(b[i].Resource, prefix) && strings.HasPrefix(b[j].Resource, prefix) {
+			return true, false
+		}
+		if strings.HasPrefix(b[i].Resource, prefix) && strings.HasPrefix(b[j].Resource, prefix) {
+			if len(b[i].Resource) > len(prefix) && len(b[j].Resource) > len(prefix) {
+				if b[i].Resource[3] > b[j].Resource[3] {
+					return true, true
+				}
+				if b[i].Resource[3] < b[j].Resource[3] {
+					return true, false
+				}
+			}
+
+			cmp := strings.Compare(b[i].Resource, b[j].Resource)
+
+			if cmp == 0 && b[i].Start.Before(b[j].Start) {
+				return true, true
+			}
+
+			return true, cmp < 0
+		}
+		return false, false
+	}
+
+	if done, retval := less(i, j, "lin"); done {
+		return retval
+	}
+
+	if done, retval := less(i, j, "esx"); done {
+		return retval
+	}
+
+	if done, retval := less(i, j, "win"); done {
+		return retval
+	}
+
+	if done, retval := less(i, j, "poc"); done {
+		return retval
+	}
+
+	if strings.Compare(b[i].Resource, b[j].Resource) == 0 &&
+		b[i].Start.Before(b[j].Start) {
+		return true
+	}
+
+	return strings.Compare(b[i].Resource, b[j].Resource) < 0
+}
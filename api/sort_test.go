@@ -0,0 +1,45 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package api
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSortEndDuration(t *testing.T) {
+	now := time.Now()
+
+	res := func() []*Reservation {
+		return []*Reservation{
+			&Reservation{ID: 1, Start: now, End: now.Add(3 * time.Hour)},
+			&Reservation{ID: 2, Start: now.Add(-time.Hour), End: now.Add(-time.Hour), Loan: true},
+			&Reservation{ID: 3, Start: now.Add(2 * time.Hour), End: now.Add(4 * time.Hour)},
+			&Reservation{ID: 4, Start: now.Add(-2 * time.Hour), End: now.Add(30 * time.Minute)},
+			&Reservation{ID: 5, Start: now.Add(-3 * time.Hour), End: now.Add(-3 * time.Hour), Loan: true},
+		}
+	}
+
+	tests := []struct {
+		name string
+		sort func([]*Reservation) sort.Interface
+		ids  []int
+	}{
+		{name: "end", sort: func(r []*Reservation) sort.Interface { return ByEnd(r) }, ids: []int{4, 1, 3, 5, 2}},
+		{name: "duration", sort: func(r []*Reservation) sort.Interface { return ByDuration(r) }, ids: []int{3, 4, 1, 5, 2}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := res()
+			sort.Sort(tc.sort(r))
+
+			for i, id := range tc.ids {
+				if r[i].ID != id {
+					t.Fatalf("expected reservation %d at %d got %d", id, i, r[i].ID)
+				}
+			}
+		})
+	}
+}
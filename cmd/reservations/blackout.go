@@ -0,0 +1,384 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+// resource blackout calendar
+//
+// - admins add recurring windows (nightly backups, weekly maintenance)
+// - reservations overlapping a window for the same resource are rejected
+// - windows are saved as a JSON array, rewritten on every change
+
+type Blackouts interface {
+	Blocked(res *Reservation, same func(a, b string) bool) (*Blackout, bool)
+}
+
+type blackouts struct {
+	nextID   int
+	windows  []*Blackout
+	filename string
+	sync.Mutex
+}
+
+func NewBlackouts(filename string) (*blackouts, error) {
+	b := &blackouts{
+		windows:  make([]*Blackout, 0),
+		filename: filename,
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	err = b.readfile()
+	if err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+	}
+
+	for _, w := range b.windows {
+		if w.ID >= b.nextID {
+			b.nextID = w.ID + 1
+		}
+	}
+
+	return b, nil
+}
+
+func (b *blackouts) readfile() error {
+	if b.filename == "" {
+		return nil
+	}
+
+	file, err := os.Open(b.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewDecoder(file).Decode(&b.windows)
+}
+
+func (b *blackouts) savefile() error {
+	if b.filename == "" {
+		return nil
+	}
+
+	newfile := b.filename + "-"
+
+	file, err := os.Create(newfile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "    ")
+	err = enc.Encode(&b.windows)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(newfile, b.filename)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func clock(hhmm string) (time.Duration, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("time \"%s\" not in HH:MM format", hhmm)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func validBlackout(w *Blackout) error {
+	if w.Resource == "" {
+		return errors.New("resource not specified")
+	}
+
+	if len(w.Days) == 0 {
+		return errors.New("days not specified")
+	}
+
+	for _, d := range w.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid day %d", d)
+		}
+	}
+
+	start, err := clock(w.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := clock(w.End)
+	if err != nil {
+		return err
+	}
+
+	if start == end {
+		return errors.New("start and end are the same")
+	}
+
+	return nil
+}
+
+// determine if a reservation overlaps any occurrence of the window -
+// occurrences start on the listed days, so start one day early to
+// catch windows running past midnight
+func blackoutOverlap(w *Blackout, res *Reservation) bool {
+	start, err := clock(w.Start)
+	if err != nil {
+		return false
+	}
+
+	end, err := clock(w.End)
+	if err != nil {
+		return false
+	}
+
+	if end <= start {
+		end += 24 * time.Hour
+	}
+
+	days := make(map[time.Weekday]bool)
+	for _, d := range w.Days {
+		days[d] = true
+	}
+
+	first := res.Start.In(time.Local).AddDate(0, 0, -1)
+	day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.Local)
+
+	for day.Before(res.End) {
+		if days[day.Weekday()] {
+			ws := day.Add(start)
+			we := day.Add(end)
+
+			if ws.Before(res.End) && we.After(res.Start) {
+				return true
+			}
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return false
+}
+
+// find a blackout window overlapping the reservation, resources matched
+// by same - loans are not checked as they have no end, and open-ended
+// reservations are only refused starting inside a window, the holder
+// ending them for maintenance
+func (b *blackouts) Blocked(res *Reservation, same func(a, b string) bool) (*Blackout, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if res.Loan {
+		return nil, false
+	}
+
+	if res.OpenEnded {
+		start := *res
+		start.End = res.Start.Add(time.Second)
+		res = &start
+	}
+
+	for _, w := range b.windows {
+		if !same(w.Resource, res.Resource) {
+			continue
+		}
+
+		if blackoutOverlap(w, res) {
+			return w, true
+		}
+	}
+
+	return nil, false
+}
+
+func (b *blackouts) List() []*Blackout {
+	b.Lock()
+	defer b.Unlock()
+
+	return append([]*Blackout{}, b.windows...)
+}
+
+func (b *blackouts) Add(w *Blackout) error {
+	err := validBlackout(w)
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	w.ID = b.nextID
+	b.nextID++
+	b.windows = append(b.windows, w)
+
+	log.Printf("added blackout %s", w)
+
+	return b.savefile()
+}
+
+func (b *blackouts) Delete(ref int) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for i, w := range b.windows {
+		if w.ID != ref {
+			continue
+		}
+
+		b.windows = append(b.windows[:i], b.windows[i+1:]...)
+
+		log.Printf("deleted blackout %s", w)
+
+		return b.savefile()
+	}
+
+	return errors.New("blackout not found")
+}
+
+// GET    /v3/blackouts/      - list blackout windows
+// POST   /v3/blackouts/      - add blackout window, admins only
+// DELETE /v3/blackouts/<id>  - delete blackout window, admins only
+
+func (b *blackouts) rest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ref int
+		var refset bool
+		var err error
+
+		if r.URL.Path != "" {
+			ref, err = strconv.Atoi(r.URL.Path)
+			if err != nil {
+				v3error(w, fmt.Sprintf("ref \"%s\" is not a number", r.URL.Path), http.StatusNotFound)
+				return
+			}
+
+			refset = true
+		}
+
+		switch r.Method {
+		case http.MethodPost, http.MethodDelete:
+			if !v3admin(w, r, "blackout changes") {
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if refset {
+				v3error(w, "get not allowed on blackout", http.StatusMethodNotAllowed)
+				return
+			}
+
+			reply := struct {
+				Status    string      `json:"status"`
+				Blackouts []*Blackout `json:"blackouts"`
+			}{
+				Status:    "Success",
+				Blackouts: b.List(),
+			}
+
+			buf, err := json.Marshal(reply)
+			if err != nil {
+				v3error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+			w.Write(buf)
+
+		case http.MethodPost:
+			if refset {
+				v3error(w, "post not allowed on blackout", http.StatusMethodNotAllowed)
+				return
+			}
+
+			if r.Header.Get("Content-Type") != "application/json" {
+				v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			var req = &Blackout{}
+
+			err := json.NewDecoder(io.LimitReader(r.Body, v3readlen(r, v3MaxRead))).Decode(req)
+			if err != nil {
+				v3error(w, "malformed request", http.StatusBadRequest)
+				return
+			}
+
+			err = b.Add(req)
+			if err != nil {
+				v3error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			reply := struct {
+				Status string `json:"status"`
+				ID     *int   `json:"id,omitempty"`
+			}{
+				Status: "Success",
+				ID:     &req.ID,
+			}
+
+			buf, err := json.Marshal(reply)
+			if err != nil {
+				v3error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+			w.WriteHeader(http.StatusCreated)
+			w.Write(buf)
+
+		case http.MethodDelete:
+			if refset == false {
+				v3error(w, "ref not specified", http.StatusNotFound)
+				return
+			}
+
+			err := b.Delete(ref)
+			if err != nil {
+				if strings.Contains(err.Error(), "not found") {
+					v3error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+				v3error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		}
+	}
+}
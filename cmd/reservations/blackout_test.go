@@ -0,0 +1,153 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestBlackoutOverlap(t *testing.T) {
+	// a Monday
+	monday := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+
+	nightly := &Blackout{
+		Resource: "resource",
+		Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Start:    "22:00",
+		End:      "02:00",
+	}
+
+	tests := []struct {
+		name    string
+		start   time.Duration
+		end     time.Duration
+		overlap bool
+	}{
+		{name: "before window", start: 20 * time.Hour, end: 22 * time.Hour, overlap: false},
+		{name: "into window", start: 21 * time.Hour, end: 23 * time.Hour, overlap: true},
+		{name: "past midnight", start: 25 * time.Hour, end: 27 * time.Hour, overlap: true},
+		{name: "after window", start: 26 * time.Hour, end: 30 * time.Hour, overlap: false},
+		{name: "sunday night", start: -3 * time.Hour, end: -1 * time.Hour, overlap: false},
+		{name: "saturday early", start: 5*24*time.Hour + time.Hour, end: 5*24*time.Hour + 3*time.Hour, overlap: true},
+		{name: "sunday early", start: 6*24*time.Hour + time.Hour, end: 6*24*time.Hour + 3*time.Hour, overlap: false},
+		{name: "spanning days", start: 12 * time.Hour, end: 3 * 24 * time.Hour, overlap: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				Resource: "resource",
+				Start:    monday.Add(tc.start),
+				End:      monday.Add(tc.end),
+			}
+
+			if overlap := blackoutOverlap(nightly, res); overlap != tc.overlap {
+				t.Fatalf("expected overlap %t got %t", tc.overlap, overlap)
+			}
+		})
+	}
+}
+
+func TestBlackoutSaveRestore(t *testing.T) {
+	filename := time.Now().Format("blackout-20060102150405000000.json")
+	defer os.Remove(filename)
+
+	b, err := NewBlackouts(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Add(&Blackout{
+		Resource: "resource",
+		Days:     []time.Weekday{time.Saturday},
+		Start:    "08:00",
+		End:      "12:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Add(&Blackout{
+		Resource: "resource",
+		Days:     []time.Weekday{time.Sunday},
+		Start:    "8am",
+		End:      "12:00",
+	})
+	if err == nil {
+		t.Fatal("expected time format error")
+	}
+
+	b, err = NewBlackouts(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.windows) != 1 {
+		t.Fatalf("expected %d blackout windows got %d", 1, len(b.windows))
+	}
+
+	if b.nextID != 1 {
+		t.Fatalf("expected next ID \"%d\", got \"%d\"", 1, b.nextID)
+	}
+
+	err = b.Delete(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Delete(0)
+	if err == nil {
+		t.Fatal("expected not found error")
+	}
+}
+
+func TestBlackoutRestAdmin(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	b := &blackouts{windows: make([]*Blackout, 0)}
+	handler := b.rest()
+
+	request := func(method, path, user string) int {
+		body := bytes.NewBufferString(`{"resource":"resource","days":[6],"start":"08:00","end":"12:00"}`)
+
+		r, _ := http.NewRequest(method, path, body)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(UserHeader, user)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	if code := request(http.MethodPost, "", "Some User"); code != http.StatusForbidden {
+		t.Fatalf("expected status code %d got %d", http.StatusForbidden, code)
+	}
+
+	if code := request(http.MethodPost, "", "Admin User"); code != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d", http.StatusCreated, code)
+	}
+
+	if code := request(http.MethodGet, "", "Some User"); code != http.StatusOK {
+		t.Fatalf("expected status code %d got %d", http.StatusOK, code)
+	}
+
+	if code := request(http.MethodDelete, "0", "Some User"); code != http.StatusForbidden {
+		t.Fatalf("expected status code %d got %d", http.StatusForbidden, code)
+	}
+
+	if code := request(http.MethodDelete, "0", "Admin User"); code != http.StatusOK {
+		t.Fatalf("expected status code %d got %d", http.StatusOK, code)
+	}
+}
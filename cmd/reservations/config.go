@@ -0,0 +1,36 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+// effective server settings as parsed from the environment and flags,
+// reported by the config command - nothing secret belongs here
+type serverConfig struct {
+	Port          string         `json:"port"`
+	Addr          string         `json:"addr"`
+	Data          string         `json:"data"`
+	Mail          string         `json:"mail"`
+	Blackout      string         `json:"blackout"`
+	Compact       bool           `json:"compact"`
+	Owners        bool           `json:"owners"`
+	Admins        []string       `json:"admins"`
+	NoShow        string         `json:"noShow"`
+	EOD           string         `json:"eod"`
+	MaxQueued     map[string]int `json:"maxQueued"`
+	Shared        []string       `json:"shared"`
+	MaxBody       int            `json:"maxBody"`
+	ReadTimeout   int            `json:"readTimeout"`
+	WriteTimeout  int            `json:"writeTimeout"`
+	BodyTimeout   int            `json:"bodyTimeout"`
+	LogFormat     string         `json:"logFormat"`
+	RejectPastEnd bool           `json:"rejectPastEnd"`
+	ReadOnly      bool           `json:"readOnly"`
+	CaseFold      bool           `json:"caseFold"`
+	MaxDuration   string         `json:"maxDuration"`
+	Rate          int            `json:"rate"`
+	TrustProxy    bool           `json:"trustProxy"`
+	StrictLog     bool           `json:"strictLog"`
+	MinFlexible   string         `json:"minFlexible"`
+	SlowStore     string         `json:"slowStore"`
+	Undo          string         `json:"undo"`
+	CORS          []string       `json:"cors"`
+}
@@ -0,0 +1,73 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+// origins browsers may call the API from, "*" for any - none leaves CORS
+// off and cross-origin browser requests blocked
+var corsOrigins = map[string]bool{}
+
+// request headers a cross-origin caller may send, and response headers
+// it may read beyond the basic set
+var (
+	corsAllowHeaders  = []string{"Content-Type", "Content-Encoding", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "Idempotency-Key", UserHeader, OwnerHeader}
+	corsExposeHeaders = []string{"Location", "ID", "ETag", "Last-Modified", "X-Next-Reservation", "X-Reservation-End", "Idempotent-Replayed"}
+)
+
+// the allowed origin of a cross-origin request, empty when the request
+// is same-origin or its origin not allowed
+func corsOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return ""
+	}
+
+	if corsOrigins[origin] || corsOrigins["*"] {
+		return origin
+	}
+
+	return ""
+}
+
+// let an allowed origin read the response, the origin is echoed so a
+// cache keeps responses to different origins apart
+func corsHeaders(w http.ResponseWriter, r *http.Request) {
+	if len(corsOrigins) == 0 {
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	origin := corsOrigin(r)
+	if origin == "" {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposeHeaders, ", "))
+}
+
+// answer a preflight from an allowed origin, whatever the path - the
+// methods allowed are those of any endpoint, each refusing the rest
+func corsPreflight(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	if corsOrigin(r) == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, HEAD, GET, POST, PUT, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
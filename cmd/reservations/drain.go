@@ -0,0 +1,23 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// changes in flight are counted in jobs, so shutdown waits for their
+// log appends to finish rather than exiting part way through a line
+func drain(jobs *sync.WaitGroup, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			jobs.Add(1)
+			defer jobs.Done()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainShutdown(t *testing.T) {
+	var (
+		jobs     sync.WaitGroup
+		started  = make(chan struct{})
+		finished int32
+	)
+
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: drain(&jobs, slow)}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	go func() {
+		resp, err := http.Post("http://"+l.Addr().String()+"/v3/reservations/", "application/json", strings.NewReader("{}"))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+
+	go srv.Shutdown(context.Background())
+
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("expected server closed got %v", err)
+	}
+
+	jobs.Wait()
+
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("shutdown finished before the change in flight")
+	}
+}
+
+func TestDrainReads(t *testing.T) {
+	var jobs sync.WaitGroup
+
+	inside := make(chan struct{})
+
+	handler := drain(&jobs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a read isn't waited for, Wait returns with one in progress
+		jobs.Wait()
+		close(inside)
+	}))
+
+	r, _ := http.NewRequest(http.MethodGet, "/v3/reservations/", nil)
+	handler.ServeHTTP(nil, r)
+
+	select {
+	case <-inside:
+	default:
+		t.Fatal("read counted as a change")
+	}
+}
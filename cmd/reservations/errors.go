@@ -0,0 +1,89 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// consistent error bodies across the mux
+//
+// - API paths and clients accepting JSON get the v3 error envelope
+// - browsers get a small HTML page
+// - everyone else gets plain text
+
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/v3/") {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// write an error in the form the client asked for
+func httpError(w http.ResponseWriter, r *http.Request, errstr string, code int) {
+	switch {
+	case wantsJSON(r):
+		v3error(w, errstr, code)
+
+	case wantsHTML(r):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "<html><body><h1>%d %s</h1><p>%s</p></body></html>\n", code, http.StatusText(code), html.EscapeString(errstr))
+
+	default:
+		http.Error(w, errstr, code)
+	}
+}
+
+// unknown API routes, paths outside the API are left to the file server
+func notFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, r, fmt.Sprintf("no such endpoint \"%s\"", r.URL.Path), http.StatusNotFound)
+}
+
+// rewrite error responses not already in JSON using httpError, encoded
+// responses are left alone
+func errorBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := httptest.NewRecorder()
+		next.ServeHTTP(response, r)
+
+		header := response.Header()
+
+		rewrite := header.Get("Content-Type") != "application/json" && header.Get("Content-Encoding") == ""
+
+		if response.Code >= http.StatusBadRequest && rewrite {
+			errstr := strings.TrimSpace(response.Body.String())
+			if errstr == "" {
+				errstr = http.StatusText(response.Code)
+			}
+
+			for k, v := range header {
+				switch k {
+				case "Content-Type", "Content-Length", "X-Content-Type-Options":
+					continue
+				}
+				w.Header()[k] = v
+			}
+
+			httpError(w, r, errstr, response.Code)
+			return
+		}
+
+		// this copies the recorded response to the response writer
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(response.Code)
+		response.Body.WriteTo(w)
+	})
+}
@@ -0,0 +1,100 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorBodies(t *testing.T) {
+	handler := routes(v3res(&apiStorage{}), &mail{names: map[string]*Email{}}, &blackouts{})
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		accept  string
+		status  int
+		content string
+	}{
+		{"file text", http.MethodGet, "/nothing-here", "", http.StatusNotFound, "text/plain; charset=utf-8"},
+		{"file json", http.MethodGet, "/nothing-here", "application/json", http.StatusNotFound, "application/json"},
+		{"file html", http.MethodGet, "/nothing-here", "text/html,application/xhtml+xml", http.StatusNotFound, "text/html; charset=utf-8"},
+		{"api method", "TRACE", "/v3/reservations/", "text/html", http.StatusMethodNotAllowed, "application/json"},
+		{"api ref", http.MethodGet, "/v3/reservations/abc", "", http.StatusNotFound, "application/json"},
+		{"blackout method", "TRACE", "/v3/blackouts/", "", http.StatusMethodNotAllowed, "application/json"},
+		{"api unknown", http.MethodGet, "/v3/nothing-here", "text/html", http.StatusNotFound, "application/json"},
+		{"api unknown post", http.MethodPost, "/v3/reservation", "", http.StatusNotFound, "application/json"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest(tc.method, tc.path, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+
+			if resp.Header.Get("Content-Type") != tc.content {
+				t.Fatalf("expected content type \"%s\" got \"%s\"", tc.content, resp.Header.Get("Content-Type"))
+			}
+
+			if tc.content != "application/json" {
+				return
+			}
+
+			reply := struct {
+				Status string `json:"status"`
+				Error  string `json:"error"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Status != "Error" || strings.TrimSpace(reply.Error) == "" {
+				t.Fatalf("expected error envelope got %+v", reply)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	handler := routes(v3res(&apiStorage{}), &mail{names: map[string]*Email{}}, &blackouts{})
+
+	r, _ := http.NewRequest(http.MethodGet, "/v3/nothing-here", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code %d got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	reply := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}{}
+
+	err := json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Error != `no such endpoint "/v3/nothing-here"` {
+		t.Fatalf("unexpected error \"%s\"", reply.Error)
+	}
+}
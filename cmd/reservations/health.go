@@ -0,0 +1,57 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// set once the store is loaded, cleared again on shutdown so load
+// balancers drain the server
+var ready int32
+
+func setReady(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&ready, v)
+}
+
+func isReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
+// liveness probe, answers whenever the server is up
+func healthz(w http.ResponseWriter, r *http.Request) {
+	probe(w, r, http.StatusOK, "ok")
+}
+
+// readiness probe, answers 503 until the store is loaded
+func readyz(w http.ResponseWriter, r *http.Request) {
+	if !isReady() {
+		probe(w, r, http.StatusServiceUnavailable, "loading")
+		return
+	}
+
+	probe(w, r, http.StatusOK, "ready")
+}
+
+func probe(w http.ResponseWriter, r *http.Request, code int, status string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	fmt.Fprintf(w, "{\"status\":\"%s\"}\n", status)
+}
@@ -0,0 +1,97 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthProbes(t *testing.T) {
+	defer setReady(isReady())
+
+	handler := routes(v3res(&apiStorage{}), &mail{names: map[string]*Email{}}, &blackouts{})
+
+	tests := []struct {
+		name   string
+		path   string
+		ready  bool
+		status int
+		body   string
+	}{
+		{"alive loading", "/healthz", false, http.StatusOK, "ok"},
+		{"alive", "/healthz", true, http.StatusOK, "ok"},
+		{"loading", "/readyz", false, http.StatusServiceUnavailable, "loading"},
+		{"ready", "/readyz", true, http.StatusOK, "ready"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setReady(tc.ready)
+
+			r, _ := http.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+
+			if resp.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("expected content type \"application/json\" got \"%s\"", resp.Header.Get("Content-Type"))
+			}
+
+			reply := struct {
+				Status string `json:"status"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Status != tc.body {
+				t.Fatalf("expected status \"%s\" got \"%s\"", tc.body, reply.Status)
+			}
+		})
+	}
+}
+
+func TestHealthProbesThrottled(t *testing.T) {
+	defer setReady(isReady())
+	setReady(true)
+
+	saved := requestLimit
+	defer func() { requestLimit = saved }()
+
+	requestLimit = newTokenLimiter(1, 1)
+
+	handler := routes(v3res(&apiStorage{}), &mail{names: map[string]*Email{}}, &blackouts{})
+
+	get := func(path string) int {
+		r, _ := http.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	if code := get("/help"); code != http.StatusOK {
+		t.Fatalf("expected status code %d got %d", http.StatusOK, code)
+	}
+
+	if code := get("/help"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, code)
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		if code := get(path); code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d got %d", path, http.StatusOK, code)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how long a POST Idempotency-Key is remembered
+var idempotencyTTL = 10 * time.Minute
+
+// response to a POST carrying an Idempotency-Key, replayed when the
+// key comes again - done is closed once the first request finishes
+type keyResult struct {
+	expires time.Time
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+// remembered Idempotency-Keys, a nil cache remembers nothing
+type keyCache struct {
+	ttl  time.Duration
+	keys map[string]*keyResult
+	sync.Mutex
+}
+
+func newKeyCache(ttl time.Duration) *keyCache {
+	return &keyCache{
+		ttl:  ttl,
+		keys: make(map[string]*keyResult),
+	}
+}
+
+// claim key for a new request, or wait out the request holding it and
+// return its result - a claimed key must be finished or released
+func (c *keyCache) claim(key string, now time.Time) (*keyResult, bool) {
+	for {
+		c.Lock()
+
+		for k, kr := range c.keys {
+			if kr.body != nil && now.After(kr.expires) {
+				delete(c.keys, k)
+			}
+		}
+
+		kr, ok := c.keys[key]
+		if !ok {
+			kr = &keyResult{done: make(chan struct{})}
+			c.keys[key] = kr
+			c.Unlock()
+			return kr, true
+		}
+
+		c.Unlock()
+
+		<-kr.done
+
+		// the request holding the key failed, try for it again
+		if kr.body == nil {
+			continue
+		}
+
+		return kr, false
+	}
+}
+
+// remember the response to the request holding key
+func (c *keyCache) finish(key string, kr *keyResult, status int, header http.Header, body []byte, now time.Time) {
+	c.Lock()
+	kr.expires = now.Add(c.ttl)
+	kr.status = status
+	kr.header = header.Clone()
+	kr.body = body
+	c.Unlock()
+
+	close(kr.done)
+}
+
+// forget key after a failed request, so a retry is tried afresh
+func (c *keyCache) release(key string, kr *keyResult) {
+	c.Lock()
+	delete(c.keys, key)
+	c.Unlock()
+
+	close(kr.done)
+}
+
+// write the remembered response
+func (kr *keyResult) replay(w http.ResponseWriter) {
+	for k, v := range kr.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(kr.status)
+	w.Write(kr.body)
+}
@@ -12,7 +12,10 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"sync"
+	"time"
 
 	. "github.com/dbulkow/reservations/api"
 )
@@ -20,6 +23,9 @@ import (
 type jsonl struct {
 	file     *os.File
 	filename string
+	tolerant bool // skip unreadable records on replay rather than fail
+	maxBody  int  // largest request body accepted, bounds a record's line
+	sync.Mutex
 }
 
 func NewJSONL(filename string) (*jsonl, error) {
@@ -35,6 +41,7 @@ func NewJSONL(filename string) (*jsonl, error) {
 type jsonlog struct {
 	Operation   string       `json:"op"`
 	ID          int          `json:"id"`
+	Time        time.Time    `json:"time"`
 	Reservation *Reservation `json:"res"`
 }
 
@@ -67,7 +74,37 @@ func (j *jsonl) Delete(ref int) error {
 	return j.append(&record)
 }
 
+func (j *jsonl) Restore(res *Reservation) error {
+	var record jsonlog
+
+	record.Operation = "restore"
+	record.ID = res.ID
+	record.Reservation = res
+
+	return j.append(&record)
+}
+
+// a scanner taking lines as long as any record written from a request
+// body of maxBody bytes - re-encoding may escape each byte to six, and
+// the buffer only grows as long lines are read
+func (j *jsonl) scanner(file *os.File) *bufio.Scanner {
+	max := bufio.MaxScanTokenSize
+	if j.maxBody > 0 {
+		max += 6 * j.maxBody
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), max)
+
+	return scanner
+}
+
 func (j *jsonl) append(record *jsonlog) error {
+	j.Lock()
+	defer j.Unlock()
+
+	record.Time = time.Now().Round(time.Second)
+
 	file, err := os.OpenFile(j.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
@@ -82,26 +119,58 @@ func (j *jsonl) append(record *jsonlog) error {
 	return nil
 }
 
+// a record that can't be replayed, say a partial append from a crash,
+// fails the read unless tolerant when it is logged and skipped
 func (j *jsonl) ReadLog(m *memory) error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.readLog(m)
+}
+
+// ReadLog with the lock held, so the log isn't appended to or compacted
+// while it is replayed
+func (j *jsonl) readLog(m *memory) error {
 	file, err := os.Open(j.filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	skipped := 0
+
+	scanner := j.scanner(file)
+	for line := 1; scanner.Scan(); line++ {
 		var record jsonlog
 
 		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err == nil {
+			switch record.Operation {
+			case "add", "modify", "restore":
+				if record.Reservation == nil {
+					err = fmt.Errorf("%s record without reservation", record.Operation)
+				}
+			case "delete", "mark":
+			default:
+				err = fmt.Errorf("unknown log operation: %s", record.Operation)
+			}
+		}
 		if err != nil {
-			return err
+			if !j.tolerant {
+				return err
+			}
+
+			log.Printf("%s line %d skipped: %v", j.filename, line, err)
+			skipped++
+			continue
 		}
 
 		switch record.Operation {
-		case "add":
+		case "add", "restore":
 			m.reservations = append(m.reservations, record.Reservation)
-			m.nextID = record.Reservation.ID + 1
+			if record.Reservation.ID >= m.nextID {
+				m.nextID = record.Reservation.ID + 1
+			}
 		case "modify":
 			for i, r := range m.reservations {
 				if r.ID != record.ID {
@@ -119,13 +188,132 @@ func (j *jsonl) ReadLog(m *memory) error {
 				m.reservations = append(m.reservations[:i], m.reservations[i+1:]...)
 				break
 			}
-		default:
-			return fmt.Errorf("unknown log operation: %s", record.Operation)
+		case "mark":
+			if record.ID >= m.nextID {
+				m.nextID = record.ID + 1
+			}
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return err
 	}
 
+	if skipped > 0 {
+		log.Printf("%s: %d unreadable lines skipped", j.filename, skipped)
+	}
+
 	return nil
 }
+
+// History returns the logged operations on one reservation, oldest
+// first. Records from before operations were timed carry the time the
+// reservation was last modified, deletes none. Compaction folds the
+// history of a reservation into a single add.
+func (j *jsonl) History(ref int) ([]*HistoryEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	file, err := os.Open(j.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hist := make([]*HistoryEntry, 0)
+
+	scanner := j.scanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var record jsonlog
+
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			if !j.tolerant {
+				return nil, err
+			}
+
+			log.Printf("%s line %d skipped: %v", j.filename, line, err)
+			continue
+		}
+
+		if record.ID != ref || record.Operation == "mark" {
+			continue
+		}
+
+		if record.Time.IsZero() && record.Reservation != nil {
+			record.Time = record.Reservation.LastModified
+		}
+
+		hist = append(hist, &HistoryEntry{
+			Operation:   record.Operation,
+			Time:        record.Time,
+			Reservation: record.Reservation,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return hist, nil
+}
+
+// Compact rewrites the log with a single add record per surviving
+// reservation. Superseded modify records and deleted reservations are
+// dropped, a leading mark record keeping the highest ID handed out so
+// IDs of deleted reservations are never reused. The new log is written
+// aside and synced before it is renamed into place.
+func (j *jsonl) Compact() error {
+	j.Lock()
+	defer j.Unlock()
+
+	m := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err := j.readLog(m)
+	if err != nil {
+		return err
+	}
+
+	newfile := j.filename + "-"
+
+	file, err := os.OpenFile(newfile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+
+	if m.nextID > 0 {
+		err = enc.Encode(&jsonlog{Operation: "mark", ID: m.nextID - 1, Time: time.Now().Round(time.Second)})
+		if err != nil {
+			return fmt.Errorf("jsonl encode: %v", err)
+		}
+	}
+
+	for _, res := range m.reservations {
+		record := &jsonlog{
+			Operation:   "add",
+			ID:          res.ID,
+			Time:        res.LastModified,
+			Reservation: res,
+		}
+
+		err = enc.Encode(record)
+		if err != nil {
+			return fmt.Errorf("jsonl encode: %v", err)
+		}
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(newfile, j.filename)
+}
@@ -3,7 +3,9 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -59,3 +61,362 @@ func TestJSONL(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestJSONLLongRecord(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	js.maxBody = v3MaxRead
+
+	// past the scanner's default 64KB line
+	notes := strings.Repeat("n", 100*1024)
+
+	err = js.Add(&Reservation{
+		ID:       56,
+		Resource: "resource",
+		Notes:    notes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = js.ReadLog(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.reservations) != 1 || m.reservations[0].Notes != notes {
+		t.Fatalf("expected reservation with %d bytes of notes read back", len(notes))
+	}
+
+	hist, err := js.History(56)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hist) != 1 || hist[0].Reservation.Notes != notes {
+		t.Fatalf("expected history with %d bytes of notes read back", len(notes))
+	}
+}
+
+func TestJSONLCompact(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	now := time.Now()
+
+	for id := 10; id < 13; id++ {
+		res := &Reservation{
+			ID:       id,
+			Resource: "resource",
+			Start:    now.Add(time.Duration(id) * time.Hour),
+			End:      now.Add(time.Duration(id+1) * time.Hour),
+		}
+
+		err = js.Add(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res.Notes = "modified"
+
+		err = js.Update(res.ID, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = js.Delete(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = js.ReadLog(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = js.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a mark and the two survivors
+	lines := strings.Count(string(b), "\n")
+	if lines != 3 {
+		t.Fatalf("expected %d records after compaction, got %d", 3, lines)
+	}
+
+	after := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = js.ReadLog(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(after.reservations) != len(before.reservations) {
+		t.Fatalf("expected %d reservations got %d", len(before.reservations), len(after.reservations))
+	}
+
+	for i, res := range after.reservations {
+		exp := before.reservations[i]
+		if res.ID != exp.ID || res.Notes != exp.Notes || !res.Start.Equal(exp.Start) || !res.End.Equal(exp.End) {
+			t.Fatalf("expected %+v got %+v", exp, res)
+		}
+	}
+}
+
+func TestJSONLCompactNextID(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	for id := 0; id < 3; id++ {
+		err = js.Add(&Reservation{ID: id, Resource: "resource"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the highest IDs go, their IDs must not come back
+	for _, id := range []int{1, 2} {
+		err = js.Delete(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = js.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemory(js, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.reservations) != 1 {
+		t.Fatalf("expected %d reservations got %d", 1, len(m.reservations))
+	}
+
+	if m.nextID != 3 {
+		t.Fatalf("expected next ID %d got %d", 3, m.nextID)
+	}
+
+	hist, err := js.History(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hist) != 0 {
+		t.Fatalf("expected no history for the mark got %d entries", len(hist))
+	}
+
+	// compacting again keeps the mark
+	err = js.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = NewMemory(js, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.nextID != 3 {
+		t.Fatalf("expected next ID %d after second compaction got %d", 3, m.nextID)
+	}
+}
+
+func TestJSONLNextID(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	now := time.Now()
+
+	// log order need not follow ID order
+	for _, id := range []int{80, 35, 79} {
+		res := &Reservation{
+			ID:       id,
+			Resource: "resource",
+			Start:    now.Add(time.Duration(id) * time.Hour),
+			End:      now.Add(time.Duration(id+1) * time.Hour),
+		}
+
+		err = js.Add(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := NewMemory(js, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := &Reservation{
+		Resource: "resource",
+		Start:    now.Add(time.Hour),
+		End:      now.Add(2 * time.Hour),
+	}
+
+	err = m.Add(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.ID != 81 {
+		t.Fatalf("expected ID %d got %d", 81, res.ID)
+	}
+
+	empty := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = (&jsonl{filename: os.DevNull}).ReadLog(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if empty.nextID != 0 {
+		t.Fatalf("expected ID %d for empty log got %d", 0, empty.nextID)
+	}
+}
+
+func TestJSONLTolerant(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	err = js.Add(&Reservation{ID: 1, Resource: "resource A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a partial append from a crash
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"add","id":2,"res":{"id":2,"reso` + "\n")
+	file.WriteString(`{"op":"add","id":3}` + "\n")
+	file.Close()
+
+	err = js.Add(&Reservation{ID: 4, Resource: "resource B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = js.ReadLog(m)
+	if err == nil {
+		t.Fatal("expected strict read to fail")
+	}
+
+	js.tolerant = true
+
+	m = &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err = js.ReadLog(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.reservations) != 2 {
+		t.Fatalf("expected %d reservations got %d", 2, len(m.reservations))
+	}
+
+	if m.reservations[0].ID != 1 || m.reservations[1].ID != 4 {
+		t.Fatalf("expected reservations 1 and 4 got %d and %d", m.reservations[0].ID, m.reservations[1].ID)
+	}
+
+	if m.nextID != 5 {
+		t.Fatalf("expected next ID %d got %d", 5, m.nextID)
+	}
+}
+
+func TestJSONLHistory(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	res := &Reservation{ID: 56, Resource: "resource"}
+
+	js.Add(res)
+	js.Add(&Reservation{ID: 57, Resource: "resource"})
+	js.Update(res.ID, res)
+	js.Delete(57)
+	js.Delete(res.ID)
+
+	hist, err := js.History(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hist) != 3 {
+		t.Fatalf("expected %d history entries got %d", 3, len(hist))
+	}
+
+	for i, exp := range []string{"add", "modify", "delete"} {
+		if hist[i].Operation != exp {
+			t.Fatalf("expected entry %d \"%s\" got \"%s\"", i, exp, hist[i].Operation)
+		}
+	}
+
+	hist, err = js.History(99)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hist) != 0 {
+		t.Fatalf("expected no history got %d entries", len(hist))
+	}
+}
@@ -3,15 +3,38 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
+	"time"
 )
 
+// "text" dumps failed requests and responses, "json" writes one object
+// per request for log aggregators
+var logFormat = "text"
+
+type logEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Duration  float64   `json:"durationMs"`
+	Bytes     int       `json:"bytes"`
+	Remote    string    `json:"remoteAddr"`
+	UserAgent string    `json:"userAgent"`
+}
+
 func logger(next http.Handler) http.Handler {
+	if logFormat == "json" {
+		return jsonLogger(next)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
 		if r.Method != http.MethodGet {
 			log.Printf("[%s] Path => %s User agent => %s Remote addr => %s", r.Method, r.URL.Path, r.UserAgent(), r.RemoteAddr)
 		}
@@ -25,6 +48,8 @@ func logger(next http.Handler) http.Handler {
 		response := httptest.NewRecorder()
 		next.ServeHTTP(response, r)
 
+		requestMetrics.observe(r.Method, response.Code, time.Since(start))
+
 		if response.Code >= http.StatusBadRequest {
 			log.Println(string(request))
 
@@ -43,3 +68,37 @@ func logger(next http.Handler) http.Handler {
 		response.Body.WriteTo(w)
 	})
 }
+
+func jsonLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		response := httptest.NewRecorder()
+		next.ServeHTTP(response, r)
+
+		requestMetrics.observe(r.Method, response.Code, time.Since(start))
+
+		entry := &logEntry{
+			Time:      start,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    response.Code,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:     response.Body.Len(),
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		}
+
+		b, err := json.Marshal(entry)
+		if err == nil {
+			// bypass the log prefix to keep each line valid JSON
+			log.Writer().Write(append(b, '\n'))
+		}
+
+		for k, v := range response.HeaderMap {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(response.Code)
+		response.Body.WriteTo(w)
+	})
+}
@@ -6,8 +6,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -67,3 +69,40 @@ func TestLoggerRequestBody(t *testing.T) {
 	handler := logger(&logtest{code: http.StatusNotFound, content: "application/json"})
 	handler.ServeHTTP(w, r)
 }
+
+func TestLoggerFormatJSON(t *testing.T) {
+	logFormat = "json"
+	defer func() {
+		logFormat = "text"
+	}()
+
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	r, _ := http.NewRequest(http.MethodPost, "path/to/file", nil)
+	r.Header.Set("User-Agent", "test")
+	r.RemoteAddr = "123.456.789.012"
+	w := httptest.NewRecorder()
+	handler := logger(&logtest{code: http.StatusNotFound, content: "application/json"})
+	handler.ServeHTTP(w, r)
+
+	var entry logEntry
+
+	err := json.Unmarshal(b.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("invalid JSON log \"%s\": %v", b.String(), err)
+	}
+
+	if entry.Method != http.MethodPost || entry.Path != "path/to/file" || entry.Status != http.StatusNotFound {
+		t.Fatalf("unexpected log entry %+v", entry)
+	}
+
+	if entry.Bytes != len("response text") || entry.UserAgent != "test" || entry.Remote != "123.456.789.012" {
+		t.Fatalf("unexpected log entry %+v", entry)
+	}
+
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code %d got %d", http.StatusNotFound, w.Result().StatusCode)
+	}
+}
@@ -15,10 +15,15 @@ import (
 	"net/http"
 	"net/smtp"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	. "github.com/dbulkow/reservations/api"
 	"github.com/google/uuid"
 )
 
@@ -33,33 +38,60 @@ const RegistrationExpire = time.Duration(24) * time.Hour
 type Mail interface {
 	Valid(name string) bool
 	Lookup(name string) (string, error)
+	Verified(email string) bool
+	Notify(name, subject, text string) error
 }
 
 type Email struct {
-	Email  string    `json:"email"`
-	UUID   uuid.UUID `json:"uuid"`   // unique path for validation
-	Expire time.Time `json:"expire"` // when validation expires
-	Valid  bool      `json:"valid"`  // user has responded to validate url
+	Email   string    `json:"email"`
+	Pending string    `json:"pending,omitempty"` // new address awaiting validation
+	UUID    uuid.UUID `json:"uuid"`              // unique path for validation
+	Expire  time.Time `json:"expire"`            // when validation expires
+	Valid   bool      `json:"valid"`             // user has responded to validate url
+	Agreed  bool      `json:"agreed,omitempty"`  // current address agreed to the pending change
+}
+
+// Email holds a validated address while valid or while a change of
+// address is pending validation
+func (em *Email) verified() bool {
+	return em.Valid || em.Pending != ""
 }
 
 type mail struct {
-	names    map[string]*Email
-	filename string
-	server   string // mail server address
-	port     string // mail server port
-	from     string // sender email address
+	names      map[string]*Email
+	filename   string
+	server     string   // mail server address
+	port       string   // mail server port
+	from       string   // sender email address
+	perHost    *limiter // registrations from one client address
+	global     *limiter // registrations from everyone
+	maxPending int      // unvalidated registrations allowed, 0 is unlimited
 	sync.Mutex
 }
 
+// registration limits, generous for people and stingy for scripts
+const (
+	RegistrationsPerHost = 5
+	RegistrationsGlobal  = 100
+	RegistrationsWindow  = time.Hour
+	RegistrationsPending = 1000
+)
+
 var MailNameNotFound = errors.New("name not found")
 
+// same check as the reserve config command, applied after lowercasing
+var validEmail = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+
 func NewMail(filename, server, port, from string) (*mail, error) {
 	m := &mail{
-		names:    make(map[string]*Email),
-		filename: filename,
-		server:   server,
-		port:     port,
-		from:     from,
+		names:      make(map[string]*Email),
+		filename:   filename,
+		server:     server,
+		port:       port,
+		from:       from,
+		perHost:    newLimiter(RegistrationsPerHost, RegistrationsWindow),
+		global:     newLimiter(RegistrationsGlobal, RegistrationsWindow),
+		maxPending: RegistrationsPending,
 	}
 
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
@@ -70,14 +102,13 @@ func NewMail(filename, server, port, from string) (*mail, error) {
 
 	err = m.readfile()
 	if err != nil {
-		if err != io.EOF {
-			return nil, err
-		}
+		return nil, err
 	}
 
 	return m, nil
 }
 
+// a new or empty file holds no registrations
 func (m *mail) readfile() error {
 	if m.filename == "" {
 		return nil
@@ -89,35 +120,59 @@ func (m *mail) readfile() error {
 	}
 	defer file.Close()
 
-	return json.NewDecoder(file).Decode(&m.names)
+	err = json.NewDecoder(file).Decode(&m.names)
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
 }
 
+// write to a uniquely named file beside the original, synced before it
+// replaces the original, so neither a crash nor another writer leaves
+// a partial file behind
 func (m *mail) savefile() error {
 	if m.filename == "" {
 		return nil
 	}
 
-	newfile := m.filename + "-"
+	file, err := ioutil.TempFile(filepath.Dir(m.filename), filepath.Base(m.filename)+"-*")
+	if err != nil {
+		return err
+	}
+	newfile := file.Name()
 
-	file, err := os.Create(newfile)
+	err = m.writefile(file)
 	if err != nil {
+		os.Remove(newfile)
+		return err
+	}
+
+	err = os.Rename(newfile, m.filename)
+	if err != nil {
+		os.Remove(newfile)
 		return err
 	}
-	defer file.Close()
 
+	return nil
+}
+
+func (m *mail) writefile(file *os.File) error {
 	enc := json.NewEncoder(file)
 	enc.SetIndent("", "    ")
-	err = enc.Encode(&m.names)
+	err := enc.Encode(&m.names)
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	err = os.Rename(newfile, m.filename)
+	err = file.Sync()
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	return nil
+	return file.Close()
 }
 
 // look for validated email by name
@@ -126,7 +181,7 @@ func (m *mail) Valid(name string) bool {
 	defer m.Unlock()
 
 	if em, ok := m.names[name]; ok {
-		if em.Valid {
+		if em.verified() {
 			return true
 		}
 	}
@@ -141,7 +196,7 @@ func (m *mail) Lookup(name string) (string, error) {
 	defer m.Unlock()
 
 	if em, ok := m.names[name]; ok {
-		if em.Valid {
+		if em.verified() {
 			return em.Email, nil
 		}
 	}
@@ -149,11 +204,81 @@ func (m *mail) Lookup(name string) (string, error) {
 	return "", MailNameNotFound
 }
 
+// a registration not validated before it expires, a lapsed change of
+// address falls back to the validated address
+func (em *Email) expired(now time.Time) bool {
+	return !em.Valid && now.After(em.Expire)
+}
+
+// report whether the registration claims the address, lapsed
+// registrations and changes of address claim nothing
+func (em *Email) holds(email string, now time.Time) bool {
+	if strings.ToLower(em.Email) == email && (em.verified() || !em.expired(now)) {
+		return true
+	}
+
+	return em.Pending != "" && em.Pending == email && !em.expired(now)
+}
+
+// remove registrations never validated before expiring, reporting
+// whether anything changed
+func (m *mail) reap() (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	reaped := false
+
+	for name, em := range m.names {
+		if !em.expired(now) {
+			continue
+		}
+
+		if em.Pending != "" {
+			log.Printf("email change expired (%s=%s)", name, em.Pending)
+			em.Pending = ""
+			em.Agreed = false
+			em.Valid = true
+		} else {
+			log.Printf("email registration expired (%s=%s)", name, em.Email)
+			delete(m.names, name)
+		}
+
+		reaped = true
+	}
+
+	if !reaped {
+		return false, nil
+	}
+
+	return true, m.savefile()
+}
+
+// report whether any registration has verified the email address
+func (m *mail) Verified(email string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	email = strings.ToLower(email)
+
+	for _, em := range m.names {
+		if em.verified() && strings.ToLower(em.Email) == email {
+			return true
+		}
+	}
+
+	return false
+}
+
 // POST submit name:email
 //      returns status
+// PUT submit name:email for an existing name
+//      a validated address is mailed to agree to the change first, then
+//      the new address is validated - the old address stays in use until
+//      both are done
 // GET
 //      look up by UUID
-//      if found, mark email valid
+//      if found, mark email valid, or the change agreed
 //
 // after RegistrationExpire hours, delete email registration (require new registration)
 
@@ -207,6 +332,47 @@ func (m *mail) rest() http.HandlerFunc {
 		w.Write(b)
 	}
 
+	type registration struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+
+	// read and check a registration, failing the request if malformed
+	request := func(w http.ResponseWriter, r *http.Request) (*registration, bool) {
+		var req registration
+
+		var reader io.Reader
+		var err error
+
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			reader, err = gzip.NewReader(io.LimitReader(r.Body, 65536))
+		default:
+			reader = bufio.NewReader(io.LimitReader(r.Body, 65536))
+		}
+
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			fail(w, "payload read error", http.StatusBadRequest)
+			return nil, false
+		}
+
+		err = json.Unmarshal(b, &req)
+		if err != nil {
+			fail(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+
+		if !validEmail.MatchString(req.Email) {
+			fail(w, "invalid email address", http.StatusBadRequest)
+			return nil, false
+		}
+
+		return &req, true
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -217,6 +383,12 @@ func (m *mail) rest() http.HandlerFunc {
 				return
 			}
 
+			// no uuid lists the registrations
+			if last := parts[len(parts)-1]; last == "" || r.URL.Path == V3mail {
+				m.list(w, r)
+				return
+			}
+
 			id, err := uuid.Parse(parts[len(parts)-1])
 			if err != nil {
 				fail(w, "bad path", http.StatusNotFound)
@@ -253,6 +425,37 @@ func (m *mail) rest() http.HandlerFunc {
 				return
 			}
 
+			// the current address agrees to the change, the new one is
+			// mailed its own link
+			if email.Pending != "" && !email.Agreed {
+				id, err := uuid.NewRandom()
+				if err != nil {
+					fail(w, "internal error", http.StatusInternalServerError)
+					return
+				}
+
+				email.UUID = id
+				email.Expire = time.Now().Add(RegistrationExpire)
+				email.Agreed = true
+				log.Printf("email change agreed (%s=%s)", name, email.Pending)
+
+				m.sendmail(email.Pending, id.String())
+
+				err = m.savefile()
+				if err != nil {
+					log.Printf("mail get: %v", err)
+				}
+
+				serve(w, "agreed.html")
+				return
+			}
+
+			if email.Pending != "" {
+				email.Email = email.Pending
+				email.Pending = ""
+				email.Agreed = false
+			}
+
 			email.Valid = true
 			log.Printf("email verified (%s=%s)", name, email.Email)
 
@@ -264,45 +467,44 @@ func (m *mail) rest() http.HandlerFunc {
 			serve(w, "valid.html")
 
 		case http.MethodPost:
-			var req = struct {
-				Name  string `json:"name"`
-				Email string `json:"email"`
-			}{}
-
-			var reader io.Reader
-			var err error
-
-			switch r.Header.Get("Content-Encoding") {
-			case "gzip":
-				reader, err = gzip.NewReader(io.LimitReader(r.Body, 65536))
-			default:
-				reader = bufio.NewReader(io.LimitReader(r.Body, 65536))
-			}
+			now := time.Now()
 
-			b, err := ioutil.ReadAll(reader)
-			if err != nil {
-				fail(w, "payload read error", http.StatusBadRequest)
+			if !m.perHost.allow(remoteHost(r.RemoteAddr), now) || !m.global.allow("", now) {
+				fail(w, "too many registrations, try again later", http.StatusTooManyRequests)
 				return
 			}
 
-			err = json.Unmarshal(b, &req)
-			if err != nil {
-				fail(w, err.Error(), http.StatusBadRequest)
+			req, ok := request(w, r)
+			if !ok {
 				return
 			}
 
 			m.Lock()
 			defer m.Unlock()
 
+			if m.maxPending > 0 {
+				pending := 0
+				for _, em := range m.names {
+					if !em.verified() && !em.expired(now) {
+						pending++
+					}
+				}
+
+				if pending >= m.maxPending {
+					fail(w, "too many pending registrations, try again later", http.StatusTooManyRequests)
+					return
+				}
+			}
+
 			if em, ok := m.names[req.Name]; ok {
-				if em.Valid {
+				if em.verified() {
 					fail(w, "name already registered", http.StatusConflict)
 					return
 				}
 			}
 
 			for _, em := range m.names {
-				if em.Email == req.Email {
+				if em.holds(req.Email, now) {
 					fail(w, "email already registered", http.StatusConflict)
 					return
 				}
@@ -317,7 +519,7 @@ func (m *mail) rest() http.HandlerFunc {
 			m.names[req.Name] = &Email{
 				Email:  req.Email,
 				UUID:   id,
-				Expire: time.Now().Add(RegistrationExpire),
+				Expire: now.Add(RegistrationExpire),
 			}
 
 			m.sendmail(req.Email, id.String())
@@ -330,11 +532,74 @@ func (m *mail) rest() http.HandlerFunc {
 			success(w)
 
 		case http.MethodPut:
-			// allow email updates?
-			// how to close the loop - send an email to old address first?
-			// send email to new address, delete old one after verified?
-			// need to avoid users changing email for others
-			// would like this to remain self-service
+			now := time.Now()
+
+			// a change mails out as a registration does
+			if !m.perHost.allow(remoteHost(r.RemoteAddr), now) || !m.global.allow("", now) {
+				fail(w, "too many registrations, try again later", http.StatusTooManyRequests)
+				return
+			}
+
+			req, ok := request(w, r)
+			if !ok {
+				return
+			}
+
+			m.Lock()
+			defer m.Unlock()
+
+			email, ok := m.names[req.Name]
+			if !ok {
+				fail(w, MailNameNotFound.Error(), http.StatusNotFound)
+				return
+			}
+
+			for n, em := range m.names {
+				if n == req.Name {
+					continue
+				}
+
+				if em.holds(req.Email, now) {
+					fail(w, "email already registered", http.StatusConflict)
+					return
+				}
+			}
+
+			id, err := uuid.NewRandom()
+			if err != nil {
+				fail(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+
+			// a validated address stays in use until it agrees to the
+			// change and the new one is validated, an unvalidated one is
+			// simply replaced
+			verified := email.verified()
+			if verified {
+				email.Pending = req.Email
+				email.Agreed = false
+			} else {
+				email.Email = req.Email
+			}
+
+			email.UUID = id
+			email.Expire = now.Add(RegistrationExpire)
+			email.Valid = false
+
+			log.Printf("email change requested (%s=%s)", req.Name, req.Email)
+
+			if verified {
+				m.sendchange(email.Email, req.Email, id.String())
+			} else {
+				m.sendmail(req.Email, id.String())
+			}
+
+			err = m.savefile()
+			if err != nil {
+				log.Printf("mail put: %v", err)
+			}
+
+			success(w)
 
 		default:
 			http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
@@ -343,6 +608,108 @@ func (m *mail) rest() http.HandlerFunc {
 	}
 }
 
+// a registration as listed, the address only shown to admins
+type registrationEntry struct {
+	Name    string    `json:"name"`
+	Email   string    `json:"email,omitempty"`
+	Pending string    `json:"pending,omitempty"`
+	Valid   bool      `json:"valid"`
+	Expire  time.Time `json:"expire"`
+}
+
+// list registrations by name - valid=true/false filters on a validated
+// address being in use, as for Valid, so a pending change still counts,
+// and start/limit select a page, admins only when ownership is checked
+func (m *mail) list(w http.ResponseWriter, r *http.Request) {
+	admin := admins[r.Header.Get(UserHeader)]
+
+	if checkOwner && !admin {
+		v3error(w, "registrations restricted to admins", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+
+	var valid *bool
+	switch q.Get("valid") {
+	case "":
+	case "true", "false":
+		v := q.Get("valid") == "true"
+		valid = &v
+	default:
+		v3error(w, fmt.Sprintf("valid \"%s\" not true or false", q.Get("valid")), http.StatusBadRequest)
+		return
+	}
+
+	start, err := strconv.Atoi(q.Get("start"))
+	if err != nil || start < 0 {
+		start = 0
+	}
+
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit < 0 {
+		limit = 0
+	}
+
+	m.Lock()
+
+	entries := make([]*registrationEntry, 0, len(m.names))
+
+	for name, em := range m.names {
+		if valid != nil && em.verified() != *valid {
+			continue
+		}
+
+		e := &registrationEntry{
+			Name:   name,
+			Valid:  em.verified(),
+			Expire: em.Expire,
+		}
+
+		if admin {
+			e.Email = em.Email
+			e.Pending = em.Pending
+		}
+
+		entries = append(entries, e)
+	}
+
+	m.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	total := len(entries)
+
+	if start > len(entries) {
+		start = len(entries)
+	}
+	entries = entries[start:]
+
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+
+	reply := struct {
+		Status        string               `json:"status"`
+		Total         int                  `json:"total"`
+		Registrations []*registrationEntry `json:"registrations"`
+	}{
+		Status:        "Success",
+		Total:         total,
+		Registrations: entries,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
 func (m *mail) sendmail(target, uuid string) error {
 	if m.server == "" {
 		return nil
@@ -360,6 +727,48 @@ Please vist the following URL to verify:\r
     https://reservations.company.com/mail/%s\r
 `, target, uuid)
 
+	return m.send(target, body)
+}
+
+// ask the validated address to agree to a change to another address
+func (m *mail) sendchange(target, pending, uuid string) error {
+	if m.server == "" {
+		return nil
+	}
+
+	body := fmt.Sprintf(`To: %s\r
+Subject: Please confirm your change of email address\r
+\r
+Someone has asked to change the email address registered for you in\r
+the reservation service to %s. If you did not ask for this, please\r
+ignore this mail and your address will not be changed.\r
+\r
+Please vist the following URL to agree to the change:\r
+\r
+    https://reservations.company.com/mail/%s\r
+`, target, pending, uuid)
+
+	return m.send(target, body)
+}
+
+// mail a notice to the validated address registered for name, names
+// without a validated address are skipped
+func (m *mail) Notify(name, subject, text string) error {
+	if m.server == "" {
+		return nil
+	}
+
+	target, err := m.Lookup(name)
+	if err != nil {
+		return nil
+	}
+
+	body := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", target, subject, strings.ReplaceAll(text, "\n", "\r\n"))
+
+	return m.send(target, body)
+}
+
+func (m *mail) send(target, body string) error {
 	c, err := smtp.Dial(net.JoinHostPort(m.server, m.port))
 	if err != nil {
 		return err
@@ -11,14 +11,19 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
 )
 
 func mkmail() *mail {
 	return &mail{
 		names: map[string]*Email{
 			"Some User": &Email{
-				Email: "some.user@company.com",
+				Email:  "some.user@company.com",
+				Expire: time.Now().Add(RegistrationExpire),
 			},
 			"Another User": &Email{
 				Email: "another.user@company.com",
@@ -124,6 +129,297 @@ func TestMailRest(t *testing.T) {
 	}
 }
 
+func TestMailRestEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		email  string
+		status int
+	}{
+		{"Third User", "third.user@", http.StatusBadRequest},
+		{"Third User", "third user@company.com", http.StatusBadRequest},
+		{"Third User", "Some.User@Company.COM", http.StatusConflict},
+		{"Third User", "Third.User@Company.com", http.StatusCreated},
+	}
+
+	m := mkmail()
+	handler := m.rest()
+
+	for _, tc := range tests {
+		req, _ := json.Marshal(map[string]string{"name": tc.name, "email": tc.email})
+
+		r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBuffer(req))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		out, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Println(string(out))
+
+		if resp.StatusCode != tc.status {
+			t.Fatalf("expected status code %d for \"%s\" got %d", tc.status, tc.email, resp.StatusCode)
+		}
+	}
+
+	if m.names["Third User"].Email != "third.user@company.com" {
+		t.Fatalf("expected normalized email got \"%s\"", m.names["Third User"].Email)
+	}
+}
+
+func TestMailRestChange(t *testing.T) {
+	m := mkmail()
+	handler := m.rest()
+
+	put := func(name, email string) int {
+		req, _ := json.Marshal(map[string]string{"name": name, "email": email})
+
+		r, _ := http.NewRequest(http.MethodPut, "", bytes.NewBuffer(req))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	if status := put("Missing User", "missing.user@company.com"); status != http.StatusNotFound {
+		t.Fatalf("expected status code %d for unknown name got %d", http.StatusNotFound, status)
+	}
+
+	if status := put("Another User", "some.user@company.com"); status != http.StatusConflict {
+		t.Fatalf("expected status code %d for taken address got %d", http.StatusConflict, status)
+	}
+
+	if status := put("Another User", "Another.Person@Company.com"); status != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d", http.StatusCreated, status)
+	}
+
+	em := m.names["Another User"]
+
+	if em.Pending != "another.person@company.com" {
+		t.Fatalf("expected pending address got \"%s\"", em.Pending)
+	}
+
+	// old address remains in use until the new one is validated
+	email, err := m.Lookup("Another User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email != "another.user@company.com" {
+		t.Fatalf("expected old address got \"%s\"", email)
+	}
+
+	if !m.Valid("Another User") || !m.Verified("another.user@company.com") {
+		t.Fatal("expected old address valid")
+	}
+
+	if m.Verified("another.person@company.com") {
+		t.Fatal("expected new address not yet valid")
+	}
+
+	// the old address agrees to the change before the new is validated
+	agree := em.UUID
+
+	r, _ := http.NewRequest(http.MethodGet, agree.String(), nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if !em.Agreed || em.UUID == agree {
+		t.Fatalf("expected change agreed with a new link, agreed %v", em.Agreed)
+	}
+
+	email, err = m.Lookup("Another User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email != "another.user@company.com" {
+		t.Fatalf("expected old address until the new is validated got \"%s\"", email)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, em.UUID.String(), nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	email, err = m.Lookup("Another User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if email != "another.person@company.com" || em.Pending != "" {
+		t.Fatalf("expected new address got \"%s\" pending \"%s\"", email, em.Pending)
+	}
+
+	if m.Verified("another.user@company.com") {
+		t.Fatal("expected old address no longer valid")
+	}
+
+	if em.Agreed {
+		t.Fatal("expected agreement cleared once the change is done")
+	}
+}
+
+func TestMailReap(t *testing.T) {
+	m := mkmail()
+	m.names["Some User"].Expire = time.Now().Add(-time.Hour)
+	m.names["Another User"].Expire = time.Now().Add(-time.Hour)
+
+	reaped, err := m.reap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reaped {
+		t.Fatal("expected registration reaped")
+	}
+
+	if _, ok := m.names["Some User"]; ok {
+		t.Fatal("expected expired registration removed")
+	}
+
+	if _, ok := m.names["Another User"]; !ok {
+		t.Fatal("expected validated registration kept")
+	}
+
+	req, _ := json.Marshal(map[string]string{"name": "Some User", "email": "some.user@company.com"})
+
+	r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBuffer(req))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	m.rest()(w, r)
+
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+}
+
+func TestMailRestExpired(t *testing.T) {
+	m := mkmail()
+	m.names["Some User"].Expire = time.Now().Add(-time.Hour)
+
+	// the lapsed registration no longer holds the address
+	req, _ := json.Marshal(map[string]string{"name": "Third User", "email": "some.user@company.com"})
+
+	r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBuffer(req))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	m.rest()(w, r)
+
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+}
+
+func TestMailRestThrottle(t *testing.T) {
+	register := func(m *mail, name, remote string) int {
+		req, _ := json.Marshal(map[string]string{"name": name, "email": name + "@company.com"})
+
+		r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBuffer(req))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		m.rest()(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	t.Run("host", func(t *testing.T) {
+		m := mkmail()
+		m.perHost = newLimiter(2, time.Hour)
+
+		for i, name := range []string{"user1", "user2"} {
+			if code := register(m, name, "10.0.0.1:1234"); code != http.StatusCreated {
+				t.Fatalf("registration %d: expected status code %d got %d", i, http.StatusCreated, code)
+			}
+		}
+
+		if code := register(m, "user3", "10.0.0.1:5678"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, code)
+		}
+
+		if code := register(m, "user4", "10.0.0.2:1234"); code != http.StatusCreated {
+			t.Fatalf("other host: expected status code %d got %d", http.StatusCreated, code)
+		}
+	})
+
+	t.Run("global", func(t *testing.T) {
+		m := mkmail()
+		m.global = newLimiter(1, time.Hour)
+
+		if code := register(m, "user1", "10.0.0.1:1234"); code != http.StatusCreated {
+			t.Fatalf("expected status code %d got %d", http.StatusCreated, code)
+		}
+
+		if code := register(m, "user2", "10.0.0.2:1234"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, code)
+		}
+	})
+
+	t.Run("change", func(t *testing.T) {
+		m := mkmail()
+		m.perHost = newLimiter(1, time.Hour)
+
+		change := func(email string) int {
+			req, _ := json.Marshal(map[string]string{"name": "Another User", "email": email})
+
+			r, _ := http.NewRequest(http.MethodPut, "", bytes.NewBuffer(req))
+			r.Header.Set("Content-Type", "application/json")
+			r.RemoteAddr = "10.0.0.1:1234"
+			w := httptest.NewRecorder()
+			m.rest()(w, r)
+
+			return w.Result().StatusCode
+		}
+
+		if code := change("another.person@company.com"); code != http.StatusCreated {
+			t.Fatalf("expected status code %d got %d", http.StatusCreated, code)
+		}
+
+		if code := change("yet.another@company.com"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, code)
+		}
+
+		if code := register(m, "user1", "10.0.0.1:5678"); code != http.StatusTooManyRequests {
+			t.Fatalf("registration: expected status code %d got %d", http.StatusTooManyRequests, code)
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		m := mkmail()
+		m.maxPending = 2 // "Some User" is already pending
+
+		if code := register(m, "user1", "10.0.0.1:1234"); code != http.StatusCreated {
+			t.Fatalf("expected status code %d got %d", http.StatusCreated, code)
+		}
+
+		if code := register(m, "user2", "10.0.0.2:1234"); code != http.StatusTooManyRequests {
+			t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, code)
+		}
+	})
+}
+
+func TestLimiterWindow(t *testing.T) {
+	l := newLimiter(1, time.Minute)
+	now := time.Now()
+
+	if !l.allow("a", now) {
+		t.Fatal("first request refused")
+	}
+
+	if l.allow("a", now.Add(time.Second)) {
+		t.Fatal("second request allowed within window")
+	}
+
+	if !l.allow("a", now.Add(time.Minute)) {
+		t.Fatal("request refused in new window")
+	}
+}
+
 func TestMailSaveRestore(t *testing.T) {
 	m := mkmail()
 	m.filename = "mail_test.json"
@@ -166,3 +462,160 @@ func TestMailSaveRestore(t *testing.T) {
 		t.Fatalf("expected \"%s\" got \"%s\"", exp, m.names["Third User"].Email)
 	}
 }
+
+func TestMailRestList(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	m := mkmail()
+	m.names["Third User"] = &Email{Email: "third.user@company.com", Valid: true}
+	m.names["Fourth User"] = &Email{Email: "fourth.user@company.com", Pending: "fourth.person@company.com"}
+	handler := m.rest()
+
+	type entry struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Valid bool   `json:"valid"`
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		user   string
+		status int
+		names  []string
+		total  int
+	}{
+		{name: "not admin", user: "Some User", status: http.StatusForbidden},
+		{name: "all", user: "Admin User", status: http.StatusOK, names: []string{"Another User", "Fourth User", "Some User", "Third User"}, total: 4},
+		{name: "valid", query: "?valid=true", user: "Admin User", status: http.StatusOK, names: []string{"Another User", "Fourth User", "Third User"}, total: 3},
+		{name: "not valid", query: "?valid=false", user: "Admin User", status: http.StatusOK, names: []string{"Some User"}, total: 1},
+		{name: "page", query: "?start=2&limit=1", user: "Admin User", status: http.StatusOK, names: []string{"Some User"}, total: 4},
+		{name: "past the end", query: "?start=5", user: "Admin User", status: http.StatusOK, names: []string{}, total: 4},
+		{name: "bad filter", query: "?valid=maybe", user: "Admin User", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, V3mail+tc.query, nil)
+			r.Header.Set(UserHeader, tc.user)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %s", tc.status, resp.Status)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			reply := struct {
+				Status        string   `json:"status"`
+				Total         int      `json:"total"`
+				Registrations []*entry `json:"registrations"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Total != tc.total {
+				t.Fatalf("expected total %d got %d", tc.total, reply.Total)
+			}
+
+			if len(reply.Registrations) != len(tc.names) {
+				t.Fatalf("expected %v got %d registrations", tc.names, len(reply.Registrations))
+			}
+
+			for i, e := range reply.Registrations {
+				if e.Name != tc.names[i] {
+					t.Fatalf("expected %v got %s at %d", tc.names, e.Name, i)
+				}
+
+				if e.Email != m.names[e.Name].Email {
+					t.Fatalf("expected email %s got %s", m.names[e.Name].Email, e.Email)
+				}
+
+				if e.Valid != m.Valid(e.Name) {
+					t.Fatalf("%s: expected valid %t as for Valid got %t", e.Name, m.Valid(e.Name), e.Valid)
+				}
+			}
+		})
+	}
+}
+
+func TestMailRestListAnonymous(t *testing.T) {
+	handler := mkmail().rest()
+
+	r, _ := http.NewRequest(http.MethodGet, V3mail+"/", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if bytes.Contains(b, []byte("@company.com")) {
+		t.Fatalf("addresses listed to a non-admin: %s", b)
+	}
+
+	if !bytes.Contains(b, []byte(`"name":"Some User"`)) {
+		t.Fatalf("expected registrations got %s", b)
+	}
+}
+
+func TestMailEmptyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "mail.json")
+
+	err = ioutil.WriteFile(filename, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMail(filename, "", "", "")
+	if err != nil {
+		t.Fatalf("new mail on empty file: %v", err)
+	}
+
+	m.names["Some User"] = &Email{Email: "some.user@company.com", Valid: true}
+
+	err = m.savefile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "mail.json" {
+		t.Fatalf("expected mail.json alone, temporary file left behind: %d files", len(files))
+	}
+
+	m, err = NewMail(filename, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.Valid("Some User") {
+		t.Fatal("saved registration not read back")
+	}
+}
@@ -3,8 +3,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,7 +21,9 @@ type BackingStore interface {
 	Add(*Reservation) error
 	Update(int, *Reservation) error
 	Delete(int) error
+	Restore(*Reservation) error
 	ReadLog(*memory) error
+	History(int) ([]*HistoryEntry, error)
 }
 
 type memory struct {
@@ -23,20 +31,93 @@ type memory struct {
 	reservations []*Reservation
 	store        BackingStore
 	mail         Mail
+	blackouts    Blackouts
+	generation   uint64          // bumped on every change
+	modified     time.Time       // time of the last change
+	maxQueued    map[string]int  // per resource limit on future reservations
+	shared       map[string]bool // resources shared unless a request says not
+	holds        []*hold         // unconfirmed reservations blocking a slot
+	tombstones   []*tombstone    // deleted reservations that may be restored
+	casefold     bool            // resource names match regardless of case
+	maxDuration  time.Duration   // longest reservation, loans exempt, 0 is unlimited
+	minFlexible  time.Duration   // shortest a flexible reservation is trimmed to
+	undoWindow   time.Duration   // deleted future reservations restorable for, 0 for never
 	sync.Mutex
 }
 
+type hold struct {
+	res    *Reservation
+	expire time.Time
+}
+
+type tombstone struct {
+	res     *Reservation
+	deleted time.Time
+}
+
+// the end of an open-ended reservation, after any real end - it blocks
+// every later reservation and stays current until ended
+var openEnd = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
 type nonstore struct{}
 
 func (s *nonstore) Add(*Reservation) error         { return nil }
 func (s *nonstore) Update(int, *Reservation) error { return nil }
 func (s *nonstore) Delete(int) error               { return nil }
+func (s *nonstore) Restore(*Reservation) error     { return nil }
 func (s *nonstore) ReadLog(*memory) error          { return nil }
 
-func NewMemory(store BackingStore, mail Mail) (*memory, error) {
+func (s *nonstore) History(int) ([]*HistoryEntry, error) { return make([]*HistoryEntry, 0), nil }
+
+// backing store calls taking longer than threshold are logged and
+// counted, a stalling disk otherwise shows only as slow requests
+type timedStore struct {
+	store     BackingStore
+	threshold time.Duration
+}
+
+func (s *timedStore) Add(res *Reservation) error {
+	defer s.time("add", time.Now())
+	return s.store.Add(res)
+}
+
+func (s *timedStore) Update(ref int, res *Reservation) error {
+	defer s.time("update", time.Now())
+	return s.store.Update(ref, res)
+}
+
+func (s *timedStore) Delete(ref int) error {
+	defer s.time("delete", time.Now())
+	return s.store.Delete(ref)
+}
+
+func (s *timedStore) Restore(res *Reservation) error {
+	defer s.time("restore", time.Now())
+	return s.store.Restore(res)
+}
+
+// replay reads the whole log and is expected to take a while
+func (s *timedStore) ReadLog(m *memory) error {
+	return s.store.ReadLog(m)
+}
+
+// as is a history, which replays the log too
+func (s *timedStore) History(ref int) ([]*HistoryEntry, error) {
+	return s.store.History(ref)
+}
+
+func (s *timedStore) time(op string, start time.Time) {
+	if d := time.Since(start); d > s.threshold {
+		log.Printf("slow store %s took %s", op, d)
+		requestMetrics.slowStore(op)
+	}
+}
+
+func NewMemory(store BackingStore, mail Mail, blackouts Blackouts) (*memory, error) {
 	m := &memory{
 		reservations: make([]*Reservation, 0),
 		mail:         mail,
+		blackouts:    blackouts,
 	}
 
 	if store == nil {
@@ -45,14 +126,182 @@ func NewMemory(store BackingStore, mail Mail) (*memory, error) {
 		m.store = store
 	}
 
-	err := store.ReadLog(m)
+	err := m.store.ReadLog(m)
 	if err != nil {
 		return nil, err
 	}
 
+	// new IDs follow the highest restored, an empty log starts at zero
+	for _, res := range m.reservations {
+		if res.ID >= m.nextID {
+			m.nextID = res.ID + 1
+		}
+	}
+
 	return m, nil
 }
 
+// record a change to the store, called with the lock held - deletions
+// leave no row behind so list freshness can't come from rows alone
+func (m *memory) touch() {
+	m.generation++
+	m.modified = time.Now()
+}
+
+// a list without a snapshot sees every reservation
+const noSnapshot = -1
+
+// the next reservation ID to be handed out, marking a point in time that
+// later pages of a list can be held to - reservations from the snapshot
+// on are left out
+func (m *memory) Snapshot() int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.nextID
+}
+
+// report the store generation and the time it last changed
+func (m *memory) Generation() (uint64, time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.generation, m.modified
+}
+
+// determine if the reservation falls in a resource blackout window
+func (m *memory) blackout(res *Reservation) error {
+	if m.blackouts == nil {
+		return nil
+	}
+
+	if w, ok := m.blackouts.Blocked(res, m.sameResource); ok {
+		if w.Reason != "" {
+			return fmt.Errorf("resource in blackout %s-%s (%s)", w.Start, w.End, w.Reason)
+		}
+		return fmt.Errorf("resource in blackout %s-%s", w.Start, w.End)
+	}
+
+	return nil
+}
+
+// loans have no end and are exempt
+func (m *memory) tooLong(res *Reservation) error {
+	if m.maxDuration > 0 && !res.Loan && !res.OpenEnded && res.End.Sub(res.Start) > m.maxDuration {
+		return fmt.Errorf("reservation exceeds maximum duration %s", m.maxDuration)
+	}
+
+	return nil
+}
+
+// most links a reservation may carry
+const MaxLinks = 8
+
+// links must be absolute URLs, e.g. a ticket or runbook
+func checkLinks(links []string) error {
+	if len(links) > MaxLinks {
+		return fmt.Errorf("too many links, maximum %d", MaxLinks)
+	}
+
+	for _, l := range links {
+		u, err := url.Parse(l)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid link \"%s\"", l)
+		}
+	}
+
+	return nil
+}
+
+// most tags a reservation may carry, and the longest tag
+const (
+	MaxTags   = 8
+	MaxTagLen = 32
+)
+
+// tags are single words kept in lower case, duplicates dropped
+func checkTags(res *Reservation) error {
+	if len(res.Tags) > MaxTags {
+		return fmt.Errorf("too many tags, maximum %d", MaxTags)
+	}
+
+	tags := make([]string, 0, len(res.Tags))
+	seen := make(map[string]bool)
+
+	for _, tag := range res.Tags {
+		t := strings.ToLower(strings.TrimSpace(tag))
+		if t == "" || len(t) > MaxTagLen || strings.ContainsAny(t, " \t,") {
+			return fmt.Errorf("invalid tag \"%s\"", tag)
+		}
+
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+
+	if len(tags) == 0 {
+		tags = nil
+	}
+
+	res.Tags = tags
+
+	return nil
+}
+
+// whether res carries tag, regardless of case
+func hasTag(res *Reservation, tag string) bool {
+	for _, t := range res.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+const MaxInitials = 3
+
+// initials are 1 to MaxInitials letters, kept in upper case - none at
+// all is fine too
+func checkInitials(res *Reservation) error {
+	if len(res.Initials) > MaxInitials {
+		return fmt.Errorf("invalid initials \"%s\", at most %d letters", res.Initials, MaxInitials)
+	}
+
+	for _, c := range res.Initials {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return fmt.Errorf("invalid initials \"%s\", letters only", res.Initials)
+		}
+	}
+
+	res.Initials = strings.ToUpper(res.Initials)
+
+	return nil
+}
+
+// resource names as compared, the stored name keeps its casing
+func (m *memory) resourceKey(resource string) string {
+	if m.casefold {
+		return strings.ToLower(resource)
+	}
+	return resource
+}
+
+func (m *memory) sameResource(a, b string) bool {
+	return m.resourceKey(a) == m.resourceKey(b)
+}
+
+// reservations carrying a verified owner email are matched on it when the
+// caller has one, otherwise on display name
+func owns(res *Reservation, name, owner string) bool {
+	if res.Owner != "" && owner != "" {
+		return strings.EqualFold(res.Owner, owner)
+	}
+
+	return name == res.Name
+}
+
 // determine if the two reservation time ranges overlap each other
 func (m *memory) overlap(s, r *Reservation) bool {
 	return s.Start.Before(r.End) && s.End.After(r.Start)
@@ -75,7 +324,65 @@ func (m *memory) GetById(resid int) (*Reservation, error) {
 	return nil, errors.New("reservation not found")
 }
 
-func (m *memory) List(resource, show string, start, length int) ([]*Reservation, error) {
+// determine if a reservation is included in the requested view
+func (m *memory) shown(res *Reservation, show string, now time.Time) bool {
+	switch show {
+	case "current": // active reservations
+		// in the future or in the past and not on loan
+		if now.Before(res.Start) || (now.After(res.End) && res.Loan == false) {
+			return false
+		}
+
+	case "history": // expired reservations
+		if now.Before(res.End) || res.Loan {
+			return false
+		}
+
+	case "all": // everything
+
+	case "loans": // on loan, which never expire
+		if !res.Loan {
+			return false
+		}
+
+	case "upcoming": // yet to start, loans included
+		if now.After(res.Start) {
+			return false
+		}
+
+	case "active": // active and future reservations
+		fallthrough
+	default:
+		if now.After(res.End) && res.Loan == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+// determine if a reservation intersects the window [from,to), a zero
+// time leaves that side open and loans have no end
+func (m *memory) inWindow(res *Reservation, from, to time.Time) bool {
+	if !to.IsZero() && !res.Start.Before(to) {
+		return false
+	}
+
+	if !from.IsZero() && !res.Loan && !res.End.After(from) {
+		return false
+	}
+
+	return true
+}
+
+func (m *memory) List(resource, show, initials, tag string, start, length int) ([]*Reservation, error) {
+	return m.ListRange(resource, show, initials, tag, time.Time{}, time.Time{}, start, length, noSnapshot)
+}
+
+// list reservations intersecting the window from/to - a snapshot, unless
+// noSnapshot, leaves out reservations added after it was taken, and initials
+// or tag, when set, keep only reservations carrying them
+func (m *memory) ListRange(resource, show, initials, tag string, from, to time.Time, start, length, snapshot int) ([]*Reservation, error) {
 	m.Lock()
 	defer m.Unlock()
 
@@ -83,8 +390,13 @@ func (m *memory) List(resource, show string, start, length int) ([]*Reservation,
 
 	now := time.Now()
 
-	for _, res := range m.reservations {
-		if resource != "" && res.Resource != resource {
+	// pages are cut in ID order, whatever order the slice has settled in
+	byid := make([]*Reservation, len(m.reservations))
+	copy(byid, m.reservations)
+	sort.Sort(ByID(byid))
+
+	for _, res := range byid {
+		if resource != "" && !m.sameResource(res.Resource, resource) {
 			continue
 		}
 
@@ -92,30 +404,28 @@ func (m *memory) List(resource, show string, start, length int) ([]*Reservation,
 			continue
 		}
 
+		if snapshot != noSnapshot && res.ID >= snapshot {
+			continue
+		}
+
 		if length > 0 && len(response) >= length {
 			continue
 		}
 
-		switch show {
-		case "current": // active reservations
-			// in the future or in the past and not on loan
-			if now.Before(res.Start) || (now.After(res.End) && res.Loan == false) {
-				continue
-			}
+		if !m.shown(res, show, now) {
+			continue
+		}
 
-		case "history": // expired reservations
-			if now.Before(res.End) || res.Loan {
-				continue
-			}
+		if !m.inWindow(res, from, to) {
+			continue
+		}
 
-		case "all": // everything
+		if initials != "" && !strings.EqualFold(res.Initials, initials) {
+			continue
+		}
 
-		case "active": // active and future reservations
-			fallthrough
-		default:
-			if now.After(res.End) && res.Loan == false {
-				continue
-			}
+		if tag != "" && !hasTag(res, tag) {
+			continue
 		}
 
 		// string is empty on error, which is what we want
@@ -127,151 +437,656 @@ func (m *memory) List(resource, show string, start, length int) ([]*Reservation,
 	return response, nil
 }
 
-// add new reservation - no overlaps allowed
+// add new reservation - no overlaps allowed, though flexible
+// reservations may be shortened to make room
 func (m *memory) Add(res *Reservation) error {
 	m.Lock()
-	defer m.Unlock()
+	trimmed, err := m.add(res, time.Now())
+	m.Unlock()
+
+	// owners are mailed outside the lock
+	for _, t := range trimmed {
+		text := fmt.Sprintf("Flexible reservation %s was shortened to make room for reservation %s.", t, res)
 
+		err := m.mail.Notify(t.Name, "Flexible reservation shortened", text)
+		if err != nil {
+			log.Printf("notify %s: %v", t.Name, err)
+		}
+	}
+
+	return err
+}
+
+// called with the lock held, returns the flexible reservations trimmed
+func (m *memory) add(res *Reservation, now time.Time) ([]*Reservation, error) {
 	// let's not be so restrictive - maybe limit unregistered user to short reservations (no loans)
 	// if m.mail.Valid(res.Name) == false {
 	// 	return errors.New("unknown name")
 	// }
 
-	for _, r := range m.reservations {
-		if r.Resource != res.Resource {
-			continue
-		}
+	trimmed, _, err := m.checkAdd(res, now)
+	if err != nil {
+		return nil, err
+	}
 
-		if r.Loan {
-			return errors.New("resource on loan")
-		}
+	for _, t := range trimmed {
+		t.LastModified = now.Round(time.Second)
 
-		if m.overlap(r, res) {
-			return errors.New("reservation range conflict")
+		err = m.store.Update(t.ID, t)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	res.ID = m.nextID
-	res.Email = ""
-	res.LastModified = time.Now().Round(time.Second)
 
-	if res.Loan {
-		res.End = res.Start
+		log.Printf("trimmed %s", t)
 	}
 
-	m.nextID++
+	m.assign(res, now)
+
 	m.reservations = append(m.reservations, res)
 
-	err := m.store.Add(res)
+	m.touch()
+
+	err = m.store.Add(res)
 	if err != nil {
-		return err
+		return trimmed, err
 	}
 
 	log.Printf("added %s", res)
 
-	return nil
+	return trimmed, nil
 }
 
-// replace reservation if no overlap
-// don't allow:
-// - update of start or end if active or expired
-// - update of loan if active
-// - update of ID
-// - update if res.LastModified newer than req.LastModified
-func (m *memory) Update(ref int, req *Reservation) (*Reservation, error) {
-	res, err := m.GetById(ref)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.LastModified.After(req.LastModified) {
-		return nil, errors.New("modified")
-	}
-
+// run the checks of Add without storing res, flexible reservations it
+// would trim are left as they were
+func (m *memory) CheckAdd(res *Reservation) error {
 	m.Lock()
 	defer m.Unlock()
 
-	now := time.Now()
+	trimmed, ends, err := m.checkAdd(res, time.Now())
 
-	if res.End.Before(now) && res.Loan == false {
-		return nil, errors.New("already expired")
+	for i, t := range trimmed {
+		t.End = ends[i]
 	}
 
-	// if active - only allow notes, share and end time changes
-	if res.Start.Before(now) {
-		if req.Resource != res.Resource || req.Start != res.Start {
-			return nil, errors.New("already active")
-		}
+	return err
+}
 
-		if res.Loan != req.Loan {
-			return nil, errors.New("converting to/from loan")
+// admit res, trimming flexible reservations to make room, called with
+// the lock held - trims are undone when res is refused, otherwise the
+// trimmed reservations are returned with their original ends
+func (m *memory) checkAdd(res *Reservation, now time.Time) ([]*Reservation, []time.Time, error) {
+	trimmed, ends := m.trimFlexible(res, now)
+
+	err := m.admit(res, now)
+	if err != nil {
+		for i, t := range trimmed {
+			t.End = ends[i]
 		}
+		return nil, nil, err
+	}
 
-		res.LastModified = now.Round(time.Second)
-		res.End = req.End
-		res.Notes = req.Notes
-		res.Share = req.Share
-		res.Name = req.Name
-		res.Initials = req.Initials
-		res.Email = ""
+	return trimmed, ends, nil
+}
 
-		err := m.store.Update(res.ID, res)
-		if err != nil {
-			return nil, err
+// end future flexible reservations overlapping res at its start, so
+// long as each keeps minFlexible - active ones are never touched. The
+// original ends are returned for the caller to restore should res
+// still not fit.
+func (m *memory) trimFlexible(res *Reservation, now time.Time) ([]*Reservation, []time.Time) {
+	var (
+		trimmed []*Reservation
+		ends    []time.Time
+	)
+
+	if res.Loan {
+		return nil, nil
+	}
+
+	for _, r := range m.reservations {
+		if !r.Flexible || r.Loan || r.OpenEnded || !m.sameResource(r.Resource, res.Resource) {
+			continue
 		}
 
-		log.Printf("updated %s", res)
+		if !r.Start.After(now) || !m.overlap(r, res) {
+			continue
+		}
 
-		return res, nil
-	}
+		// only time after the new start is given up
+		if !r.Start.Before(res.Start) || res.Start.Sub(r.Start) < m.minFlexible {
+			continue
+		}
 
-	res.LastModified = now.Round(time.Second)
-	res.Resource = req.Resource
-	res.Start = req.Start
-	res.End = req.End
-	res.Loan = req.Loan
-	res.Share = req.Share
-	res.Notes = req.Notes
-	res.Name = req.Name
-	res.Initials = req.Initials
-	res.Email = ""
+		trimmed = append(trimmed, r)
+		ends = append(ends, r.End)
 
-	err = m.store.Update(res.ID, res)
-	if err != nil {
-		return nil, err
+		r.End = res.Start
 	}
 
-	log.Printf("updated %s", res)
-
-	return res, nil
+	return trimmed, ends
 }
 
-// if reservation start is in the future, just delete it
-// if reservation end is in the past, ignore this request
-// if reservation is active (start < now and (end > now || on loan))
-//    remove loan flag
-//    set end time <= now
-func (m *memory) Delete(ref int, lastmod time.Time) error {
+// add a reservation at its requested time or, when that conflicts, at
+// the earliest time after it the resource is free for as long
+func (m *memory) Waitlist(res *Reservation) error {
 	m.Lock()
 	defer m.Unlock()
 
 	now := time.Now()
 
-	for i, r := range m.reservations {
-		if r.ID != ref {
-			continue
+	start, end := res.Start, res.End
+	length := end.Sub(start)
+
+	// the slot opens as the request or as a blocker ends, an open-ended
+	// blocker has no end to wait for
+	slots := []time.Time{start}
+
+	for _, r := range m.reservations {
+		if m.sameResource(r.Resource, res.Resource) && r.End.After(start) && !r.OpenEnded {
+			slots = append(slots, r.End)
 		}
+	}
 
-		if r.LastModified.After(lastmod) {
-			return errors.New("resource modified")
+	for _, h := range m.holds {
+		if h.expire.After(now) && m.sameResource(h.res.Resource, res.Resource) && h.res.End.After(start) {
+			slots = append(slots, h.res.End)
 		}
+	}
 
-		if r.Start.After(now) {
-			m.reservations = append(m.reservations[:i], m.reservations[i+1:]...)
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
 
-			err := m.store.Delete(ref)
-			if err != nil {
-				return err
+	var err error
+
+	for _, slot := range slots {
+		res.Start = slot
+		res.End = slot.Add(length)
+
+		err = m.admit(res, now)
+		if err == nil {
+			break
+		}
+
+		if !strings.Contains(err.Error(), "range conflict") {
+			break
+		}
+	}
+
+	if err != nil {
+		res.Start, res.End = start, end
+		return err
+	}
+
+	m.assign(res, now)
+
+	m.reservations = append(m.reservations, res)
+
+	m.touch()
+
+	err = m.store.Add(res)
+	if err != nil {
+		return err
+	}
+
+	if res.Start.Equal(start) {
+		log.Printf("added %s", res)
+	} else {
+		log.Printf("added %s, waitlisted %s", res, res.Start.Sub(start))
+	}
+
+	return nil
+}
+
+// check a new reservation against existing reservations, unexpired
+// holds, queue limits and blackouts, called with the lock held
+func (m *memory) admit(res *Reservation, now time.Time) error {
+	if res.OpenEnded {
+		if res.Loan {
+			return errors.New("open-ended reservation can't be a loan")
+		}
+		res.End = openEnd
+	}
+
+	err := m.tooLong(res)
+	if err != nil {
+		return err
+	}
+
+	err = checkLinks(res.Links)
+	if err != nil {
+		return err
+	}
+
+	err = checkTags(res)
+	if err != nil {
+		return err
+	}
+
+	err = checkInitials(res)
+	if err != nil {
+		return err
+	}
+
+	queued := 0
+
+	existing := make([]*Reservation, 0, len(m.reservations)+len(m.holds))
+	existing = append(existing, m.reservations...)
+
+	for _, h := range m.holds {
+		if h.expire.After(now) {
+			existing = append(existing, h.res)
+		}
+	}
+
+	for _, r := range existing {
+		if !m.sameResource(r.Resource, res.Resource) {
+			continue
+		}
+
+		if r.Loan {
+			return &ConflictError{Reason: "resource on loan", With: r}
+		}
+
+		if m.overlap(r, res) {
+			return &ConflictError{Reason: "reservation range conflict", With: r}
+		}
+
+		if r.Start.After(now) {
+			queued++
+		}
+	}
+
+	if max, ok := m.maxQueued[m.resourceKey(res.Resource)]; ok && res.Start.After(now) && queued >= max {
+		return errors.New("too many queued reservations for resource")
+	}
+
+	return m.blackout(res)
+}
+
+// determine if req, the new state of reservation ref, runs into another
+// reservation or live hold on its resource, called with the lock held
+func (m *memory) collides(ref int, req *Reservation, now time.Time) error {
+	existing := make([]*Reservation, 0, len(m.reservations)+len(m.holds))
+	existing = append(existing, m.reservations...)
+
+	for _, h := range m.holds {
+		if h.expire.After(now) {
+			existing = append(existing, h.res)
+		}
+	}
+
+	for _, r := range existing {
+		if r.ID == ref || !m.sameResource(r.Resource, req.Resource) {
+			continue
+		}
+
+		if r.Loan {
+			return &ConflictError{Reason: "resource on loan", With: r}
+		}
+
+		if m.overlap(r, req) {
+			return &ConflictError{Reason: "reservation range conflict", With: r}
+		}
+	}
+
+	return nil
+}
+
+// fill in the server assigned fields of a new reservation, called with
+// the lock held
+func (m *memory) assign(res *Reservation, now time.Time) {
+	// the verified email claimed by the client is the owner, else the
+	// one registered for the display name
+	res.Owner = strings.ToLower(res.Owner)
+	if res.Owner == "" || !m.mail.Verified(res.Owner) {
+		res.Owner, _ = m.mail.Lookup(res.Name)
+		res.Owner = strings.ToLower(res.Owner)
+	}
+
+	if !res.Share && !res.NoShare {
+		res.Share = m.shared[m.resourceKey(res.Resource)]
+	}
+	res.NoShare = false
+
+	res.ID = m.nextID
+	res.Email = ""
+	res.LastModified = now.Round(time.Second)
+
+	if res.Loan {
+		res.End = res.Start
+	}
+
+	m.nextID++
+}
+
+// block the slot for a new reservation until ttl passes, the hold is
+// kept out of the backing store until confirmed
+func (m *memory) Hold(res *Reservation, ttl time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	err := m.admit(res, now)
+	if err != nil {
+		return err
+	}
+
+	m.assign(res, now)
+
+	m.holds = append(m.holds, &hold{res: res, expire: now.Add(ttl)})
+
+	log.Printf("held %s", res)
+
+	return nil
+}
+
+// promote an unexpired hold to a reservation
+func (m *memory) ConfirmHold(ref int) (*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	for i, h := range m.holds {
+		if h.res.ID != ref {
+			continue
+		}
+
+		m.holds = append(m.holds[:i], m.holds[i+1:]...)
+
+		if !h.expire.After(now) {
+			return nil, errors.New("hold expired")
+		}
+
+		res := h.res
+		res.LastModified = now.Round(time.Second)
+
+		m.reservations = append(m.reservations, res)
+
+		m.touch()
+
+		err := m.store.Add(res)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("confirmed %s", res)
+
+		return res, nil
+	}
+
+	return nil, errors.New("hold not found")
+}
+
+// a hold not yet confirmed
+func (m *memory) GetHold(ref int) (*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, h := range m.holds {
+		if h.res.ID == ref {
+			return h.res, nil
+		}
+	}
+
+	return nil, errors.New("hold not found")
+}
+
+// drop holds not confirmed in time, returning how many were dropped
+func (m *memory) ExpireHolds(now time.Time) int {
+	m.Lock()
+	defer m.Unlock()
+
+	holds := m.holds[:0]
+
+	for _, h := range m.holds {
+		if h.expire.After(now) {
+			holds = append(holds, h)
+			continue
+		}
+
+		log.Printf("hold expired %s", h.res)
+	}
+
+	dropped := len(m.holds) - len(holds)
+
+	for i := len(holds); i < len(m.holds); i++ {
+		m.holds[i] = nil
+	}
+
+	m.holds = holds
+
+	return dropped
+}
+
+// replace reservation if no overlap
+// don't allow:
+// - update of start or end if active or expired
+// - update of loan if active
+// - update of ID
+// - update if res.LastModified newer than req.LastModified
+func (m *memory) Update(ref int, req *Reservation) (*Reservation, error) {
+	res, err := m.GetById(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.LastModified.After(req.LastModified) {
+		return nil, errors.New("modified")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	err = m.modify(res, req, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("updated %s", res)
+
+	return res, nil
+}
+
+// apply the changes in req to res following the rules for Update,
+// called with the lock held
+func (m *memory) modify(res, req *Reservation, now time.Time) error {
+	if res.End.Before(now) && res.Loan == false {
+		return errors.New("already expired")
+	}
+
+	// an open-ended reservation is closed by giving it an end, a
+	// reservation with an end is never opened
+	if req.OpenEnded && !res.OpenEnded {
+		return errors.New("converting to open-ended")
+	}
+
+	if res.OpenEnded && req.OpenEnded && !req.End.Equal(res.End) {
+		req.OpenEnded = false
+	}
+
+	if res.OpenEnded && !req.OpenEnded && req.End.Equal(openEnd) {
+		return errors.New("closing open-ended reservation without an end")
+	}
+
+	err := checkLinks(req.Links)
+	if err != nil {
+		return err
+	}
+
+	err = checkTags(req)
+	if err != nil {
+		return err
+	}
+
+	// initials stored before they were checked are left be
+	if !strings.EqualFold(req.Initials, res.Initials) {
+		err = checkInitials(req)
+		if err != nil {
+			return err
+		}
+	} else {
+		req.Initials = res.Initials
+	}
+
+	// if active - only allow notes, share and end time changes
+	if res.Start.Before(now) {
+		if !m.sameResource(req.Resource, res.Resource) || req.Start != res.Start {
+			return errors.New("already active")
+		}
+
+		if res.Loan != req.Loan {
+			return errors.New("converting to/from loan")
+		}
+
+		err = m.tooLong(&Reservation{Start: res.Start, End: req.End, Loan: res.Loan, OpenEnded: req.OpenEnded})
+		if err != nil {
+			return err
+		}
+
+		if !req.End.Equal(res.End) {
+			err = m.collides(res.ID, &Reservation{Resource: res.Resource, Start: res.Start, End: req.End}, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		res.LastModified = now.Round(time.Second)
+		res.End = req.End
+		res.OpenEnded = req.OpenEnded
+		res.Notes = req.Notes
+		res.Links = req.Links
+		res.Tags = req.Tags
+		res.Share = req.Share
+		res.Flexible = req.Flexible
+		res.Name = req.Name
+		res.Initials = req.Initials
+		res.Email = ""
+
+		m.touch()
+
+		return m.store.Update(res.ID, res)
+	}
+
+	err = m.tooLong(req)
+	if err != nil {
+		return err
+	}
+
+	// only a change of place or time is checked, so older overlaps don't
+	// stand in the way of editing notes
+	if !m.sameResource(req.Resource, res.Resource) || !req.Start.Equal(res.Start) || !req.End.Equal(res.End) || req.Loan != res.Loan {
+		err = m.collides(res.ID, req, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = m.blackout(req)
+	if err != nil {
+		return err
+	}
+
+	res.LastModified = now.Round(time.Second)
+	res.Resource = req.Resource
+	res.Start = req.Start
+	res.End = req.End
+	res.OpenEnded = req.OpenEnded
+	res.Loan = req.Loan
+	res.Share = req.Share
+	res.Flexible = req.Flexible
+	res.Notes = req.Notes
+	res.Links = req.Links
+	res.Tags = req.Tags
+	res.Name = req.Name
+	res.Initials = req.Initials
+	res.Email = ""
+
+	m.touch()
+
+	return m.store.Update(res.ID, res)
+}
+
+// apply a merge patch to every reservation matching the filter - each
+// reservation is checked as for Update and the outcome reported per ID
+func (m *memory) BulkPatch(resource, name, owner, show string, patch []byte) ([]*PatchResult, error) {
+	// reject a malformed patch before anything is changed
+	_, err := MergePatch(&Reservation{}, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	results := make([]*PatchResult, 0)
+
+	now := time.Now()
+
+	for _, res := range m.reservations {
+		if resource != "" && !m.sameResource(res.Resource, resource) {
+			continue
+		}
+
+		if name != "" && !owns(res, name, owner) {
+			continue
+		}
+
+		if !m.shown(res, show, now) {
+			continue
+		}
+
+		result := &PatchResult{ID: res.ID, Status: "Success"}
+
+		req := *res
+
+		_, err := MergePatch(&req, patch)
+		if err == nil {
+			err = m.modify(res, &req, now)
+		}
+
+		if err != nil {
+			result.Status = "Error"
+			result.Error = err.Error()
+		} else {
+			log.Printf("patched %s", res)
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// if reservation start is in the future, just delete it
+// if reservation end is in the past, ignore this request
+// if reservation is active (start < now and (end > now || on loan))
+//    remove loan flag
+//    set end time <= now
+func (m *memory) Delete(ref int, lastmod time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	for i, r := range m.reservations {
+		if r.ID != ref {
+			continue
+		}
+
+		if r.LastModified.After(lastmod) {
+			return errors.New("resource modified")
+		}
+
+		if r.Start.After(now) {
+			m.reservations = append(m.reservations[:i], m.reservations[i+1:]...)
+
+			m.bury(r, now)
+
+			m.touch()
+
+			err := m.store.Delete(ref)
+			if err != nil {
+				return err
 			}
 
 			log.Println("deleted", ref)
@@ -284,6 +1099,8 @@ func (m *memory) Delete(ref int, lastmod time.Time) error {
 			r.End = now
 			r.LastModified = time.Now().Round(time.Second)
 
+			m.touch()
+
 			err := m.store.Update(r.ID, r)
 			if err != nil {
 				return err
@@ -296,8 +1113,11 @@ func (m *memory) Delete(ref int, lastmod time.Time) error {
 
 		if r.Start.Before(now) && r.End.After(now) {
 			r.End = now
+			r.OpenEnded = false
 			r.LastModified = time.Now().Round(time.Second)
 
+			m.touch()
+
 			err := m.store.Update(r.ID, r)
 			if err != nil {
 				return err
@@ -315,3 +1135,395 @@ func (m *memory) Delete(ref int, lastmod time.Time) error {
 
 	return errors.New("resource not found")
 }
+
+// delete every future reservation on a resource whose name starts with
+// prefix, active and expired ones are left and reported as skipped
+func (m *memory) BulkDelete(prefix string) ([]int, []int, error) {
+	if prefix == "" {
+		return nil, nil, errors.New("resource prefix not specified")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	var (
+		removed = make([]int, 0)
+		skipped = make([]int, 0)
+		kept    = make([]*Reservation, 0, len(m.reservations))
+		now     = time.Now()
+		key     = m.resourceKey(prefix)
+	)
+
+	for _, r := range m.reservations {
+		if !strings.HasPrefix(m.resourceKey(r.Resource), key) {
+			kept = append(kept, r)
+			continue
+		}
+
+		if !r.Start.After(now) {
+			skipped = append(skipped, r.ID)
+			kept = append(kept, r)
+			continue
+		}
+
+		err := m.store.Delete(r.ID)
+		if err != nil {
+			// keep this and the rest so memory matches the store
+			m.reservations = append(kept, m.reservations[len(kept)+len(removed):]...)
+			m.touch()
+			return removed, skipped, err
+		}
+
+		m.bury(r, now)
+
+		log.Println("deleted", r.ID)
+
+		removed = append(removed, r.ID)
+	}
+
+	m.reservations = kept
+
+	if len(removed) > 0 {
+		m.touch()
+	}
+
+	return removed, skipped, nil
+}
+
+// keep a deleted reservation for undoWindow, dropping those kept past
+// it, called with the lock held
+func (m *memory) bury(res *Reservation, now time.Time) {
+	tombstones := m.tombstones[:0]
+
+	for _, t := range m.tombstones {
+		if now.Sub(t.deleted) < m.undoWindow {
+			tombstones = append(tombstones, t)
+		}
+	}
+
+	for i := len(tombstones); i < len(m.tombstones); i++ {
+		m.tombstones[i] = nil
+	}
+
+	m.tombstones = tombstones
+
+	if m.undoWindow > 0 {
+		m.tombstones = append(m.tombstones, &tombstone{res: res, deleted: now})
+	}
+}
+
+// restore a reservation deleted within undoWindow, so long as nothing
+// has been booked in its place - tombstones are kept in memory only and
+// don't survive a restart
+func (m *memory) Undelete(ref int) (*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	for i, t := range m.tombstones {
+		if t.res.ID != ref {
+			continue
+		}
+
+		if now.Sub(t.deleted) >= m.undoWindow {
+			return nil, errors.New("undo window passed")
+		}
+
+		res := t.res
+
+		err := m.admit(res, now)
+		if err != nil {
+			return nil, err
+		}
+
+		m.tombstones = append(m.tombstones[:i], m.tombstones[i+1:]...)
+
+		res.LastModified = now.Round(time.Second)
+
+		m.reservations = append(m.reservations, res)
+
+		m.touch()
+
+		err = m.store.Restore(res)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("restored %s", res)
+
+		return res, nil
+	}
+
+	return nil, errors.New("deleted reservation not found")
+}
+
+// a deleted reservation still kept for restore
+func (m *memory) GetDeleted(ref int) (*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, t := range m.tombstones {
+		if t.res.ID == ref {
+			return t.res, nil
+		}
+	}
+
+	return nil, errors.New("deleted reservation not found")
+}
+
+// record that the holder is using an active reservation
+func (m *memory) CheckIn(ref int) (*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	for _, r := range m.reservations {
+		if r.ID != ref {
+			continue
+		}
+
+		if r.Start.After(now) || (r.End.Before(now) && r.Loan == false) {
+			return nil, errors.New("reservation not active")
+		}
+
+		if r.CheckedIn {
+			return r, nil
+		}
+
+		r.CheckedIn = true
+		r.LastModified = now.Round(time.Second)
+
+		m.touch()
+
+		err := m.store.Update(r.ID, r)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("checked in", ref)
+
+		return r, nil
+	}
+
+	return nil, errors.New("reservation not found")
+}
+
+// the operations the backing store recorded on a reservation, oldest
+// first - deleted reservations keep their history
+func (m *memory) History(ref int) ([]*HistoryEntry, error) {
+	hist, err := m.store.History(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hist) == 0 {
+		return nil, errors.New("reservation not found")
+	}
+
+	return hist, nil
+}
+
+// end active reservations not checked in within grace of their start,
+// returning the reservations flagged as no-shows - loans are skipped
+func (m *memory) NoShows(grace time.Duration, now time.Time) ([]*Reservation, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	noshows := make([]*Reservation, 0)
+
+	for _, r := range m.reservations {
+		if r.Loan || r.CheckedIn || r.NoShow {
+			continue
+		}
+
+		if r.Start.Add(grace).After(now) || r.End.Before(now) {
+			continue
+		}
+
+		r.NoShow = true
+		r.End = now
+		r.OpenEnded = false
+		r.LastModified = now.Round(time.Second)
+
+		m.touch()
+
+		err := m.store.Update(r.ID, r)
+		if err != nil {
+			return noshows, err
+		}
+
+		log.Printf("no-show %s", r)
+
+		noshows = append(noshows, r)
+	}
+
+	return noshows, nil
+}
+
+// move all unexpired reservations of a resource to a new name - nothing
+// changes if any would conflict with reservations or live holds under
+// the new name, or fall in one of its blackouts
+func (m *memory) Rename(from, to string) ([]*Reservation, error) {
+	if from == "" || to == "" {
+		return nil, errors.New("resource not specified")
+	}
+
+	if from == to {
+		return nil, errors.New("resource names are the same")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+
+	expired := func(r *Reservation) bool {
+		return r.End.Before(now) && r.Loan == false
+	}
+
+	moving := make([]*Reservation, 0)
+	renamed := make(map[int]bool)
+
+	for _, r := range m.reservations {
+		if m.sameResource(r.Resource, from) && !expired(r) {
+			moving = append(moving, r)
+			renamed[r.ID] = true
+		}
+	}
+
+	// those renamed are left out, a change of case alone under casefold
+	// finds them already under the new name
+	existing := make([]*Reservation, 0, len(m.reservations)+len(m.holds))
+
+	for _, r := range m.reservations {
+		if !renamed[r.ID] && !expired(r) {
+			existing = append(existing, r)
+		}
+	}
+
+	for _, h := range m.holds {
+		if h.expire.After(now) {
+			existing = append(existing, h.res)
+		}
+	}
+
+	for _, r := range moving {
+		for _, s := range existing {
+			if !m.sameResource(s.Resource, to) {
+				continue
+			}
+
+			if r.Loan || s.Loan {
+				return nil, fmt.Errorf("reservation %d conflicts with %d on loan", r.ID, s.ID)
+			}
+
+			if m.overlap(r, s) {
+				return nil, fmt.Errorf("reservation %d range conflict with %d", r.ID, s.ID)
+			}
+		}
+
+		// the time already used isn't checked against blackouts
+		check := *r
+		check.Resource = to
+		if check.Start.Before(now) {
+			check.Start = now
+		}
+
+		err := m.blackout(&check)
+		if err != nil {
+			return nil, fmt.Errorf("reservation %d: %v", r.ID, err)
+		}
+	}
+
+	for _, r := range moving {
+		r.Resource = to
+		r.LastModified = now.Round(time.Second)
+
+		m.touch()
+
+		err := m.store.Update(r.ID, r)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("renamed %s from %s", r, from)
+	}
+
+	return moving, nil
+}
+
+// compare memory against a fresh read of the backing store, for when the
+// log was edited by hand or a write was lost - with reload the store wins
+func (m *memory) Reconcile(reload bool) ([]*Discrepancy, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	fresh := &memory{
+		reservations: make([]*Reservation, 0),
+	}
+
+	err := m.store.ReadLog(fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[int]*Reservation)
+	for _, r := range fresh.reservations {
+		stored[r.ID] = r
+	}
+
+	diffs := make([]*Discrepancy, 0)
+
+	for _, r := range m.reservations {
+		s, ok := stored[r.ID]
+		if !ok {
+			diffs = append(diffs, &Discrepancy{ID: r.ID, Issue: "missing from store", Memory: r})
+			continue
+		}
+
+		delete(stored, r.ID)
+
+		// email is filled in from registrations as reservations are
+		// read, it isn't stored
+		mr, sr := *r, *s
+		mr.Email, sr.Email = "", ""
+
+		mb, err := json.Marshal(&mr)
+		if err != nil {
+			return nil, err
+		}
+
+		sb, err := json.Marshal(&sr)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(mb, sb) {
+			diffs = append(diffs, &Discrepancy{ID: r.ID, Issue: "differs", Memory: r, Store: s})
+		}
+	}
+
+	for id, s := range stored {
+		diffs = append(diffs, &Discrepancy{ID: id, Issue: "missing from memory", Store: s})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].ID < diffs[j].ID })
+
+	if reload && len(diffs) > 0 {
+		m.reservations = fresh.reservations
+
+		for _, r := range m.reservations {
+			if r.ID >= m.nextID {
+				m.nextID = r.ID + 1
+			}
+		}
+
+		m.touch()
+
+		log.Printf("reloaded %d reservations from store, %d discrepancies", len(m.reservations), len(diffs))
+	}
+
+	return diffs, nil
+}
@@ -3,7 +3,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -12,11 +18,18 @@ import (
 )
 
 type memtestMailer struct {
-	valid bool
+	valid   bool
+	notices []string
 }
 
 func (m *memtestMailer) Valid(string) bool             { return m.valid }
 func (m *memtestMailer) Lookup(string) (string, error) { return "", nil }
+func (m *memtestMailer) Verified(string) bool          { return m.valid }
+
+func (m *memtestMailer) Notify(name, subject, text string) error {
+	m.notices = append(m.notices, name)
+	return nil
+}
 
 func fillMemory(valid bool) (*memory, time.Time) {
 	storage := &memory{store: &nonstore{}}
@@ -129,7 +142,7 @@ func TestMemoryList(t *testing.T) {
 
 	count := len(storage.reservations)
 
-	res, err := storage.List("", "all", 0, 0)
+	res, err := storage.List("", "all", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -138,7 +151,7 @@ func TestMemoryList(t *testing.T) {
 		t.Fatalf("expected %d reservations got %d", count, len(res))
 	}
 
-	res, err = storage.List("resource A", "all", 0, 0)
+	res, err = storage.List("resource A", "all", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -149,7 +162,7 @@ func TestMemoryList(t *testing.T) {
 
 	time.Sleep(50 * time.Millisecond)
 
-	res, err = storage.List("", "current", 0, 0)
+	res, err = storage.List("", "current", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -158,7 +171,7 @@ func TestMemoryList(t *testing.T) {
 		t.Fatalf("expected %d reservations got %d", 2, len(res))
 	}
 
-	res, err = storage.List("", "history", 0, 0)
+	res, err = storage.List("", "history", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -167,7 +180,7 @@ func TestMemoryList(t *testing.T) {
 		t.Fatalf("expected %d reservations got %d", 1, len(res))
 	}
 
-	res, err = storage.List("", "all", 0, 0)
+	res, err = storage.List("", "all", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -176,7 +189,7 @@ func TestMemoryList(t *testing.T) {
 		t.Fatalf("expected %d reservations got %d", len(storage.reservations), len(res))
 	}
 
-	res, err = storage.List("", "active", 0, 0)
+	res, err = storage.List("", "active", "", "", 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -184,6 +197,86 @@ func TestMemoryList(t *testing.T) {
 	if len(res) != 8 {
 		t.Fatalf("expected %d reservations got %d", 8, len(res))
 	}
+
+	res, err = storage.List("", "loans", "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 || res[0].ID != 112 {
+		t.Fatalf("expected loan %d got %v", 112, res)
+	}
+}
+
+func TestMemoryListInitials(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	storage.reservations[0].Initials = "DB"
+	storage.reservations[3].Initials = "db"
+	storage.reservations[5].Initials = "SU"
+
+	res, err := storage.List("", "all", "Db", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 || res[0].ID != 35 || res[1].ID != 80 {
+		t.Fatalf("expected reservations %d and %d got %v", 35, 80, res)
+	}
+
+	res, err = storage.List("resource C", "all", "DB", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 || res[0].ID != 80 {
+		t.Fatalf("expected reservation %d got %v", 80, res)
+	}
+
+	res, err = storage.List("", "all", "XX", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res == nil || len(res) != 0 {
+		t.Fatalf("expected empty list got %v", res)
+	}
+}
+
+func TestMemoryListOrder(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	// an undelete or reload can leave the slice out of ID order
+	r := storage.reservations
+	r[0], r[3] = r[3], r[0]
+	r[1], r[5] = r[5], r[1]
+
+	res, err := storage.List("", "all", "", "", 79, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 || res[0].ID != 79 || res[1].ID != 80 {
+		t.Fatalf("expected reservations %d and %d got %v", 79, 80, res)
+	}
+
+	res, err = storage.List("", "all", "", "", 81, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 || res[0].ID != 110 || res[1].ID != 111 {
+		t.Fatalf("expected reservations %d and %d got %v", 110, 111, res)
+	}
+
+	res, err = storage.List("", "all", "", "", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 || res[0].ID != 35 {
+		t.Fatalf("expected reservation %d got %v", 35, res)
+	}
 }
 
 func TestMemoryAdd(t *testing.T) {
@@ -205,6 +298,277 @@ func TestMemoryAdd(t *testing.T) {
 	}
 }
 
+func TestMemoryShareDefault(t *testing.T) {
+	storage, now := fillMemory(true)
+	storage.shared = map[string]bool{"resource D": true}
+
+	tests := []struct {
+		name     string
+		resource string
+		share    bool
+		noshare  bool
+		expected bool
+	}{
+		{name: "default", resource: "resource D", expected: true},
+		{name: "override", resource: "resource D", noshare: true, expected: false},
+		{name: "explicit", resource: "resource E", share: true, expected: true},
+		{name: "unshared", resource: "resource E", expected: false},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := now.Add(time.Duration(i+1) * time.Hour)
+
+			res := &Reservation{
+				Resource: tc.resource,
+				Start:    start,
+				End:      start.Add(time.Minute),
+				Share:    tc.share,
+				NoShare:  tc.noshare,
+			}
+
+			err := storage.Add(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Share != tc.expected {
+				t.Fatalf("expected share %t got %t", tc.expected, res.Share)
+			}
+
+			if res.NoShare {
+				t.Fatal("override stored with reservation")
+			}
+		})
+	}
+}
+
+func TestMemoryCasefold(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		t.Run(fmt.Sprintf("casefold=%t", fold), func(t *testing.T) {
+			storage, now := fillMemory(true)
+			storage.casefold = fold
+
+			err := storage.Add(&Reservation{
+				Resource: "ESX01",
+				Start:    now.Add(time.Hour),
+				End:      now.Add(2 * time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := &Reservation{
+				Resource: "esx01",
+				Start:    now.Add(90 * time.Minute),
+				End:      now.Add(3 * time.Hour),
+			}
+
+			err = storage.Add(res)
+			if fold && (err == nil || !strings.Contains(err.Error(), "conflict")) {
+				t.Fatalf("expected range conflict got %v", err)
+			}
+			if !fold && err != nil {
+				t.Fatal(err)
+			}
+
+			list, _ := storage.List("Esx01", "all", "", "", 0, 0)
+
+			exp := 0
+			if fold {
+				exp = 1
+			}
+
+			if len(list) != exp {
+				t.Fatalf("expected %d reservations got %d", exp, len(list))
+			}
+
+			if fold && list[0].Resource != "ESX01" {
+				t.Fatalf("expected resource \"%s\" got \"%s\"", "ESX01", list[0].Resource)
+			}
+		})
+	}
+}
+
+func TestMemoryOwner(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	first := &Reservation{
+		Resource: "resource D",
+		Start:    now.Add(100 * time.Second),
+		End:      now.Add(120 * time.Second),
+		Name:     "Pat",
+		Owner:    "Pat@A.example.com",
+	}
+
+	second := &Reservation{
+		Resource: "resource D",
+		Start:    now.Add(120 * time.Second),
+		End:      now.Add(140 * time.Second),
+		Name:     "Pat",
+		Owner:    "pat@b.example.com",
+	}
+
+	for _, res := range []*Reservation{first, second} {
+		err := storage.Add(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first.Owner != "pat@a.example.com" {
+		t.Fatalf("expected owner \"pat@a.example.com\", got \"%s\"", first.Owner)
+	}
+
+	results, err := storage.BulkPatch("resource D", "Pat", "pat@b.example.com", "all", []byte(`{"notes":"mine"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].ID != second.ID {
+		t.Fatalf("expected only reservation %d patched, got %d results", second.ID, len(results))
+	}
+
+	if first.Notes != "" {
+		t.Fatalf("reservation %d owned by another user was patched", first.ID)
+	}
+}
+
+func TestMemoryAddMaxQueued(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int
+		error bool
+	}{
+		{name: "under cap", max: 3, error: false},
+		{name: "at cap", max: 2, error: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			storage, now := fillMemory(true)
+			storage.maxQueued = map[string]int{"resource A": tc.max}
+
+			res := &Reservation{
+				Resource: "resource A",
+				Start:    now.Add(100 * time.Hour),
+				End:      now.Add(120 * time.Hour),
+			}
+
+			err := storage.Add(res)
+			if tc.error {
+				if err == nil || err.Error() != "too many queued reservations for resource" {
+					t.Fatalf("expected queue limit error, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMemoryHoldConfirm(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	res := &Reservation{
+		Resource: "resource D",
+		Start:    now.Add(100 * time.Second),
+		End:      now.Add(120 * time.Second),
+	}
+
+	err := storage.Hold(res, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hold blocks the slot
+	err = storage.Add(&Reservation{
+		Resource: "resource D",
+		Start:    now.Add(110 * time.Second),
+		End:      now.Add(130 * time.Second),
+	})
+	if err == nil {
+		t.Fatal("expected conflict with hold")
+	}
+
+	if _, err := storage.GetById(res.ID); err == nil {
+		t.Fatal("expected hold not listed before confirm")
+	}
+
+	if held, err := storage.GetHold(res.ID); err != nil || held.ID != res.ID {
+		t.Fatalf("expected hold %d got %v %v", res.ID, held, err)
+	}
+
+	confirmed, err := storage.ConfirmHold(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.GetById(confirmed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.ConfirmHold(res.ID); err == nil {
+		t.Fatal("expected hold already confirmed")
+	}
+}
+
+func TestMemoryHoldExpire(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	res := &Reservation{
+		Resource: "resource D",
+		Start:    now.Add(100 * time.Second),
+		End:      now.Add(120 * time.Second),
+	}
+
+	err := storage.Hold(res, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := storage.ExpireHolds(now); n != 0 {
+		t.Fatalf("expected no holds expired got %d", n)
+	}
+
+	if n := storage.ExpireHolds(now.Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("expected %d hold expired got %d", 1, n)
+	}
+
+	if _, err := storage.ConfirmHold(res.ID); err == nil || err.Error() != "hold not found" {
+		t.Fatalf("expected hold not found got %v", err)
+	}
+
+	// the slot is free again
+	err = storage.Add(&Reservation{
+		Resource: "resource D",
+		Start:    now.Add(110 * time.Second),
+		End:      now.Add(130 * time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lapsed hold not yet swept can't be confirmed
+	late := &Reservation{
+		Resource: "resource E",
+		Start:    now.Add(100 * time.Second),
+		End:      now.Add(120 * time.Second),
+	}
+
+	err = storage.Hold(late, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.ConfirmHold(late.ID); err == nil || err.Error() != "hold expired" {
+		t.Fatalf("expected hold expired got %v", err)
+	}
+}
+
 func TestMemoryAddOverlap(t *testing.T) {
 	storage, now := fillMemory(true)
 
@@ -244,6 +608,137 @@ func TestMemoryAddOverlap(t *testing.T) {
 	}
 }
 
+func TestMemoryConflictDetail(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	day := time.Date(2030, time.April, 3, 0, 0, 0, 0, time.Local)
+
+	blocker := storage.reservations[5] // 111, resource D
+	blocker.Name = "Some User"
+	blocker.Start = day.Add(10 * time.Hour)
+	blocker.End = day.Add(12 * time.Hour)
+
+	res := &Reservation{
+		Resource: "resource D",
+		Start:    day.Add(11 * time.Hour),
+		End:      day.Add(13 * time.Hour),
+	}
+
+	err := storage.Add(res)
+	if err == nil {
+		t.Fatal("expected conflict error")
+	}
+
+	exp := "reservation range conflict, blocked by reservation 111 (Some User, Apr 3 10:00-12:00)"
+	if err.Error() != exp {
+		t.Fatalf("expected \"%s\" got \"%s\"", exp, err.Error())
+	}
+
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.With.ID != 111 {
+		t.Fatalf("expected a conflict with %d got %#v", 111, err)
+	}
+
+	blocker.End = day.Add(36 * time.Hour)
+
+	err = storage.Add(res)
+	exp = "reservation range conflict, blocked by reservation 111 (Some User, Apr 3 10:00-Apr 4 12:00)"
+	if err == nil || err.Error() != exp {
+		t.Fatalf("expected \"%s\" got %v", exp, err)
+	}
+
+	loan := storage.reservations[6] // 112, resource X
+	loan.Name = "Another User"
+	loan.Start = day.Add(9 * time.Hour)
+
+	res.Resource = "resource X"
+
+	err = storage.Add(res)
+	exp = "resource on loan, blocked by reservation 112 (Another User, on loan from Apr 3 09:00)"
+	if err == nil || err.Error() != exp {
+		t.Fatalf("expected \"%s\" got %v", exp, err)
+	}
+}
+
+func TestMemoryWaitlist(t *testing.T) {
+	tests := []struct {
+		name     string
+		blockers [][2]time.Duration
+		start    time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "free",
+			blockers: [][2]time.Duration{{time.Hour, 2 * time.Hour}},
+			start:    3 * time.Hour,
+			expected: 3 * time.Hour,
+		},
+		{
+			name:     "single",
+			blockers: [][2]time.Duration{{time.Hour, 2 * time.Hour}},
+			start:    90 * time.Minute,
+			expected: 2 * time.Hour,
+		},
+		{
+			// the gap after the second blocker is too short
+			name:     "chained",
+			blockers: [][2]time.Duration{{time.Hour, 2 * time.Hour}, {2 * time.Hour, 3 * time.Hour}, {210 * time.Minute, 4 * time.Hour}},
+			start:    time.Hour,
+			expected: 4 * time.Hour,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			storage, now := fillMemory(true)
+
+			for _, b := range tc.blockers {
+				err := storage.Add(&Reservation{
+					Resource: "resource W",
+					Start:    now.Add(b[0]),
+					End:      now.Add(b[1]),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res := &Reservation{
+				Resource: "resource W",
+				Start:    now.Add(tc.start),
+				End:      now.Add(tc.start + time.Hour),
+			}
+
+			err := storage.Waitlist(res)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !res.Start.Equal(now.Add(tc.expected)) || !res.End.Equal(now.Add(tc.expected+time.Hour)) {
+				t.Fatalf("expected start %s got %s", now.Add(tc.expected), res.Start)
+			}
+
+			if _, err := storage.GetById(res.ID); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	storage, now := fillMemory(true)
+
+	// loans never end, so there is no slot to wait for
+	res := &Reservation{
+		Resource: "resource X",
+		Start:    now.Add(time.Hour),
+		End:      now.Add(2 * time.Hour),
+	}
+
+	err := storage.Waitlist(res)
+	if err == nil || !strings.Contains(err.Error(), "on loan") {
+		t.Fatalf("expected on loan error got %v", err)
+	}
+}
+
 func TestMemoryAddExistingLoan(t *testing.T) {
 	storage, now := fillMemory(true)
 
@@ -333,79 +828,616 @@ func TestMemoryUpdate(t *testing.T) {
 	}
 }
 
-func TestMemoryUpdateActive(t *testing.T) {
-	storage, now := fillMemory(true)
+func TestMemoryInitials(t *testing.T) {
+	tests := []struct {
+		initials string
+		stored   string
+		error    string
+	}{
+		{initials: "", stored: ""},
+		{initials: "d", stored: "D"},
+		{initials: "db", stored: "DB"},
+		{initials: "dAb", stored: "DAB"},
+		{initials: "DBUL", error: "at most 3 letters"},
+		{initials: "D1", error: "letters only"},
+		{initials: "D B", error: "letters only"},
+		{initials: "<b>", error: "letters only"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.initials, func(t *testing.T) {
+			storage, now := fillMemory(true)
+
+			res := &Reservation{
+				Resource: "resource D",
+				Start:    now.Add(100 * time.Second),
+				End:      now.Add(120 * time.Second),
+				Initials: tc.initials,
+			}
+
+			err := storage.Add(res)
+			if tc.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.error) {
+					t.Fatalf("expected an error with \"%s\" got %v", tc.error, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Initials != tc.stored {
+				t.Fatalf("expected initials \"%s\" got \"%s\"", tc.stored, res.Initials)
+			}
+		})
+	}
+}
 
-	time.Sleep(50 * time.Millisecond)
+func TestMemoryUpdateInitials(t *testing.T) {
+	storage, now := fillMemory(true)
 
-	id := 113
+	id := 78
 
 	res, err := storage.GetById(id)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	req := &Reservation{
-		LastModified: res.LastModified,
-		Resource:     res.Resource,
-		Start:        res.Start,
-		End:          now.Add(1 * time.Hour),
-		Loan:         res.Loan,
-		Share:        res.Share,
-		Notes:        res.Notes,
-		Name:         "test person",
-		Initials:     res.Initials,
-	}
+	// stored before initials were checked
+	res.Initials = "legacy"
 
-	res, err = storage.Update(id, req)
+	req := *res
+	req.End = now.Add(61 * time.Hour)
+
+	_, err = storage.Update(id, &req)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("unchanged initials refused: %v", err)
 	}
 
-	if res.Name != req.Name {
-		t.Fatalf("name not modified")
+	req = *res
+	req.Initials = "x-y"
+
+	_, err = storage.Update(id, &req)
+	if err == nil || !strings.Contains(err.Error(), "invalid initials") {
+		t.Fatalf("expected \"invalid initials\" error got %v", err)
 	}
 
-	if res.End != req.End {
-		t.Fatalf("end time not modified")
+	req = *res
+	req.Initials = "su"
+
+	res, err = storage.Update(id, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Initials != "SU" {
+		t.Fatalf("expected initials \"SU\" got \"%s\"", res.Initials)
 	}
 }
 
-func TestMemoryUpdateModified(t *testing.T) {
+func TestMemoryMaxDuration(t *testing.T) {
 	storage, now := fillMemory(true)
-
-	id := 35
-
-	res, err := storage.GetById(id)
+	storage.maxDuration = 720 * time.Hour
+
+	start := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		resource string
+		end      time.Time
+		loan     bool
+		fail     bool
+	}{
+		{name: "under", resource: "resource M1", end: start.Add(720*time.Hour - time.Minute)},
+		{name: "over", resource: "resource M2", end: start.Add(720*time.Hour + time.Minute), fail: true},
+		{name: "loan", resource: "resource M3", end: start, loan: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := storage.Add(&Reservation{
+				Resource: tc.resource,
+				Start:    start,
+				End:      tc.end,
+				Loan:     tc.loan,
+			})
+
+			if tc.fail {
+				if err == nil || !strings.Contains(err.Error(), "maximum duration") {
+					t.Fatalf("expected maximum duration error got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	res, err := storage.GetById(79)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	res.LastModified = res.LastModified.Add(time.Second)
+	req := *res
+	req.End = res.Start.Add(720*time.Hour + time.Minute)
 
-	req := &Reservation{
-		LastModified: now,
-		Resource:     res.Resource,
-		Start:        res.Start,
-		End:          now.Add(1 * time.Hour),
-		Loan:         res.Loan,
-		Share:        res.Share,
-		Notes:        res.Notes,
-		Name:         "test person",
-		Initials:     res.Initials,
+	_, err = storage.Update(79, &req)
+	if err == nil || !strings.Contains(err.Error(), "maximum duration") {
+		t.Fatalf("expected maximum duration error got %v", err)
 	}
 
-	res, err = storage.Update(id, req)
-	if err == nil {
-		t.Fatal("expected \"not modifed\" error")
+	req.End = res.Start.Add(720*time.Hour - time.Minute)
+
+	_, err = storage.Update(79, &req)
+	if err != nil {
+		t.Fatal(err)
 	}
+}
 
-	if strings.Contains(err.Error(), "modified") == false {
-		t.Fatalf("expected \"modified\" got \"%s\"", err.Error())
+func TestMemoryFlexible(t *testing.T) {
+	storage, now := fillMemory(true)
+	storage.minFlexible = time.Hour
+
+	mailer := storage.mail.(*memtestMailer)
+
+	flexible := func(resource string, start, end time.Time) *Reservation {
+		res := &Reservation{
+			ID:       len(storage.reservations) + 500,
+			Resource: resource,
+			Start:    start,
+			End:      end,
+			Flexible: true,
+			Name:     "Flexible User",
+		}
+		storage.reservations = append(storage.reservations, res)
+		return res
+	}
+
+	t.Run("trim", func(t *testing.T) {
+		f := flexible("resource F1", now.Add(time.Hour), now.Add(4*time.Hour))
+
+		err := storage.Add(&Reservation{Resource: "resource F1", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !f.End.Equal(now.Add(2 * time.Hour)) {
+			t.Fatalf("expected end trimmed to %s got %s", now.Add(2*time.Hour), f.End)
+		}
+
+		if len(mailer.notices) != 1 || mailer.notices[0] != "Flexible User" {
+			t.Fatalf("expected owner notified got %v", mailer.notices)
+		}
+	})
+
+	t.Run("minimum", func(t *testing.T) {
+		f := flexible("resource F2", now.Add(time.Hour), now.Add(4*time.Hour))
+
+		err := storage.Add(&Reservation{Resource: "resource F2", Start: now.Add(90 * time.Minute), End: now.Add(5 * time.Hour)})
+		if err == nil || !strings.Contains(err.Error(), "range conflict") {
+			t.Fatalf("expected range conflict got %v", err)
+		}
+
+		if !f.End.Equal(now.Add(4 * time.Hour)) {
+			t.Fatalf("expected end unchanged got %s", f.End)
+		}
+	})
+
+	t.Run("active", func(t *testing.T) {
+		f := flexible("resource F3", now.Add(-2*time.Hour), now.Add(2*time.Hour))
+
+		err := storage.Add(&Reservation{Resource: "resource F3", Start: now.Add(time.Hour), End: now.Add(3 * time.Hour)})
+		if err == nil || !strings.Contains(err.Error(), "range conflict") {
+			t.Fatalf("expected range conflict got %v", err)
+		}
+
+		if !f.End.Equal(now.Add(2 * time.Hour)) {
+			t.Fatalf("expected end unchanged got %s", f.End)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		f := flexible("resource F4", now.Add(time.Hour), now.Add(4*time.Hour))
+
+		storage.reservations = append(storage.reservations, &Reservation{
+			ID:       900,
+			Resource: "resource F4",
+			Start:    now.Add(3 * time.Hour),
+			End:      now.Add(5 * time.Hour),
+		})
+
+		err := storage.Add(&Reservation{Resource: "resource F4", Start: now.Add(2 * time.Hour), End: now.Add(4 * time.Hour)})
+		if err == nil || !strings.Contains(err.Error(), "range conflict") {
+			t.Fatalf("expected range conflict got %v", err)
+		}
+
+		if !f.End.Equal(now.Add(4 * time.Hour)) {
+			t.Fatalf("expected end restored got %s", f.End)
+		}
+	})
+
+	if len(mailer.notices) != 1 {
+		t.Fatalf("expected %d notice got %d", 1, len(mailer.notices))
 	}
 }
 
-func TestMemoryUpdateExpired(t *testing.T) {
+func TestMemoryCheckAdd(t *testing.T) {
+	storage, now := fillMemory(true)
+	storage.minFlexible = time.Hour
+
+	count := len(storage.reservations)
+	nextID := storage.nextID
+
+	err := storage.CheckAdd(&Reservation{Resource: "resource V", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := &Reservation{ID: 700, Resource: "resource V", Start: now.Add(time.Hour), End: now.Add(4 * time.Hour)}
+	storage.reservations = append(storage.reservations, existing)
+
+	err = storage.CheckAdd(&Reservation{Resource: "resource V", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)})
+	if err == nil || !strings.Contains(err.Error(), "range conflict") {
+		t.Fatalf("expected range conflict got %v", err)
+	}
+
+	// room a flexible reservation would give up is available, though
+	// checking leaves it untouched
+	existing.Flexible = true
+
+	err = storage.CheckAdd(&Reservation{Resource: "resource V", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !existing.End.Equal(now.Add(4 * time.Hour)) {
+		t.Fatalf("expected end unchanged got %s", existing.End)
+	}
+
+	if len(storage.reservations) != count+1 || storage.nextID != nextID {
+		t.Fatalf("expected nothing stored, %d reservations next ID %d", len(storage.reservations), storage.nextID)
+	}
+}
+
+func TestMemoryLinks(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	start := now.Add(time.Hour)
+
+	tooMany := make([]string, MaxLinks+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("https://tickets.example.com/%d", i)
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		links    []string
+		fail     string
+	}{
+		{name: "valid", resource: "resource L1", links: []string{"https://tickets.example.com/123", "http://wiki/runbook"}},
+		{name: "relative", resource: "resource L2", links: []string{"tickets/123"}, fail: "invalid link"},
+		{name: "no host", resource: "resource L3", links: []string{"mailto:someone@example.com"}, fail: "invalid link"},
+		{name: "too many", resource: "resource L4", links: tooMany, fail: "too many links"},
+	}
+
+	id := 0
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				Resource: tc.resource,
+				Start:    start,
+				End:      start.Add(time.Hour),
+				Links:    tc.links,
+			}
+
+			err := storage.Add(res)
+
+			if tc.fail != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.fail) {
+					t.Fatalf("expected %s error got %v", tc.fail, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id = res.ID
+		})
+	}
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Links) != 2 || res.Links[0] != "https://tickets.example.com/123" {
+		t.Fatalf("expected links to be stored got %v", res.Links)
+	}
+
+	req := *res
+
+	_, err = MergePatch(&req, []byte(`{"links":["not a link"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Update(id, &req)
+	if err == nil || !strings.Contains(err.Error(), "invalid link") {
+		t.Fatalf("expected invalid link error got %v", err)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"links":["https://runbooks.example.com/reset"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.Update(id, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Links) != 1 || res.Links[0] != "https://runbooks.example.com/reset" {
+		t.Fatalf("expected patched link got %v", res.Links)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"links":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.Update(id, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Links) != 0 {
+		t.Fatalf("expected links cleared got %v", res.Links)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"links":[1]}`))
+	if err == nil {
+		t.Fatal("expected error for non-string link")
+	}
+}
+
+func TestMemoryTags(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	start := now.Add(time.Hour)
+
+	tooMany := make([]string, MaxTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		tags     []string
+		expect   []string
+		fail     string
+	}{
+		{name: "valid", resource: "resource T1", tags: []string{"CI", " demo ", "ci"}, expect: []string{"ci", "demo"}},
+		{name: "none", resource: "resource T2", tags: []string{}, expect: nil},
+		{name: "empty", resource: "resource T3", tags: []string{""}, fail: "invalid tag"},
+		{name: "spaces", resource: "resource T4", tags: []string{"nightly build"}, fail: "invalid tag"},
+		{name: "too many", resource: "resource T5", tags: tooMany, fail: "too many tags"},
+	}
+
+	id := 0
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				Resource: tc.resource,
+				Start:    start,
+				End:      start.Add(time.Hour),
+				Tags:     tc.tags,
+			}
+
+			err := storage.Add(res)
+
+			if tc.fail != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.fail) {
+					t.Fatalf("expected %s error got %v", tc.fail, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(res.Tags) != fmt.Sprint(tc.expect) {
+				t.Fatalf("expected tags %v got %v", tc.expect, res.Tags)
+			}
+
+			if id == 0 {
+				id = res.ID
+			}
+		})
+	}
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := *res
+
+	// an array replaces the tags outright
+	_, err = MergePatch(&req, []byte(`{"tags":["Maintenance"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.Update(id, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Tags) != 1 || res.Tags[0] != "maintenance" {
+		t.Fatalf("expected tags replaced got %v", res.Tags)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"tags":["ci","not ok"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Update(id, &req)
+	if err == nil || !strings.Contains(err.Error(), "invalid tag") {
+		t.Fatalf("expected invalid tag error got %v", err)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"tags":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.Update(id, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Tags) != 0 {
+		t.Fatalf("expected tags cleared got %v", res.Tags)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"tags":[true]}`))
+	if err == nil || err.Error() != "tag not a string" {
+		t.Fatalf("expected non-string tag error got %v", err)
+	}
+
+	_, err = MergePatch(&req, []byte(`{"labels":["ci"]}`))
+	if err == nil || err.Error() != "unknown field name" {
+		t.Fatalf("expected unknown field error got %v", err)
+	}
+}
+
+func TestMemoryListTag(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	for _, r := range storage.reservations {
+		switch r.ID {
+		case 35, 80:
+			r.Tags = []string{"ci"}
+		case 79:
+			r.Tags = []string{"demo", "ci"}
+		case 111:
+			r.Tags = []string{"maintenance"}
+		}
+	}
+
+	tests := []struct {
+		resource string
+		tag      string
+		expect   []int
+	}{
+		{"", "ci", []int{35, 79, 80}},
+		{"", "CI", []int{35, 79, 80}},
+		{"", "demo", []int{79}},
+		{"resource C", "ci", []int{80}},
+		{"", "none", []int{}},
+	}
+
+	for _, tc := range tests {
+		res, err := storage.List(tc.resource, "all", "", tc.tag, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]int, 0)
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+
+		if fmt.Sprint(ids) != fmt.Sprint(tc.expect) {
+			t.Fatalf("tag %s: expected %v got %v", tc.tag, tc.expect, ids)
+		}
+	}
+}
+
+func TestMemoryUpdateActive(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	time.Sleep(50 * time.Millisecond)
+
+	id := 113
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &Reservation{
+		LastModified: res.LastModified,
+		Resource:     res.Resource,
+		Start:        res.Start,
+		End:          now.Add(1 * time.Hour),
+		Loan:         res.Loan,
+		Share:        res.Share,
+		Notes:        res.Notes,
+		Name:         "test person",
+		Initials:     res.Initials,
+	}
+
+	res, err = storage.Update(id, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Name != req.Name {
+		t.Fatalf("name not modified")
+	}
+
+	if res.End != req.End {
+		t.Fatalf("end time not modified")
+	}
+}
+
+func TestMemoryUpdateModified(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	id := 35
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.LastModified = res.LastModified.Add(time.Second)
+
+	req := &Reservation{
+		LastModified: now,
+		Resource:     res.Resource,
+		Start:        res.Start,
+		End:          now.Add(1 * time.Hour),
+		Loan:         res.Loan,
+		Share:        res.Share,
+		Notes:        res.Notes,
+		Name:         "test person",
+		Initials:     res.Initials,
+	}
+
+	res, err = storage.Update(id, req)
+	if err == nil {
+		t.Fatal("expected \"not modifed\" error")
+	}
+
+	if strings.Contains(err.Error(), "modified") == false {
+		t.Fatalf("expected \"modified\" got \"%s\"", err.Error())
+	}
+}
+
+func TestMemoryUpdateExpired(t *testing.T) {
 	storage, now := fillMemory(true)
 
 	time.Sleep(50 * time.Millisecond)
@@ -507,6 +1539,89 @@ func TestMemoryUpdateConvertActiveLoan(t *testing.T) {
 	}
 }
 
+func TestMemoryUpdateConflict(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	update := func(id int, change func(req *Reservation)) error {
+		res, err := storage.GetById(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := *res
+		change(&req)
+
+		_, err = storage.Update(id, &req)
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		id     int
+		change func(req *Reservation)
+		reason string
+		with   int
+	}{
+		{
+			name:   "moved onto booked resource",
+			id:     79,
+			change: func(req *Reservation) { req.Resource = "resource C" },
+			reason: "reservation range conflict",
+			with:   80,
+		},
+		{
+			name:   "moved onto loaned resource",
+			id:     79,
+			change: func(req *Reservation) { req.Resource = "resource X" },
+			reason: "resource on loan",
+			with:   112,
+		},
+		{
+			name:   "end stretched over next",
+			id:     80,
+			change: func(req *Reservation) { req.End = now.Add(110 * time.Second) },
+			reason: "reservation range conflict",
+			with:   110,
+		},
+		{
+			name:   "active end stretched over next",
+			id:     35,
+			change: func(req *Reservation) { req.End = now.Add(40 * time.Hour) },
+			reason: "reservation range conflict",
+			with:   78,
+		},
+	}
+
+	// make 35 active
+	storage.reservations[0].Start = now.Add(-time.Second)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := update(tc.id, tc.change)
+
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("expected conflict got %v", err)
+			}
+
+			if conflict.Reason != tc.reason || conflict.With.ID != tc.with {
+				t.Fatalf("expected %s with %d got %v", tc.reason, tc.with, err)
+			}
+		})
+	}
+
+	// its own slot and a free resource are fine
+	err := update(80, func(req *Reservation) { req.Start = now.Add(95 * time.Second) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = update(79, func(req *Reservation) { req.Resource = "resource N" })
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestMemoryDelete(t *testing.T) {
 	storage, _ := fillMemory(true)
 
@@ -523,53 +1638,192 @@ func TestMemoryDelete(t *testing.T) {
 	}
 }
 
-func TestMemoryDeleteLoan(t *testing.T) {
-	storage, _ := fillMemory(true)
+func TestMemoryUndelete(t *testing.T) {
+	storage, now := fillMemory(true)
+	storage.undoWindow = time.Hour
 
-	id := 112
+	id := 78
 
 	err := storage.Delete(id, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	res, err := storage.GetById(id)
+	deleted, err := storage.GetDeleted(id)
+	if err != nil || deleted.ID != id {
+		t.Fatalf("expected deleted reservation %d got %v %v", id, deleted, err)
+	}
+
+	res, err := storage.Undelete(id)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if res.Loan {
-		t.Fatalf("expected loan false got %t", res.Loan)
+	if res.ID != id || !res.Start.Equal(now.Add(30*time.Hour)) {
+		t.Fatalf("expected reservation %d restored got %s", id, res)
 	}
 
-	if res.End.After(res.Start) == false {
-		t.Fatalf("expected end time after start")
+	_, err = storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second undo has nothing to restore
+	_, err = storage.Undelete(id)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected \"not found\" error got %v", err)
 	}
 }
 
-func TestMemoryDeleteActive(t *testing.T) {
-	storage, _ := fillMemory(true)
+func TestMemoryUndeleteConflict(t *testing.T) {
+	storage, now := fillMemory(true)
+	storage.undoWindow = time.Hour
 
-	id := 113
+	id := 78
 
-	res, err := storage.GetById(id)
+	err := storage.Delete(id, time.Now())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	end := res.End
-
-	err = storage.Delete(id, time.Now())
+	err = storage.Add(&Reservation{
+		Resource: "resource A",
+		Start:    now.Add(40 * time.Hour),
+		End:      now.Add(50 * time.Hour),
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	res, err = storage.GetById(id)
-	if err != nil {
-		t.Fatal(err)
+	_, err = storage.Undelete(id)
+	if err == nil || !strings.Contains(err.Error(), "range conflict") {
+		t.Fatalf("expected \"range conflict\" error got %v", err)
 	}
 
-	if end.After(res.End) == false {
+	_, err = storage.GetById(id)
+	if err == nil {
+		t.Fatal("expected \"not found\" error")
+	}
+}
+
+func TestMemoryUndeleteWindow(t *testing.T) {
+	storage, _ := fillMemory(true)
+	storage.undoWindow = time.Hour
+
+	id := 78
+
+	err := storage.Delete(id, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage.tombstones[0].deleted = time.Now().Add(-2 * time.Hour)
+
+	_, err = storage.Undelete(id)
+	if err == nil || !strings.Contains(err.Error(), "window passed") {
+		t.Fatalf("expected \"window passed\" error got %v", err)
+	}
+
+	// without a window deletes are final
+	storage.undoWindow = 0
+
+	err = storage.Delete(79, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Undelete(79)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected \"not found\" error got %v", err)
+	}
+}
+
+func TestMemoryBulkDelete(t *testing.T) {
+	storage, _ := fillMemory(true)
+	storage.casefold = true
+
+	storage.reservations[1].Resource = "esx01" // 78, future
+	storage.reservations[2].Resource = "ESX02" // 79, future
+	storage.reservations[4].Resource = "web01" // 110, future
+	storage.reservations[7].Resource = "esx03" // 113, active
+
+	count := len(storage.reservations)
+
+	removed, skipped, err := storage.BulkDelete("esx0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 2 || removed[0] != 78 || removed[1] != 79 {
+		t.Fatalf("expected %d and %d removed got %v", 78, 79, removed)
+	}
+
+	if len(skipped) != 1 || skipped[0] != 113 {
+		t.Fatalf("expected %d skipped got %v", 113, skipped)
+	}
+
+	if len(storage.reservations) != count-2 {
+		t.Fatalf("expected %d reservations got %d", count-2, len(storage.reservations))
+	}
+
+	for _, id := range []int{110, 113} {
+		if _, err := storage.GetById(id); err != nil {
+			t.Fatalf("reservation %d: %v", id, err)
+		}
+	}
+
+	if _, _, err := storage.BulkDelete(""); err == nil {
+		t.Fatal("expected error for an empty prefix")
+	}
+}
+
+func TestMemoryDeleteLoan(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	id := 112
+
+	err := storage.Delete(id, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Loan {
+		t.Fatalf("expected loan false got %t", res.Loan)
+	}
+
+	if res.End.After(res.Start) == false {
+		t.Fatalf("expected end time after start")
+	}
+}
+
+func TestMemoryDeleteActive(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	id := 113
+
+	res, err := storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := res.End
+
+	err = storage.Delete(id, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if end.After(res.End) == false {
 		t.Fatal("end time not adjusted")
 	}
 }
@@ -603,3 +1857,864 @@ func TestMemoryDeleteNotFound(t *testing.T) {
 		t.Fatalf("expected \"not found\" error, got \"%s\"", err.Error())
 	}
 }
+
+func TestMemoryAddBlackout(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	// tomorrow, so the test does not depend on the time of day
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	day := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, time.Local)
+
+	storage.blackouts = &blackouts{
+		windows: []*Blackout{
+			&Blackout{
+				ID:       1,
+				Resource: "resource B",
+				Days:     []time.Weekday{day.Weekday()},
+				Start:    "01:00",
+				End:      "03:00",
+				Reason:   "backups",
+			},
+		},
+	}
+
+	res := &Reservation{
+		Resource: "resource B",
+		Start:    day.Add(2 * time.Hour),
+		End:      day.Add(4 * time.Hour),
+	}
+
+	err := storage.Add(res)
+	if err == nil {
+		t.Fatal("expected blackout error")
+	}
+
+	if strings.Contains(err.Error(), "resource in blackout") == false {
+		t.Fatalf("expected an error with \"resource in blackout\" got \"%s\"", err.Error())
+	}
+
+	res = &Reservation{
+		Resource: "resource B",
+		Start:    day.Add(3 * time.Hour),
+		End:      day.Add(5 * time.Hour),
+	}
+
+	err = storage.Add(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// resource names match as they do for reservations
+	storage.casefold = true
+
+	res = &Reservation{
+		Resource: "RESOURCE B",
+		Start:    day.Add(30 * time.Minute),
+		End:      day.Add(2 * time.Hour),
+	}
+
+	err = storage.Add(res)
+	if err == nil || !strings.Contains(err.Error(), "resource in blackout") {
+		t.Fatalf("expected blackout error regardless of case got %v", err)
+	}
+}
+
+func TestMemoryBulkPatch(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	time.Sleep(50 * time.Millisecond)
+
+	results, err := storage.BulkPatch("resource C", "", "", "all", []byte(`{"notes":"maintenance"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected %d results got %d", 2, len(results))
+	}
+
+	for _, result := range results {
+		if result.Status != "Success" {
+			t.Fatalf("expected success for %d got \"%s\"", result.ID, result.Error)
+		}
+
+		res, _ := storage.GetById(result.ID)
+		if res.Notes != "maintenance" {
+			t.Fatalf("expected notes \"%s\" got \"%s\"", "maintenance", res.Notes)
+		}
+	}
+
+	res, _ := storage.GetById(78)
+	if res.Notes != "" {
+		t.Fatalf("expected reservation %d unchanged, got notes \"%s\"", 78, res.Notes)
+	}
+
+	// expired reservations are reported, not changed
+	results, err = storage.BulkPatch("resource Z", "", "", "all", []byte(`{"notes":"maintenance"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("expected %d results got %d", 1, len(results))
+	}
+
+	if results[0].Status != "Error" || strings.Contains(results[0].Error, "already expired") == false {
+		t.Fatalf("expected \"already expired\" error got %+v", results[0])
+	}
+
+	// active reservations can't move
+	results, err = storage.BulkPatch("resource Y", "", "", "all", []byte(`{"resource":"resource Q"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0].Status != "Error" || strings.Contains(results[0].Error, "already active") == false {
+		t.Fatalf("expected \"already active\" error got %+v", results[0])
+	}
+
+	_, err = storage.BulkPatch("resource C", "", "", "all", []byte(`{"shair":true}`))
+	if err == nil {
+		t.Fatal("expected unknown field error")
+	}
+}
+
+func TestMemoryNoShow(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	storage.reservations = append(storage.reservations,
+		&Reservation{
+			ID:           200,
+			LastModified: now,
+			Resource:     "resource N",
+			Start:        now.Add(-time.Hour),
+			End:          now.Add(time.Hour),
+		},
+		&Reservation{
+			ID:           201,
+			LastModified: now,
+			Resource:     "resource O",
+			Start:        now.Add(-time.Hour),
+			End:          now.Add(time.Hour),
+		},
+	)
+
+	_, err := storage.CheckIn(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.CheckIn(78)
+	if err == nil || strings.Contains(err.Error(), "not active") == false {
+		t.Fatalf("expected \"not active\" error got %v", err)
+	}
+
+	noshows, err := storage.NoShows(30*time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(noshows) != 1 || noshows[0].ID != 201 {
+		t.Fatalf("expected reservation %d as no-show got %v", 201, noshows)
+	}
+
+	kept, _ := storage.GetById(200)
+	if kept.NoShow || kept.End.Equal(now.Add(time.Hour)) == false {
+		t.Fatalf("expected checked in reservation kept got %s", kept)
+	}
+
+	ended, _ := storage.GetById(201)
+	if ended.NoShow == false || ended.End.Equal(now) == false {
+		t.Fatalf("expected no-show reservation ended got %s", ended)
+	}
+
+	// already flagged reservations aren't reported twice
+	noshows, err = storage.NoShows(30*time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(noshows) != 0 {
+		t.Fatalf("expected no no-shows got %v", noshows)
+	}
+}
+
+func TestMemorySnapshotEmpty(t *testing.T) {
+	storage := &memory{store: &nonstore{}, mail: &memtestMailer{}}
+
+	now := time.Now()
+
+	// an empty store and one holding only ID 0 both hide later adds
+	for i := 0; i < 2; i++ {
+		snapshot := storage.Snapshot()
+
+		err := storage.Add(&Reservation{
+			Resource: "resource",
+			Start:    now.Add(time.Duration(i+1) * time.Hour),
+			End:      now.Add(time.Duration(i+2) * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := storage.ListRange("", "all", "", "", time.Time{}, time.Time{}, 0, 10, snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res) != i {
+			t.Fatalf("snapshot %d: expected %d reservations got %d", snapshot, i, len(res))
+		}
+
+		res, err = storage.List("", "all", "", "", 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res) != i+1 {
+			t.Fatalf("expected %d reservations without a snapshot got %d", i+1, len(res))
+		}
+	}
+}
+
+func TestMemoryListRange(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	// resource D holds 111 [now+90s, now+100s)
+	tests := []struct {
+		name  string
+		from  time.Time
+		to    time.Time
+		count int
+	}{
+		{"ends at from", now.Add(100 * time.Second), time.Time{}, 0},
+		{"starts at to", time.Time{}, now.Add(90 * time.Second), 0},
+		{"overlaps from", now.Add(99 * time.Second), now.Add(200 * time.Second), 1},
+		{"overlaps to", now.Add(10 * time.Second), now.Add(91 * time.Second), 1},
+		{"within", now.Add(92 * time.Second), now.Add(95 * time.Second), 1},
+		{"unbounded", time.Time{}, time.Time{}, 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := storage.ListRange("resource D", "all", "", "", tc.from, tc.to, 0, 0, noSnapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(res) != tc.count {
+				t.Fatalf("expected %d reservations got %d", tc.count, len(res))
+			}
+		})
+	}
+
+	// loans have no end
+	res, err := storage.ListRange("resource X", "all", "", "", now.Add(time.Hour), time.Time{}, 0, 0, noSnapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 {
+		t.Fatalf("expected loan in window got %d reservations", len(res))
+	}
+}
+
+func TestMemoryRename(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	res, err := storage.Rename("resource C", "resource N")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("expected %d renamed got %d", 2, len(res))
+	}
+
+	list, _ := storage.List("resource N", "all", "", "", 0, 0)
+	if len(list) != 2 {
+		t.Fatalf("expected %d reservations under new name got %d", 2, len(list))
+	}
+
+	list, _ = storage.List("resource C", "all", "", "", 0, 0)
+	if len(list) != 0 {
+		t.Fatalf("expected no reservations under old name got %d", len(list))
+	}
+}
+
+func TestMemoryReconcile(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	now := time.Now().Round(time.Second)
+
+	for id := 1; id <= 3; id++ {
+		err = js.Add(&Reservation{
+			ID:       id,
+			Resource: "resource",
+			Start:    now.Add(time.Duration(id) * time.Hour),
+			End:      now.Add(time.Duration(id+1) * time.Hour),
+			Name:     "Some User",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := NewMemory(js, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := m.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diffs) != 0 {
+		t.Fatalf("expected no discrepancies got %d", len(diffs))
+	}
+
+	// edit the log behind the server's back
+	js.Update(2, &Reservation{
+		ID:       2,
+		Resource: "resource",
+		Start:    now.Add(2 * time.Hour),
+		End:      now.Add(3 * time.Hour),
+		Name:     "Some User",
+		Notes:    "edited",
+	})
+	js.Delete(3)
+	js.Add(&Reservation{
+		ID:       9,
+		Resource: "resource",
+		Start:    now.Add(9 * time.Hour),
+		End:      now.Add(10 * time.Hour),
+		Name:     "Another User",
+	})
+
+	diffs, err = m.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		id    int
+		issue string
+	}{
+		{2, "differs"},
+		{3, "missing from store"},
+		{9, "missing from memory"},
+	}
+
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d discrepancies got %d", len(expected), len(diffs))
+	}
+
+	for i, e := range expected {
+		if diffs[i].ID != e.id || diffs[i].Issue != e.issue {
+			t.Fatalf("expected %d \"%s\" got %d \"%s\"", e.id, e.issue, diffs[i].ID, diffs[i].Issue)
+		}
+	}
+
+	if len(m.reservations) != 3 || m.reservations[2].ID != 3 {
+		t.Fatal("memory changed without reload")
+	}
+
+	_, err = m.Reconcile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err = m.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diffs) != 0 {
+		t.Fatalf("expected no discrepancies after reload got %d", len(diffs))
+	}
+
+	res, err := m.GetById(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Notes != "edited" {
+		t.Fatalf("expected notes \"%s\" got \"%s\"", "edited", res.Notes)
+	}
+
+	if m.nextID != 10 {
+		t.Fatalf("expected next ID %d got %d", 10, m.nextID)
+	}
+}
+
+func TestMemoryReconcileEmail(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	now := time.Now().Round(time.Second)
+
+	err = js.Add(&Reservation{
+		ID:       1,
+		Resource: "resource",
+		Start:    now.Add(time.Hour),
+		End:      now.Add(2 * time.Hour),
+		Name:     "Another User",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMemory(js, mkmail(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// listing fills in the registered email
+	list, err := m.List("", "all", "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].Email != "another.user@company.com" {
+		t.Fatalf("expected reservation listed with email got %v", list)
+	}
+
+	diffs, err := m.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diffs) != 0 {
+		t.Fatalf("expected no discrepancies got %d", len(diffs))
+	}
+}
+
+func TestMemoryRenameConflict(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	// 111 on resource D overlaps 80 on resource C
+	_, err := storage.Rename("resource D", "resource C")
+	if err == nil {
+		t.Fatal("expected range conflict error")
+	}
+
+	if strings.Contains(err.Error(), "range conflict") == false {
+		t.Fatalf("expected \"range conflict\" error got \"%s\"", err.Error())
+	}
+
+	res, _ := storage.GetById(111)
+	if res.Resource != "resource D" {
+		t.Fatalf("expected reservation %d unchanged got resource \"%s\"", 111, res.Resource)
+	}
+
+	// resource X is on loan
+	_, err = storage.Rename("resource A", "resource X")
+	if err == nil || strings.Contains(err.Error(), "on loan") == false {
+		t.Fatalf("expected \"on loan\" error got %v", err)
+	}
+}
+
+func TestMemoryRenameHold(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	err := storage.Hold(&Reservation{
+		Resource: "resource N",
+		Start:    now.Add(95 * time.Second),
+		End:      now.Add(105 * time.Second),
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.Rename("resource C", "resource N")
+	if err == nil || strings.Contains(err.Error(), "range conflict") == false {
+		t.Fatalf("expected \"range conflict\" error got %v", err)
+	}
+
+	res, _ := storage.GetById(80)
+	if res.Resource != "resource C" {
+		t.Fatalf("expected reservation %d unchanged got resource \"%s\"", 80, res.Resource)
+	}
+}
+
+func TestMemoryRenameBlackout(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	all := []time.Weekday{
+		time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+		time.Thursday, time.Friday, time.Saturday,
+	}
+
+	storage.blackouts = &blackouts{
+		windows: []*Blackout{
+			&Blackout{ID: 1, Resource: "resource N", Days: all, Start: "00:00", End: "12:00"},
+			&Blackout{ID: 2, Resource: "resource N", Days: all, Start: "12:00", End: "00:00"},
+		},
+	}
+
+	_, err := storage.Rename("resource C", "resource N")
+	if err == nil || strings.Contains(err.Error(), "resource in blackout") == false {
+		t.Fatalf("expected \"resource in blackout\" error got %v", err)
+	}
+
+	res, _ := storage.GetById(80)
+	if res.Resource != "resource C" {
+		t.Fatalf("expected reservation %d unchanged got resource \"%s\"", 80, res.Resource)
+	}
+}
+
+func TestMemoryRenameCase(t *testing.T) {
+	storage, _ := fillMemory(true)
+	storage.casefold = true
+
+	res, err := storage.Rename("resource C", "Resource C")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("expected %d renamed got %d", 2, len(res))
+	}
+
+	for _, r := range res {
+		if r.Resource != "Resource C" {
+			t.Fatalf("expected resource \"%s\" got \"%s\"", "Resource C", r.Resource)
+		}
+	}
+}
+
+type slowstore struct {
+	nonstore
+	delay time.Duration
+}
+
+func (s *slowstore) Add(*Reservation) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func TestMemorySlowStore(t *testing.T) {
+	requestMetrics = newMetrics()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	store := &timedStore{store: &slowstore{delay: 20 * time.Millisecond}, threshold: 5 * time.Millisecond}
+
+	m, err := NewMemory(store, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	err = m.Add(&Reservation{Resource: "resource S", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// quick operations pass unremarked
+	err = m.Delete(0, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logged.String(), "slow store add took") {
+		t.Fatalf("expected slow add logged got \"%s\"", logged.String())
+	}
+
+	if strings.Contains(logged.String(), "slow store delete") {
+		t.Fatalf("expected fast delete not logged got \"%s\"", logged.String())
+	}
+
+	if requestMetrics.slow["add"] != 1 || requestMetrics.slow["delete"] != 0 {
+		t.Fatalf("expected one slow add counted got %v", requestMetrics.slow)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	requestMetrics.ServeHTTP(w, r)
+
+	exp := `reservations_store_slow_operations_total{op="add"} 1`
+	if !strings.Contains(w.Body.String(), exp) {
+		t.Fatalf("expected \"%s\" in metrics got \"%s\"", exp, w.Body.String())
+	}
+}
+
+func TestMemoryListUpcoming(t *testing.T) {
+	storage := &memory{store: &nonstore{}, mail: &memtestMailer{}}
+
+	now := time.Now()
+
+	storage.reservations = []*Reservation{
+		&Reservation{ID: 1, Resource: "resource A", Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+		&Reservation{ID: 2, Resource: "resource A", Start: now.Add(time.Minute), End: now.Add(2 * time.Hour)},
+		&Reservation{ID: 3, Resource: "resource B", Start: now.Add(4*time.Hour - time.Minute), End: now.Add(5 * time.Hour)},
+		&Reservation{ID: 4, Resource: "resource B", Start: now.Add(4*time.Hour + time.Minute), End: now.Add(6 * time.Hour)},
+		&Reservation{ID: 5, Resource: "resource C", Start: now.Add(2 * time.Hour), Loan: true},
+		&Reservation{ID: 6, Resource: "resource C", Start: now.Add(-3 * time.Hour), End: now.Add(-2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name   string
+		to     time.Time
+		expect []int
+	}{
+		{"unbounded", time.Time{}, []int{2, 3, 4, 5}},
+		{"within 4h", now.Add(4 * time.Hour), []int{2, 3, 5}},
+		{"within 1h", now.Add(time.Hour), []int{2}},
+		{"within 1m", now.Add(time.Minute), []int{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := storage.ListRange("", "upcoming", "", "", time.Time{}, tc.to, 0, 0, noSnapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]int, 0)
+			for _, r := range res {
+				ids = append(ids, r.ID)
+			}
+
+			if fmt.Sprint(ids) != fmt.Sprint(tc.expect) {
+				t.Fatalf("expected %v got %v", tc.expect, ids)
+			}
+		})
+	}
+
+	// starting now is upcoming, not yet current - a moment later it is
+	res := &Reservation{Start: now, End: now.Add(time.Hour)}
+
+	if !storage.shown(res, "upcoming", now) {
+		t.Fatal("reservation starting now not upcoming")
+	}
+
+	if storage.shown(res, "upcoming", now.Add(time.Nanosecond)) {
+		t.Fatal("started reservation upcoming")
+	}
+}
+
+func TestMemoryOpenEnded(t *testing.T) {
+	storage := &memory{store: &nonstore{}, mail: &memtestMailer{}, maxDuration: 24 * time.Hour}
+
+	now := time.Now()
+
+	open := &Reservation{Resource: "resource O", Start: now.Add(time.Hour), OpenEnded: true, Name: "Some User"}
+
+	err := storage.Add(open)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !open.End.Equal(openEnd) {
+		t.Fatalf("expected end %s got %s", openEnd, open.End)
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		end   time.Time
+		fail  string
+	}{
+		{"before", now, now.Add(30 * time.Minute), ""},
+		{"up to start", now.Add(30 * time.Minute), now.Add(time.Hour), ""},
+		{"into", now.Add(50 * time.Minute), now.Add(2 * time.Hour), "open-ended from"},
+		{"far later", now.Add(1000 * time.Hour), now.Add(1001 * time.Hour), "open-ended from"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := storage.Add(&Reservation{Resource: "resource O", Start: tc.start, End: tc.end})
+
+			if tc.fail == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) || conflict.With.ID != open.ID || !strings.Contains(err.Error(), tc.fail) {
+				t.Fatalf("expected conflict with %d got %v", open.ID, err)
+			}
+		})
+	}
+
+	err = storage.Add(&Reservation{Resource: "resource P", Start: now, OpenEnded: true, Loan: true})
+	if err == nil || err.Error() != "open-ended reservation can't be a loan" {
+		t.Fatalf("expected open-ended loan refused got %v", err)
+	}
+
+	// no waiting out an open-ended blocker
+	late := &Reservation{Resource: "resource O", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)}
+
+	err = storage.Waitlist(late)
+	if err == nil || !strings.Contains(err.Error(), "range conflict") {
+		t.Fatalf("expected waitlist refused got %v", err)
+	}
+}
+
+func TestMemoryOpenEndedList(t *testing.T) {
+	storage := &memory{store: &nonstore{}, mail: &memtestMailer{}}
+
+	now := time.Now()
+
+	storage.reservations = []*Reservation{
+		&Reservation{ID: 1, Resource: "resource O", Start: now.Add(-48 * time.Hour), End: openEnd, OpenEnded: true},
+		&Reservation{ID: 2, Resource: "resource P", Start: now.Add(time.Hour), End: openEnd, OpenEnded: true},
+		&Reservation{ID: 3, Resource: "resource Q", Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		show   string
+		expect []int
+	}{
+		{"current", []int{1}},
+		{"active", []int{1, 2}},
+		{"history", []int{3}},
+		{"upcoming", []int{2}},
+	}
+
+	list := func(show string) []int {
+		res, err := storage.List("", show, "", "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := make([]int, 0)
+		for _, r := range res {
+			ids = append(ids, r.ID)
+		}
+
+		return ids
+	}
+
+	for _, tc := range tests {
+		ids := list(tc.show)
+		if fmt.Sprint(ids) != fmt.Sprint(tc.expect) {
+			t.Fatalf("show %s: expected %v got %v", tc.show, tc.expect, ids)
+		}
+	}
+
+	// current until ended
+	err := storage.Delete(1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, _ := storage.GetById(1)
+	if res.OpenEnded || res.End.After(time.Now()) {
+		t.Fatalf("expected reservation ended got end %s open-ended %t", res.End, res.OpenEnded)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if ids := list("history"); fmt.Sprint(ids) != "[1 3]" {
+		t.Fatalf("expected ended reservation in history got %v", ids)
+	}
+}
+
+func TestMemoryOpenEndedBlackout(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	day := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 0, 0, 0, 0, time.Local)
+
+	storage.blackouts = &blackouts{
+		windows: []*Blackout{
+			&Blackout{
+				ID:       1,
+				Resource: "resource O",
+				Days:     []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+				Start:    "01:00",
+				End:      "03:00",
+				Reason:   "backups",
+			},
+		},
+	}
+
+	// only the start is checked, later windows are left to the holder
+	for _, start := range []time.Duration{time.Hour, 2 * time.Hour} {
+		err := storage.Add(&Reservation{Resource: "resource O", Start: day.Add(start), OpenEnded: true})
+		if err == nil || !strings.Contains(err.Error(), "resource in blackout") {
+			t.Fatalf("start %s: expected blackout error got %v", start, err)
+		}
+	}
+
+	err := storage.Add(&Reservation{Resource: "resource O", Start: day.Add(3 * time.Hour), OpenEnded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryOpenEndedUpdate(t *testing.T) {
+	storage := &memory{store: &nonstore{}, mail: &memtestMailer{}}
+
+	now := time.Now()
+
+	open := &Reservation{ID: 1, Resource: "resource O", Start: now.Add(time.Hour), End: openEnd, OpenEnded: true}
+	fixed := &Reservation{ID: 2, Resource: "resource P", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}
+
+	storage.reservations = []*Reservation{open, fixed}
+
+	req := *fixed
+	req.OpenEnded = true
+
+	_, err := storage.Update(2, &req)
+	if err == nil || err.Error() != "converting to open-ended" {
+		t.Fatalf("expected conversion refused got %v", err)
+	}
+
+	req = *open
+	req.OpenEnded = false
+
+	_, err = storage.Update(1, &req)
+	if err == nil || err.Error() != "closing open-ended reservation without an end" {
+		t.Fatalf("expected close without end refused got %v", err)
+	}
+
+	// a notes change leaves it open
+	req = *open
+	req.Notes = "until the firmware bug is found"
+
+	res, err := storage.Update(1, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.OpenEnded || !res.End.Equal(openEnd) {
+		t.Fatalf("expected still open-ended got end %s", res.End)
+	}
+
+	// patching in an end closes it
+	req = *res
+
+	_, err = MergePatch(&req, []byte(fmt.Sprintf(`{"end":"%s"}`, now.Add(3*time.Hour).Format(time.RFC3339Nano))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = storage.Update(1, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.OpenEnded || !res.End.Equal(now.Add(3*time.Hour)) {
+		t.Fatalf("expected closed at %s got end %s open-ended %t", now.Add(3*time.Hour), res.End, res.OpenEnded)
+	}
+}
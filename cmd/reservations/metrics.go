@@ -0,0 +1,117 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// request counts and latency, exposed in Prometheus text format
+
+// upper bounds of the latency histogram in seconds
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricKey struct {
+	method string
+	status int
+}
+
+type metrics struct {
+	requests map[metricKey]uint64
+	buckets  []uint64 // cumulative counts per latencyBuckets entry
+	count    uint64
+	sum      float64
+	slow     map[string]uint64 // backing store operations over threshold
+	sync.Mutex
+}
+
+var requestMetrics = newMetrics()
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[metricKey]uint64),
+		buckets:  make([]uint64, len(latencyBuckets)),
+		slow:     make(map[string]uint64),
+	}
+}
+
+func (m *metrics) slowStore(op string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.slow[op]++
+}
+
+func (m *metrics) observe(method string, status int, latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.requests[metricKey{method: method, status: status}]++
+
+	secs := latency.Seconds()
+
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			m.buckets[i]++
+		}
+	}
+
+	m.count++
+	m.sum += secs
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	keys := make([]metricKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP reservations_http_requests_total HTTP requests by method and status.")
+	fmt.Fprintln(w, "# TYPE reservations_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "reservations_http_requests_total{method=\"%s\",status=\"%d\"} %d\n", k.method, k.status, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP reservations_http_request_duration_seconds HTTP request latency.")
+	fmt.Fprintln(w, "# TYPE reservations_http_request_duration_seconds histogram")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "reservations_http_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "reservations_http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "reservations_http_request_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "reservations_http_request_duration_seconds_count %d\n", m.count)
+
+	ops := make([]string, 0, len(m.slow))
+	for op := range m.slow {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintln(w, "# HELP reservations_store_slow_operations_total Backing store operations over the slow threshold.")
+	fmt.Fprintln(w, "# TYPE reservations_store_slow_operations_total counter")
+	for _, op := range ops {
+		fmt.Fprintf(w, "reservations_store_slow_operations_total{op=\"%s\"} %d\n", op, m.slow[op])
+	}
+}
@@ -0,0 +1,48 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	requestMetrics = newMetrics()
+
+	for _, code := range []int{http.StatusOK, http.StatusOK, http.StatusNotFound} {
+		r, _ := http.NewRequest(http.MethodGet, "path/to/file", nil)
+		w := httptest.NewRecorder()
+		handler := logger(&logtest{code: code, content: "application/json"})
+		handler.ServeHTTP(w, r)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	routes(nil, nil, nil).ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code %d got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	out := string(b)
+
+	expect := []string{
+		`reservations_http_requests_total{method="GET",status="200"} 2`,
+		`reservations_http_requests_total{method="GET",status="404"} 1`,
+		`reservations_http_request_duration_seconds_bucket{le="+Inf"} 3`,
+		`reservations_http_request_duration_seconds_count 3`,
+	}
+
+	for _, line := range expect {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected \"%s\" in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+// mirror changes to every store, in order - a change is written to all
+// stores even when one fails, the failures reported together
+type multiStore struct {
+	stores []BackingStore
+}
+
+func (s *multiStore) Add(res *Reservation) error {
+	return s.each(func(store BackingStore) error { return store.Add(res) })
+}
+
+func (s *multiStore) Update(ref int, res *Reservation) error {
+	return s.each(func(store BackingStore) error { return store.Update(ref, res) })
+}
+
+func (s *multiStore) Delete(ref int) error {
+	return s.each(func(store BackingStore) error { return store.Delete(ref) })
+}
+
+func (s *multiStore) Restore(res *Reservation) error {
+	return s.each(func(store BackingStore) error { return store.Restore(res) })
+}
+
+// replay the first store read without error, a store failing part way
+// leaves nothing behind for the next to replay over
+func (s *multiStore) ReadLog(m *memory) error {
+	errs := make([]string, 0)
+
+	for i, store := range s.stores {
+		err := store.ReadLog(m)
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("store %d read: %v", i+1, err)
+		errs = append(errs, fmt.Sprintf("store %d: %v", i+1, err))
+
+		m.reservations = make([]*Reservation, 0)
+		m.nextID = 0
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(errs, "; "))
+}
+
+// history from the first store able to give one
+func (s *multiStore) History(ref int) ([]*HistoryEntry, error) {
+	errs := make([]string, 0)
+
+	for i, store := range s.stores {
+		hist, err := store.History(ref)
+		if err == nil {
+			return hist, nil
+		}
+
+		errs = append(errs, fmt.Sprintf("store %d: %v", i+1, err))
+	}
+
+	if len(errs) == 0 {
+		return make([]*HistoryEntry, 0), nil
+	}
+
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+func (s *multiStore) each(op func(BackingStore) error) error {
+	errs := make([]string, 0)
+
+	for i, store := range s.stores {
+		err := op(store)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("store %d: %v", i+1, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(errs, "; "))
+}
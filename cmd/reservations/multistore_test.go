@@ -0,0 +1,140 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+// a store keeping its log in memory, failing every call once fail is set
+type fakestore struct {
+	ops  []string
+	log  []*Reservation
+	fail error
+}
+
+func (s *fakestore) record(op string, ref int) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.ops = append(s.ops, fmt.Sprintf("%s %d", op, ref))
+	return nil
+}
+
+func (s *fakestore) Add(res *Reservation) error {
+	err := s.record("add", res.ID)
+	if err == nil {
+		s.log = append(s.log, res)
+	}
+	return err
+}
+
+func (s *fakestore) Update(ref int, res *Reservation) error { return s.record("update", ref) }
+func (s *fakestore) Delete(ref int) error                   { return s.record("delete", ref) }
+func (s *fakestore) Restore(res *Reservation) error         { return s.record("restore", res.ID) }
+
+func (s *fakestore) ReadLog(m *memory) error {
+	if s.fail != nil {
+		m.reservations = append(m.reservations, &Reservation{ID: 99})
+		return s.fail
+	}
+
+	m.reservations = append(m.reservations, s.log...)
+	return nil
+}
+
+func (s *fakestore) History(int) ([]*HistoryEntry, error) {
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	return make([]*HistoryEntry, len(s.ops)), nil
+}
+
+func TestMultiStoreFanOut(t *testing.T) {
+	first, second := &fakestore{}, &fakestore{}
+	store := &multiStore{stores: []BackingStore{first, second}}
+
+	calls := []func() error{
+		func() error { return store.Add(&Reservation{ID: 1}) },
+		func() error { return store.Update(1, &Reservation{ID: 1}) },
+		func() error { return store.Restore(&Reservation{ID: 2}) },
+		func() error { return store.Delete(2) },
+	}
+
+	for _, call := range calls {
+		if err := call(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := "add 1,update 1,restore 2,delete 2"
+
+	for i, s := range []*fakestore{first, second} {
+		ops := strings.Join(s.ops, ",")
+		if ops != expect {
+			t.Fatalf("store %d: expected %q got %q", i+1, expect, ops)
+		}
+	}
+}
+
+func TestMultiStoreFailure(t *testing.T) {
+	first, second := &fakestore{}, &fakestore{fail: errors.New("disk full")}
+	store := &multiStore{stores: []BackingStore{first, second}}
+
+	err := store.Add(&Reservation{ID: 1})
+	if err == nil || err.Error() != "store 2: disk full" {
+		t.Fatalf("expected store 2 failure got %v", err)
+	}
+
+	if len(first.ops) != 1 {
+		t.Fatalf("expected the healthy store written, %d ops", len(first.ops))
+	}
+
+	first.fail = errors.New("read only")
+
+	err = store.Delete(1)
+	if err == nil || err.Error() != "store 1: read only; store 2: disk full" {
+		t.Fatalf("expected both failures got %v", err)
+	}
+}
+
+func TestMultiStoreReadLog(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	first := &fakestore{fail: errors.New("stale handle")}
+	second := &fakestore{log: []*Reservation{&Reservation{ID: 4, Resource: "resource A"}}}
+
+	m, err := NewMemory(&multiStore{stores: []BackingStore{first, second}}, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.reservations) != 1 || m.reservations[0].ID != 4 {
+		t.Fatalf("expected the second store replayed alone, got %d reservations", len(m.reservations))
+	}
+
+	if m.nextID != 5 {
+		t.Fatalf("expected next ID 5 got %d", m.nextID)
+	}
+
+	if !strings.Contains(logged.String(), "store 1 read: stale handle") {
+		t.Fatalf("expected first store failure logged: %s", logged.String())
+	}
+
+	second.fail = errors.New("gone")
+
+	_, err = NewMemory(&multiStore{stores: []BackingStore{first, second}}, &memtestMailer{}, nil)
+	if err == nil || err.Error() != "store 1: stale handle; store 2: gone" {
+		t.Fatalf("expected both read failures got %v", err)
+	}
+}
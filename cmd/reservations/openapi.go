@@ -0,0 +1,31 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// the API as an OpenAPI 3 document, kept by hand alongside the handlers
+//
+//go:embed openapi.json
+var openapiDoc []byte
+
+func openapi(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(openapiDoc)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(openapiDoc)
+}
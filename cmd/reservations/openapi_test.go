@@ -0,0 +1,84 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestOpenAPI(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/v3/openapi.json", nil)
+	w := httptest.NewRecorder()
+	routes(nil, nil, nil).ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+	}
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected content type \"application/json\" got \"%s\"", resp.Header.Get("Content-Type"))
+	}
+
+	doc := struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}{}
+
+	err := json.NewDecoder(resp.Body).Decode(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("expected OpenAPI 3 got \"%s\"", doc.OpenAPI)
+	}
+
+	paths := []string{
+		V3api,
+		V3api + "{id}",
+		V3api + "{id}/history",
+		V3api + "hold",
+		V3mail,
+		V3mail + "/{uuid}",
+		V3blackout,
+		V3version,
+	}
+
+	for _, p := range paths {
+		if _, ok := doc.Paths[p]; !ok {
+			t.Fatalf("expected path \"%s\"", p)
+		}
+	}
+
+	schema, ok := doc.Components.Schemas["Reservation"]
+	if !ok {
+		t.Fatal("expected reservation schema")
+	}
+
+	// the schema follows the api package, field for field
+	typ := reflect.TypeOf(Reservation{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := schema.Properties[name]; !ok {
+			t.Fatalf("reservation schema missing \"%s\"", name)
+		}
+	}
+
+	if len(schema.Properties) != typ.NumField() {
+		t.Fatalf("expected %d reservation properties got %d", typ.NumField(), len(schema.Properties))
+	}
+}
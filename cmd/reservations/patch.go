@@ -5,6 +5,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -19,7 +20,10 @@ func MergePatch(res *Reservation, patch []byte) (int, error) {
 		return http.StatusBadRequest, err
 	}
 
-	m := p.(map[string]interface{})
+	m, ok := p.(map[string]interface{})
+	if !ok {
+		return http.StatusBadRequest, errors.New("patch not a JSON object")
+	}
 
 	for k, v := range m {
 		switch vv := v.(type) {
@@ -59,9 +63,43 @@ func MergePatch(res *Reservation, patch []byte) (int, error) {
 				res.Loan = vv
 			case "share":
 				res.Share = vv
+			case "flexible":
+				res.Flexible = vv
+			case "openEnded":
+				res.OpenEnded = vv
+			default:
+				return http.StatusBadRequest, errors.New("unknown field name")
+			}
+		case []interface{}:
+			switch k {
+			case "links":
+				res.Links, err = stringList("link", vv)
+			case "tags":
+				res.Tags, err = stringList("tag", vv)
+			default:
+				return http.StatusBadRequest, errors.New("unknown field name")
+			}
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+
+		case nil:
+			// null clears an optional field, RFC 7386
+			switch k {
+			case "notes":
+				res.Notes = ""
+			case "initials":
+				res.Initials = ""
+			case "links":
+				res.Links = nil
+			case "tags":
+				res.Tags = nil
+			case "resource", "start", "end", "name", "loan", "share", "flexible", "openEnded":
+				return http.StatusBadRequest, fmt.Errorf("%s can't be cleared", k)
 			default:
 				return http.StatusBadRequest, errors.New("unknown field name")
 			}
+
 		default:
 			return http.StatusBadRequest, errors.New("unknown field type")
 		}
@@ -69,3 +107,18 @@ func MergePatch(res *Reservation, patch []byte) (int, error) {
 
 	return http.StatusOK, nil
 }
+
+// an array in a patch replaces the whole list, each element a string
+func stringList(what string, v []interface{}) ([]string, error) {
+	list := make([]string, 0, len(v))
+
+	for _, e := range v {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s not a string", what)
+		}
+		list = append(list, s)
+	}
+
+	return list, nil
+}
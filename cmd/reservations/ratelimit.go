@@ -0,0 +1,166 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fixed window request limiter - at most limit requests per key in each
+// window, a nil limiter allows everything
+type limiter struct {
+	limit  int
+	window time.Duration
+	start  time.Time
+	counts map[string]int
+	sync.Mutex
+}
+
+func newLimiter(limit int, window time.Duration) *limiter {
+	return &limiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+	}
+}
+
+func (l *limiter) allow(key string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.counts = make(map[string]int)
+	}
+
+	if l.counts[key] >= l.limit {
+		return false
+	}
+
+	l.counts[key]++
+
+	return true
+}
+
+// client address without the port, for keying limits
+func remoteHost(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+
+	return host
+}
+
+// token bucket limiter - each key earns rate tokens a second up to
+// burst and a request spends one
+type tokenLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets kept before full ones are dropped
+const maxBuckets = 4096
+
+func newTokenLimiter(rate, burst int) *tokenLimiter {
+	return &tokenLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// take a token for key, when none is left report the wait for the next
+func (l *tokenLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// forget keys whose buckets have refilled, they start full anyway
+func (l *tokenLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// per client request limit, nil for none
+var requestLimit *tokenLimiter
+
+// key clients on the first X-Forwarded-For address, only safe behind a
+// proxy that sets it
+var trustProxy bool
+
+func clientKey(r *http.Request) string {
+	if trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	return remoteHost(r.RemoteAddr)
+}
+
+// refuse clients over the request limit with 429 and the seconds until
+// they may try again
+func throttle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestLimit != nil {
+			ok, wait := requestLimit.take(clientKey(r), time.Now())
+			if !ok {
+				secs := int(math.Ceil(wait.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+
+				w.Header().Set("Retry-After", strconv.Itoa(secs))
+				httpError(w, r, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
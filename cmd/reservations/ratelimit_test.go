@@ -0,0 +1,98 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	savedLimit, savedTrust := requestLimit, trustProxy
+	defer func() { requestLimit, trustProxy = savedLimit, savedTrust }()
+
+	requestLimit = newTokenLimiter(1, 3)
+	trustProxy = false
+
+	handler := throttle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	get := func(remote, forwarded string) *http.Response {
+		r, _ := http.NewRequest(http.MethodGet, "/v3/reservations/", nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp := get("10.0.0.1:1234", ""); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: expected status code %d got %d", i, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	resp := get("10.0.0.1:5678", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 1 {
+		t.Fatalf("expected Retry-After in seconds got \"%s\"", resp.Header.Get("Retry-After"))
+	}
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON error body got \"%s\"", resp.Header.Get("Content-Type"))
+	}
+
+	if resp := get("10.0.0.2:1234", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("other client: expected status code %d got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// without trust a forwarded address does not change the key
+	if resp := get("10.0.0.1:1234", "192.168.1.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("untrusted proxy: expected status code %d got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	trustProxy = true
+
+	for i := 0; i < 3; i++ {
+		if resp := get("10.0.0.1:1234", "192.168.1.1, 10.0.0.1"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("forwarded %d: expected status code %d got %d", i, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	if resp := get("10.0.0.3:1234", "192.168.1.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("forwarded: expected status code %d got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
+
+func TestTokenLimiterRefill(t *testing.T) {
+	l := newTokenLimiter(2, 1)
+	now := time.Now()
+
+	if ok, _ := l.take("a", now); !ok {
+		t.Fatal("first request refused")
+	}
+
+	ok, wait := l.take("a", now)
+	if ok {
+		t.Fatal("expected request over the limit refused")
+	}
+
+	if wait != 500*time.Millisecond {
+		t.Fatalf("expected wait %s got %s", 500*time.Millisecond, wait)
+	}
+
+	if ok, _ := l.take("a", now.Add(wait)); !ok {
+		t.Fatal("expected request allowed after refill")
+	}
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,13 +15,17 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	. "github.com/dbulkow/reservations/api"
+	"github.com/dbulkow/reservations/internal/buildinfo"
 	"github.com/dbulkow/reservations/internal/getenv"
-	_ "github.com/dbulkow/reservations/internal/gzip"
+	Gzip "github.com/dbulkow/reservations/internal/gzip"
 )
 
 // favicon from from http://clipartbarn.com/clock-clip-art_36285/
@@ -40,14 +45,62 @@ func run(args []string, stdout, stderr io.Writer) error {
 
 		datafile = env.Get("DATA", "reservations.jsonl")
 		mailfile = env.Get("MAIL", "mail.json")
+		blackout = env.Get("BLACKOUT", "blackout.json")
+		compact  = env.GetBool("COMPACT", false)
+		owners   = env.GetBool("OWNERS", false)
+		adminstr = env.Get("ADMINS", "")
+		noshow   = env.Get("NOSHOW", "")
+		eod      = env.Get("EOD", "17:00")
+		queuestr = env.Get("MAXQUEUED", "")
+		sharestr = env.Get("SHARED", "")
+		maxbody  = env.GetInt("MAXBODY", v3MaxRead)
+		rtimeout = env.GetInt("READTIMEOUT", 60)
+		wtimeout = env.GetInt("WRITETIMEOUT", 60)
+		logfmt   = env.Get("LOGFORMAT", "text")
+		pastend  = env.GetBool("REJECTPASTEND", true)
+		readonly = env.GetBool("READONLY", false)
+		casefold = env.GetBool("CASEFOLD", false)
+		maxdur   = env.Get("MAXDURATION", "")
+		btimeout = env.GetInt("BODYTIMEOUT", 0)
+		rate     = env.GetInt("RATE", 0)
+		trusted  = env.GetBool("TRUSTPROXY", false)
+		strict   = env.GetBool("STRICTLOG", true)
+		minflex  = env.Get("MINFLEXIBLE", "1h")
+		slowstr  = env.Get("SLOWSTORE", "1s")
+		undostr  = env.Get("UNDO", "1h")
+		corsstr  = env.Get("CORS", "")
 	)
 
 	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
 
 	flags.StringVar(&port, "port", port, "REST/HTTP port number")
 	flags.StringVar(&addr, "addr", addr, "Listen address")
-	flags.StringVar(&datafile, "data", datafile, "Backing store filename")
+	flags.StringVar(&datafile, "data", datafile, "Backing store filename, comma separated to mirror")
 	flags.StringVar(&mailfile, "mail", mailfile, "Mail registration filename")
+	flags.StringVar(&blackout, "blackout", blackout, "Resource blackout calendar filename")
+	flags.BoolVar(&compact, "compact", compact, "Compact backing store at startup")
+	flags.BoolVar(&owners, "owners", owners, "Only allow owners and admins to modify reservations")
+	flags.StringVar(&adminstr, "admins", adminstr, "Comma separated list of admin names")
+	flags.StringVar(&noshow, "noshow", noshow, "End reservations not checked in within this duration of start")
+	flags.StringVar(&eod, "eod", eod, "End of day for rest of day reservations (HH:MM or midnight)")
+	flags.StringVar(&queuestr, "maxqueued", queuestr, "Comma separated list of resource=count limits on future reservations")
+	flags.StringVar(&sharestr, "shared", sharestr, "Comma separated list of resources shared by default")
+	flags.IntVar(&maxbody, "maxbody", maxbody, "Maximum request body size in bytes")
+	flags.IntVar(&rtimeout, "readtimeout", rtimeout, "HTTP read timeout in seconds")
+	flags.IntVar(&wtimeout, "writetimeout", wtimeout, "HTTP write timeout in seconds")
+	flags.IntVar(&btimeout, "bodytimeout", btimeout, "Request header and change body read timeout in seconds, 0 for none")
+	flags.StringVar(&logfmt, "logformat", logfmt, "Request log format [text, json]")
+	flags.BoolVar(&pastend, "rejectpastend", pastend, "Reject updates ending in the past for reservations yet to start")
+	flags.StringVar(&maxdur, "maxduration", maxdur, "Longest reservation allowed, loans exempt (e.g. 720h)")
+	flags.BoolVar(&casefold, "casefold", casefold, "Match resource names regardless of case")
+	flags.BoolVar(&readonly, "readonly", readonly, "Serve reads only, refusing changes (SIGUSR2 toggles at runtime)")
+	flags.IntVar(&rate, "rate", rate, "Requests per second allowed each client, 0 for no limit")
+	flags.BoolVar(&trusted, "trustproxy", trusted, "Identify clients by X-Forwarded-For for rate limits")
+	flags.BoolVar(&strict, "strictlog", strict, "Refuse to start on an unreadable backing store record, false skips them")
+	flags.StringVar(&minflex, "minflexible", minflex, "Shortest a flexible reservation is trimmed to for a later booking")
+	flags.StringVar(&slowstr, "slowstore", slowstr, "Log backing store operations taking longer, 0 for none")
+	flags.StringVar(&undostr, "undo", undostr, "Deleted future reservations may be restored for this long, 0 for never")
+	flags.StringVar(&corsstr, "cors", corsstr, "Comma separated list of origins browsers may call the API from, * for any")
 
 	flags.Usage = func() {
 		fmt.Fprintf(stderr, "Usage: %s\n", args[0])
@@ -58,10 +111,58 @@ func run(args []string, stdout, stderr io.Writer) error {
   RESERVATIONS_ADDR = %s
         Network listen address
   RESERVATIONS_DATA = %s
-        Backing store filename
+        Backing store filename, a comma separated list mirrors the log
   RESERVATIONS_MAIL = %s
         Mail registrations filename
-`, port, addr, datafile, mailfile)
+  RESERVATIONS_BLACKOUT = %s
+        Resource blackout calendar filename
+  RESERVATIONS_COMPACT = %t
+        Compact backing store at startup (SIGUSR1 compacts at runtime)
+  RESERVATIONS_OWNERS = %t
+        Only allow owners and admins to modify reservations
+  RESERVATIONS_ADMINS = %s
+        Comma separated list of admin names
+  RESERVATIONS_NOSHOW = %s
+        End reservations not checked in within this duration of start (e.g. 30m)
+  RESERVATIONS_EOD = %s
+        End of day for rest of day reservations (HH:MM or midnight)
+  RESERVATIONS_MAXQUEUED = %s
+        Comma separated list of resource=count limits on future reservations
+  RESERVATIONS_SHARED = %s
+        Comma separated list of resources shared by default
+  RESERVATIONS_MAXBODY = %d
+        Maximum request body size in bytes
+  RESERVATIONS_READTIMEOUT = %d
+        HTTP read timeout in seconds
+  RESERVATIONS_WRITETIMEOUT = %d
+        HTTP write timeout in seconds
+  RESERVATIONS_BODYTIMEOUT = %d
+        Request header and change body read timeout in seconds, 0 for none
+  RESERVATIONS_LOGFORMAT = %s
+        Request log format (text or json)
+  RESERVATIONS_REJECTPASTEND = %t
+        Reject updates ending in the past for reservations yet to start
+  RESERVATIONS_READONLY = %t
+        Serve reads only, refusing changes (SIGUSR2 toggles at runtime)
+  RESERVATIONS_CASEFOLD = %t
+        Match resource names regardless of case
+  RESERVATIONS_MAXDURATION = %s
+        Longest reservation allowed, loans exempt (e.g. 720h)
+  RESERVATIONS_RATE = %d
+        Requests per second allowed each client, 0 for no limit
+  RESERVATIONS_TRUSTPROXY = %t
+        Identify clients by X-Forwarded-For for rate limits
+  RESERVATIONS_STRICTLOG = %t
+        Refuse to start on an unreadable backing store record, false skips them
+  RESERVATIONS_MINFLEXIBLE = %s
+        Shortest a flexible reservation is trimmed to for a later booking
+  RESERVATIONS_SLOWSTORE = %s
+        Log backing store operations taking longer, 0 for none
+  RESERVATIONS_UNDO = %s
+        Deleted future reservations may be restored for this long, 0 for never
+  RESERVATIONS_CORS = %s
+        Comma separated list of origins browsers may call the API from, * for any
+`, port, addr, datafile, mailfile, blackout, compact, owners, adminstr, noshow, eod, queuestr, sharestr, maxbody, rtimeout, wtimeout, btimeout, logfmt, pastend, readonly, casefold, maxdur, rate, trusted, strict, minflex, slowstr, undostr, corsstr)
 		flags.PrintDefaults()
 	}
 
@@ -72,50 +173,275 @@ func run(args []string, stdout, stderr io.Writer) error {
 
 	// report version details
 
-	log.Printf("git commit hash: %s\n", GitHash)
-	log.Printf("build time:      %s\n", BuildTime)
+	hash, built := buildinfo.Version(GitHash, BuildTime)
+
+	log.Printf("git commit hash: %s\n", hash)
+	log.Printf("build time:      %s\n", built)
 
 	// server initialization
 
+	if logfmt != "text" && logfmt != "json" {
+		return fmt.Errorf("logformat: unknown format \"%s\"", logfmt)
+	}
+
+	logFormat = logfmt
+
+	checkOwner = owners
+	rejectPastEnd = pastend
+	setReadOnly(readonly)
+	bodyTimeout = time.Duration(btimeout) * time.Second
+	trustProxy = trusted
+
+	if rate < 0 {
+		return fmt.Errorf("rate: %d is negative", rate)
+	}
+
+	if rate > 0 {
+		requestLimit = newTokenLimiter(rate, rate)
+	}
+
+	for _, name := range strings.Split(adminstr, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			admins[name] = true
+		}
+	}
+
+	for _, origin := range strings.Split(corsstr, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			corsOrigins[origin] = true
+		}
+	}
+
+	var grace time.Duration
+
+	if noshow != "" {
+		grace, err = time.ParseDuration(noshow)
+		if err != nil {
+			return fmt.Errorf("noshow: %v", err)
+		}
+	}
+
+	var maxDuration time.Duration
+
+	if maxdur != "" {
+		maxDuration, err = time.ParseDuration(maxdur)
+		if err != nil {
+			return fmt.Errorf("maxduration: %v", err)
+		}
+	}
+
+	minFlexible, err := time.ParseDuration(minflex)
+	if err != nil {
+		return fmt.Errorf("minflexible: %v", err)
+	}
+
+	slowStore, err := time.ParseDuration(slowstr)
+	if err != nil {
+		return fmt.Errorf("slowstore: %v", err)
+	}
+
+	undoWindow, err := time.ParseDuration(undostr)
+	if err != nil {
+		return fmt.Errorf("undo: %v", err)
+	}
+
+	if eod == "midnight" {
+		endOfDay = 24 * time.Hour
+	} else {
+		endOfDay, err = clock(eod)
+		if err != nil {
+			return fmt.Errorf("eod: %v", err)
+		}
+	}
+
+	maxQueued := make(map[string]int)
+
+	for _, limit := range strings.Split(queuestr, ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+
+		i := strings.LastIndex(limit, "=")
+		if i < 0 {
+			return fmt.Errorf("maxqueued: \"%s\" not resource=count", limit)
+		}
+
+		count, err := strconv.Atoi(limit[i+1:])
+		if err != nil || count < 0 {
+			return fmt.Errorf("maxqueued: \"%s\" not resource=count", limit)
+		}
+
+		maxQueued[strings.TrimSpace(limit[:i])] = count
+	}
+
+	shared := make(map[string]bool)
+
+	for _, resource := range strings.Split(sharestr, ",") {
+		resource = strings.TrimSpace(resource)
+		if resource != "" {
+			shared[resource] = true
+		}
+	}
+
 	ctxt, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	var jobs sync.WaitGroup
 
 	// filename := fmt.Sprintf("%s-%s", prefix, time.Now().Format("20060102"))
-	file, err := NewJSONL(datafile)
+
+	// each of a comma separated list of files mirrors the log, the
+	// first readable is replayed at startup
+	files := make([]*jsonl, 0)
+
+	for _, filename := range strings.Split(datafile, ",") {
+		filename = strings.TrimSpace(filename)
+		if filename == "" {
+			continue
+		}
+
+		file, err := NewJSONL(filename)
+		if err != nil {
+			return err
+		}
+
+		file.tolerant = !strict
+		file.maxBody = maxbody
+
+		if compact {
+			err = file.Compact()
+			if err != nil {
+				return fmt.Errorf("compact: %v", err)
+			}
+
+			log.Printf("compacted %s", filename)
+		}
+
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		return errors.New("no backing store filename")
+	}
+
+	mail, err := NewMail(mailfile, "" /*server*/, "" /*port*/, "" /*from*/)
 	if err != nil {
 		return err
 	}
 
-	mail, err := NewMail(mailfile, "" /*server*/, "" /*port*/, "" /*from*/)
+	blackouts, err := NewBlackouts(blackout)
 	if err != nil {
 		return err
 	}
 
-	storage, err := NewMemory(file, mail)
+	var store BackingStore = files[0]
+
+	if len(files) > 1 {
+		mirror := &multiStore{}
+		for _, file := range files {
+			mirror.stores = append(mirror.stores, file)
+		}
+		store = mirror
+	}
+
+	if slowStore > 0 {
+		store = &timedStore{store: store, threshold: slowStore}
+	}
+
+	storage, err := NewMemory(store, mail, blackouts)
 	if err != nil {
 		return err
 	}
 
+	storage.casefold = casefold
+	storage.maxDuration = maxDuration
+	storage.minFlexible = minFlexible
+	storage.undoWindow = undoWindow
+	storage.maxQueued = make(map[string]int)
+	storage.shared = make(map[string]bool)
+
+	for resource, count := range maxQueued {
+		storage.maxQueued[storage.resourceKey(resource)] = count
+	}
+
+	for resource := range shared {
+		storage.shared[storage.resourceKey(resource)] = true
+	}
+
+	// the log is read, reservations can be served
+	setReady(true)
+
 	// XXX load from backing store
 
 	// http routes
 
-	mux := http.NewServeMux()
-	mux.Handle("/", logger(http.FileServer(http.FS(assets))))
-	mux.Handle("/help", logger(http.HandlerFunc(usage)))
-	mux.Handle(V3api, logger(http.StripPrefix(V3api, http.HandlerFunc(v3res(storage)))))
-	mux.Handle(V3mail, logger(mail.rest()))
-	mux.Handle(V3mail+"/", logger(mail.rest()))
+	config := &serverConfig{
+		Port:          port,
+		Addr:          addr,
+		Data:          datafile,
+		Mail:          mailfile,
+		Blackout:      blackout,
+		Compact:       compact,
+		Owners:        owners,
+		Admins:        make([]string, 0, len(admins)),
+		NoShow:        noshow,
+		EOD:           eod,
+		MaxQueued:     maxQueued,
+		Shared:        make([]string, 0, len(shared)),
+		MaxBody:       maxbody,
+		ReadTimeout:   rtimeout,
+		WriteTimeout:  wtimeout,
+		BodyTimeout:   btimeout,
+		LogFormat:     logfmt,
+		RejectPastEnd: pastend,
+		CaseFold:      casefold,
+		MaxDuration:   maxdur,
+		Rate:          rate,
+		TrustProxy:    trusted,
+		StrictLog:     strict,
+		MinFlexible:   minflex,
+		SlowStore:     slowstr,
+		Undo:          undostr,
+		CORS:          make([]string, 0, len(corsOrigins)),
+	}
+
+	for name := range admins {
+		config.Admins = append(config.Admins, name)
+	}
+	sort.Strings(config.Admins)
+
+	for origin := range corsOrigins {
+		config.CORS = append(config.CORS, origin)
+	}
+	sort.Strings(config.CORS)
+
+	for resource := range shared {
+		config.Shared = append(config.Shared, resource)
+	}
+	sort.Strings(config.Shared)
+
+	v3 := &v3handler{
+		storage: storage,
+		maxRead: int64(maxbody),
+		config:  config,
+		keys:    newKeyCache(idempotencyTTL),
+	}
 
 	srv := &http.Server{
 		Addr:           net.JoinHostPort(addr, port),
-		Handler:        mux,
-		ReadTimeout:    60 * time.Second,
-		WriteTimeout:   60 * time.Second,
+		Handler:        drain(&jobs, routes(v3, mail, blackouts)),
+		ReadTimeout:    time.Duration(rtimeout) * time.Second,
+		WriteTimeout:   time.Duration(wtimeout) * time.Second,
 		MaxHeaderBytes: 1 << 20,
 		TLSNextProto:   nil,
+		ConnContext:    saveConn,
+	}
+
+	if bodyTimeout > 0 {
+		srv.ReadHeaderTimeout = bodyTimeout
 	}
 
 	// signal handling
@@ -123,12 +449,17 @@ func run(args []string, stdout, stderr io.Writer) error {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
+	// closed once the web server has finished the requests in flight
+	stopped := make(chan struct{})
+
 	go func() {
 		<-c
 
 		log.Println("signal received")
 		log.Println("stopping web server")
 
+		setReady(false)
+
 		err := srv.Shutdown(ctxt)
 		if err != nil {
 			log.Fatal(err)
@@ -137,8 +468,131 @@ func run(args []string, stdout, stderr io.Writer) error {
 		log.Println("stopping background tasks")
 
 		cancel()
+		close(stopped)
+	}()
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+
+		for {
+			select {
+			case <-usr1:
+				for _, file := range files {
+					err := file.Compact()
+					if err != nil {
+						log.Printf("compact: %v", err)
+						continue
+					}
+
+					log.Printf("compacted %s", file.filename)
+				}
+
+			case <-ctxt.Done():
+				return
+			}
+		}
 	}()
 
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+
+	go func() {
+		for {
+			select {
+			case <-usr2:
+				setReadOnly(!isReadOnly())
+
+				log.Printf("read-only %t", isReadOnly())
+
+			case <-ctxt.Done():
+				return
+			}
+		}
+	}()
+
+	// mail registration cleanup
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+
+		tick := time.NewTicker(time.Hour)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+				_, err := mail.reap()
+				if err != nil {
+					log.Printf("mail reap: %v", err)
+				}
+
+			case <-ctxt.Done():
+				return
+			}
+		}
+	}()
+
+	// drop unconfirmed holds
+
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+
+		tick := time.NewTicker(holdTTL / 4)
+		defer tick.Stop()
+
+		for {
+			select {
+			case now := <-tick.C:
+				storage.ExpireHolds(now)
+
+			case <-ctxt.Done():
+				return
+			}
+		}
+	}()
+
+	// no-show sweep
+
+	if grace > 0 {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+
+			tick := time.NewTicker(time.Minute)
+			defer tick.Stop()
+
+			for {
+				select {
+				case now := <-tick.C:
+					noshows, err := storage.NoShows(grace, now)
+					if err != nil {
+						log.Printf("noshow: %v", err)
+					}
+
+					for _, res := range noshows {
+						text := fmt.Sprintf("Reservation %s was not checked in within %s of its start and has been ended.", res, grace)
+
+						err := mail.Notify(res.Name, "Reservation ended as a no-show", text)
+						if err != nil {
+							log.Printf("notify %s: %v", res.Name, err)
+						}
+					}
+
+				case <-ctxt.Done():
+					return
+				}
+			}
+		}()
+
+		log.Printf("ending reservations not checked in within %s", grace)
+	}
+
 	// start web listener
 
 	// the service is convenient for development but will not
@@ -151,8 +605,12 @@ func run(args []string, stdout, stderr io.Writer) error {
 	log.Printf("serving http at %s", net.JoinHostPort(addr, port))
 
 	err = srv.ListenAndServe()
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Println(err)
+		cancel()
+	} else {
+		// ListenAndServe returns as Shutdown starts, not as it ends
+		<-stopped
 	}
 
 	// graceful exit
@@ -167,6 +625,30 @@ func run(args []string, stdout, stderr io.Writer) error {
 	return nil
 }
 
+// probes and scrapes are served ahead of the request throttle, so a
+// busy server isn't taken for a dead one
+func routes(v3 http.Handler, mail *mail, blackouts *blackouts) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", logger(http.FileServer(http.FS(assets))))
+	mux.Handle("/help", logger(http.HandlerFunc(usage)))
+	mux.Handle("/version", logger(http.HandlerFunc(version)))
+	mux.Handle("/v3/", logger(http.HandlerFunc(notFound)))
+	mux.Handle("/v3/openapi.json", logger(http.HandlerFunc(openapi)))
+	mux.Handle(V3version, logger(http.HandlerFunc(version)))
+	mux.Handle(V3api, logger(http.StripPrefix(V3api, Gzip.Gzip(v3))))
+	mux.Handle(V3mail, logger(mail.rest()))
+	mux.Handle(V3mail+"/", logger(mail.rest()))
+	mux.Handle(V3blackout, logger(http.StripPrefix(V3blackout, blackouts.rest())))
+
+	probes := http.NewServeMux()
+	probes.Handle("/", errorBodies(throttle(bodyDeadline(mux))))
+	probes.Handle("/healthz", http.HandlerFunc(healthz)) // probes aren't logged
+	probes.Handle("/readyz", http.HandlerFunc(readyz))
+	probes.Handle("/metrics", requestMetrics)
+
+	return probes
+}
+
 func main() {
 	err := run(os.Args, os.Stdout, os.Stderr)
 	if err != nil {
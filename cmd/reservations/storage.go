@@ -3,6 +3,7 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
 	. "github.com/dbulkow/reservations/api"
@@ -10,8 +11,75 @@ import (
 
 type Storage interface {
 	GetById(resid int) (*Reservation, error)
-	List(resource, show string, start, length int) ([]*Reservation, error)
+	List(resource, show, initials, tag string, start, length int) ([]*Reservation, error)
+	ListRange(resource, show, initials, tag string, from, to time.Time, start, length, snapshot int) ([]*Reservation, error)
 	Add(res *Reservation) error
+	CheckAdd(res *Reservation) error
+	Waitlist(res *Reservation) error
+	Hold(res *Reservation, ttl time.Duration) error
+	ConfirmHold(ref int) (*Reservation, error)
+	GetHold(ref int) (*Reservation, error)
 	Update(ref int, res *Reservation) (*Reservation, error)
+	BulkPatch(resource, name, owner, show string, patch []byte) ([]*PatchResult, error)
 	Delete(ref int, lastmod time.Time) error
+	BulkDelete(prefix string) ([]int, []int, error)
+	Undelete(ref int) (*Reservation, error)
+	GetDeleted(ref int) (*Reservation, error)
+	CheckIn(ref int) (*Reservation, error)
+	History(ref int) ([]*HistoryEntry, error)
+	Rename(from, to string) ([]*Reservation, error)
+	Reconcile(reload bool) ([]*Discrepancy, error)
+	Generation() (uint64, time.Time)
+	Snapshot() int
+}
+
+// a request refused for an existing reservation in its way, the
+// blocker is reported to the client
+type ConflictError struct {
+	Reason string
+	With   *Reservation
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s, blocked by reservation %d (%s, %s)", e.Reason, e.With.ID, e.With.Name, window(e.With))
+}
+
+// the time a reservation occupies, the end day left out when the same
+func window(r *Reservation) string {
+	const day = "Jan 2 15:04"
+
+	if r.Loan {
+		return "on loan from " + r.Start.Format(day)
+	}
+
+	if r.OpenEnded {
+		return "open-ended from " + r.Start.Format(day)
+	}
+
+	if r.Start.YearDay() == r.End.YearDay() && r.Start.Year() == r.End.Year() {
+		return r.Start.Format(day) + "-" + r.End.Format("15:04")
+	}
+
+	return r.Start.Format(day) + "-" + r.End.Format(day)
+}
+
+type PatchResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// an operation on a reservation as recorded by the backing store
+type HistoryEntry struct {
+	Operation   string       `json:"op"`
+	Time        time.Time    `json:"time"`
+	Reservation *Reservation `json:"reservation,omitempty"`
+}
+
+// a reservation that differs between memory and the backing store
+type Discrepancy struct {
+	ID     int          `json:"id"`
+	Issue  string       `json:"issue"`
+	Memory *Reservation `json:"memory,omitempty"`
+	Store  *Reservation `json:"store,omitempty"`
 }
@@ -0,0 +1,76 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// request bodies of changes must arrive within bodyTimeout of the
+// request, so a slow sender can't hold a handler for the whole server
+// read timeout - zero leaves bodies to the server timeouts
+var bodyTimeout time.Duration
+
+var errBodyTimeout = errors.New("request body read timed out")
+
+type connKey struct{}
+
+// keep the connection in the context of its requests, for bodyDeadline
+// to set a read deadline on - set as the server ConnContext
+func saveConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// the deadline is set on the connection itself, so a stalled read fails
+// in place - HTTP/2 streams share a connection and are left to the
+// server timeouts
+func bodyDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			conn, ok := r.Context().Value(connKey{}).(net.Conn)
+
+			if ok && bodyTimeout > 0 && r.ProtoMajor == 1 && r.Body != nil && r.Body != http.NoBody {
+				conn.SetReadDeadline(time.Now().Add(bodyTimeout))
+				r.Body = &deadlineBody{body: r.Body, conn: conn}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reads past the deadline fail with errBodyTimeout - the deadline is
+// lifted once the body is read, as the server goes on reading the
+// connection to notice the client leaving
+type deadlineBody struct {
+	body io.ReadCloser
+	conn net.Conn
+	done bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.conn.SetReadDeadline(time.Time{})
+	}
+
+	var neterr net.Error
+	if errors.As(err, &neterr) && neterr.Timeout() {
+		return n, errBodyTimeout
+	}
+
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	return b.body.Close()
+}
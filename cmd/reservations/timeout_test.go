@@ -0,0 +1,88 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyDeadline(t *testing.T) {
+	saved := bodyTimeout
+	defer func() { bodyTimeout = saved }()
+
+	bodyTimeout = 200 * time.Millisecond
+
+	type result struct {
+		body    string
+		err     error
+		elapsed time.Duration
+	}
+
+	results := make(chan result, 1)
+
+	srv := httptest.NewUnstartedServer(bodyDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body, err := ioutil.ReadAll(r.Body)
+		results <- result{body: string(body), err: err, elapsed: time.Since(start)}
+	})))
+	srv.Config.ReadTimeout = 3 * time.Second
+	srv.Config.ConnContext = saveConn
+	srv.Start()
+	defer srv.Close()
+
+	// a sender that stalls after the first bytes
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "POST /v3/reservations/ HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"resource\":")
+
+	var res result
+
+	select {
+	case res = <-results:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stalled body held the handler past the body timeout")
+	}
+
+	if res.err != errBodyTimeout {
+		t.Fatalf("expected \"%v\" got %v", errBodyTimeout, res.err)
+	}
+
+	if res.elapsed < bodyTimeout/2 || res.elapsed > time.Second {
+		t.Fatalf("expected read aborted at %s took %s", bodyTimeout, res.elapsed)
+	}
+
+	if res.body != `{"resource":` {
+		t.Fatalf("expected partial body got \"%s\"", res.body)
+	}
+
+	// prompt bodies are read in full, the deadline lifted for the next
+	// request on the connection
+	client := srv.Client()
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(srv.URL+"/v3/reservations/", "application/json", strings.NewReader(`{"resource":"thing"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		res = <-results
+
+		if res.err != nil || res.body != `{"resource":"thing"}` {
+			t.Fatalf("expected full body got \"%s\" %v", res.body, res.err)
+		}
+
+		time.Sleep(bodyTimeout)
+	}
+}
@@ -3,19 +3,83 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
+
+	"github.com/dbulkow/reservations/internal/buildinfo"
 )
 
 const usetext = `Reservations Server
 
 GET    /v3/reservations/         - get all reservations
+GET    /v3/reservations/?from=<RFC3339>&to=<RFC3339>
+                                 - get reservations within a window
+GET    /v3/reservations/?sort=<id|resource|date|name>
+                                 - get reservations in order, default id
+GET    /v3/reservations/?show=upcoming&within=<duration>
+                                 - get reservations yet to start, starting
+                                   within the duration when given
+GET    /v3/reservations/?initials=<initials>
+                                 - get reservations carrying the initials
+GET    /v3/reservations/?tag=<tag>
+                                 - get reservations carrying the tag
+GET    /v3/reservations/?start=<index>&limit=<count>&snapshot=<index>
+                                 - get a page of reservations, later pages
+                                   follow the snapshot of the first
+GET    /v3/reservations/?last=<id>&limit=<count>
+                                 - get a page of reservations with IDs
+                                   greater than last, in ID order
 GET    /v3/reservations/<index>  - get one reservation
+GET    /v3/reservations/<index>/history
+                                 - get the logged changes to a reservation
 POST   /v3/reservations/         - create reservation
+                                   an Idempotency-Key header makes a
+                                   retry return the first response
+POST   /v3/reservations/?waitlist=true
+                                 - create reservation, on conflict at the
+                                   first free slot after the blockers
+POST   /v3/reservations/?validate=true
+                                 - check a reservation would be created,
+                                   nothing is stored
+POST   /v3/reservations/restofday
+                                 - create reservation from now to end of day
+POST   /v3/reservations/hold     - hold a slot for two minutes
+POST   /v3/reservations/<index>/confirm
+                                 - turn a hold into a reservation
 PUT    /v3/reservations/<index>  - update reservation
 PATCH  /v3/reservations/<index>  - update reservation
+PATCH  /v3/reservations/?resource=<name>&name=<owner>
+                                 - update all matching reservations
 DELETE /v3/reservations/<index>  - delete reservation
+DELETE /v3/reservations/?resource=<prefix>
+                                 - delete future reservations on resources
+                                   starting with prefix, admins only
+POST   /v3/reservations/<index>/restore
+                                 - undo the recent delete of a reservation
+POST   /v3/reservations/<index>/checkin
+                                 - report reservation in use
+POST   /v3/reservations/command  - run a command, e.g.
+                                   {"command":"rename","from":"<resource>","to":"<resource>"}
+                                   {"command":"reconcile","reload":true}
+                                   {"command":"config"}
+                                   admins only
+
+GET    /v3/blackouts/            - get resource blackout windows
+POST   /v3/blackouts/            - create blackout window, admins only
+DELETE /v3/blackouts/<index>     - delete blackout window, admins only
+
+GET    /v3/mailverify?valid=<true|false>&start=<index>&limit=<count>
+                                 - list email registrations, addresses
+                                   shown to admins only
+
+GET    /v3/openapi.json          - OpenAPI description of the API
+GET    /v3/version               - git hash and build time of the server
+GET    /metrics                  - request counts and latency (Prometheus)
+GET    /version                  - git hash and build time of the server
+GET    /healthz                  - liveness probe
+GET    /readyz                   - readiness probe, 503 until data is loaded
 `
 
 var browserAgents = regexp.MustCompile("Mozilla|AppleWebKit|WebKit|Chrome|Safari")
@@ -23,9 +87,24 @@ var browserAgents = regexp.MustCompile("Mozilla|AppleWebKit|WebKit|Chrome|Safari
 func usage(w http.ResponseWriter, r *http.Request) {
 	if !browserAgents.MatchString(r.UserAgent()) {
 		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprintln(w, usetext)
+		fmt.Fprint(w, usetext)
 		return
 	}
 
 	// respond with fancy version
 }
+
+func version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash, built := buildinfo.Version(GitHash, BuildTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"gitHash":   hash,
+		"buildTime": built,
+	})
+}
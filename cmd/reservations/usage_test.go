@@ -0,0 +1,51 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestVersion(t *testing.T) {
+	for _, path := range []string{"/version", V3version} {
+		t.Run(path, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			routes(nil, nil, nil).ServeHTTP(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+			}
+
+			if resp.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("expected content type \"application/json\" got \"%s\"", resp.Header.Get("Content-Type"))
+			}
+
+			var rpy map[string]string
+
+			err := json.NewDecoder(resp.Body).Decode(&rpy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rpy) != 2 || rpy["gitHash"] == "" || rpy["buildTime"] == "" {
+				t.Fatalf("expected gitHash and buildTime got %v", rpy)
+			}
+		})
+	}
+
+	r, _ := http.NewRequest(http.MethodPost, V3version, nil)
+	w := httptest.NewRecorder()
+	routes(nil, nil, nil).ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status code 405 got %d", w.Result().StatusCode)
+	}
+}
@@ -3,7 +3,10 @@
 package main
 
 import (
+	"compress/gzip"
+	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,107 +14,280 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	. "github.com/dbulkow/reservations/api"
+	Gzip "github.com/dbulkow/reservations/internal/gzip"
 )
 
 var isNumeric = regexp.MustCompile("[0-9]+")
 
 const v3MaxRead = 128 * 1024
 
+// ownership checks, when enabled only the named owner of a reservation
+// or an admin can modify it
+var (
+	checkOwner bool
+	admins     = map[string]bool{}
+)
+
+// refuse a PUT ending in the past for a reservation yet to start,
+// likely a client bug
+var rejectPastEnd = true
+
+// read-only mode serves reads and refuses changes, for maintenance - set
+// from the environment and toggled at runtime by SIGUSR2
+var readOnly int32
+
+func setReadOnly(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&readOnly, v)
+}
+
+func isReadOnly() bool {
+	return atomic.LoadInt32(&readOnly) == 1
+}
+
+// refuse a change while read-only, reporting whether it may go ahead
+func v3writable(w http.ResponseWriter) bool {
+	if isReadOnly() {
+		v3error(w, "server is read-only for maintenance", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// refuse an admin-only request from anyone not listed with -admins,
+// whether or not ownership is checked, reporting whether it may go ahead
+func v3admin(w http.ResponseWriter, r *http.Request, what string) bool {
+	if !admins[r.Header.Get(UserHeader)] {
+		v3error(w, what+" restricted to admins", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// v3 reservations API, request bodies are read up to maxRead bytes
+type v3handler struct {
+	storage Storage
+	maxRead int64
+	config  *serverConfig
+	keys    *keyCache
+}
+
+// responses are compressed for clients accepting gzip
 func v3res(storage Storage) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "command" {
-			v3cmd(storage, w, r)
+	h := &v3handler{
+		storage: storage,
+		maxRead: v3MaxRead,
+		keys:    newKeyCache(idempotencyTTL),
+	}
+
+	return Gzip.Gzip(h)
+}
+
+func (h *v3handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	corsHeaders(w, r)
+
+	if corsPreflight(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(io.LimitReader(r.Body, h.maxRead))
+		if err != nil {
+			v3error(w, "malformed gzip request", http.StatusBadRequest)
 			return
 		}
+		defer gz.Close()
 
-		if false {
-			in, err := httputil.DumpRequest(r, false)
-			if err != nil {
-				log.Println(err)
-			}
+		r.Body = gz
+	}
 
-			fmt.Println(string(in))
+	// commands, and posts only validated, are checked as they run
+	switch {
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+	case r.URL.Path == "command":
+	case r.URL.Path == "" && r.Method == http.MethodPost && r.URL.Query().Get("validate") == "true":
+	default:
+		if !v3writable(w) {
+			return
 		}
+	}
 
-		var ref int
-		var refset bool
-		var err error
+	if r.URL.Path == "command" {
+		h.cmd(w, r)
+		return
+	}
 
-		if r.URL.Path != "" && r.URL.Path != "*" { // the latter is for OPTIONS
-			if !isNumeric.MatchString(r.URL.Path) {
-				v3error(w, fmt.Sprintf("ref \"%s\" is not a number", r.URL.Path), http.StatusNotFound)
-				return
-			}
+	if r.URL.Path == "restofday" {
+		h.restofday(w, r)
+		return
+	}
 
-			ref, err = strconv.Atoi(r.URL.Path)
-			if err != nil {
-				v3error(w, fmt.Sprintf("ref \"%s\" not a valid number: %v", r.URL.Path, err), http.StatusNotFound)
-				return
-			}
+	if r.URL.Path == "hold" {
+		h.hold(w, r)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/confirm") {
+		h.confirm(w, r, strings.TrimSuffix(r.URL.Path, "/confirm"))
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/checkin") {
+		h.checkin(w, r, strings.TrimSuffix(r.URL.Path, "/checkin"))
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/restore") {
+		h.restore(w, r, strings.TrimSuffix(r.URL.Path, "/restore"))
+		return
+	}
 
-			refset = true
+	if strings.HasSuffix(r.URL.Path, "/history") {
+		h.history(w, r, strings.TrimSuffix(r.URL.Path, "/history"))
+		return
+	}
+
+	if false {
+		in, err := httputil.DumpRequest(r, false)
+		if err != nil {
+			log.Println(err)
 		}
 
-		switch r.Method {
-		case http.MethodOptions:
-			if refset {
-				w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, PUT, PATCH, DELETE")
-				w.Header().Set("Accept-Patch", "application/json-patch+json, application/merge-patch+json")
-			} else {
-				w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST")
-			}
-			w.Header().Set("Content-Length", "0")
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Println(string(in))
+	}
+
+	var ref int
+	var refset bool
+	var err error
+
+	if r.URL.Path != "" && r.URL.Path != "*" { // the latter is for OPTIONS
+		if !isNumeric.MatchString(r.URL.Path) {
+			v3error(w, fmt.Sprintf("ref \"%s\" is not a number", r.URL.Path), http.StatusNotFound)
 			return
+		}
 
-		case http.MethodHead:
-			fallthrough
-		case http.MethodGet:
-			if refset {
-				v3getref(storage, w, r, ref)
-			} else {
-				v3get(storage, w, r)
-			}
+		ref, err = strconv.Atoi(r.URL.Path)
+		if err != nil {
+			v3error(w, fmt.Sprintf("ref \"%s\" not a valid number: %v", r.URL.Path, err), http.StatusNotFound)
+			return
+		}
 
-		case http.MethodPost:
-			if refset {
-				v3error(w, "post not allowed on reservation", http.StatusMethodNotAllowed)
-			} else {
-				v3post(storage, w, r)
-			}
+		refset = true
+	}
 
-		// the following all require a reference :ref
+	switch r.Method {
+	case http.MethodOptions:
+		if refset {
+			w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Accept-Patch", "application/json-patch+json, application/merge-patch+json")
+		} else {
+			w.Header().Set("Allow", "OPTIONS, HEAD, GET, POST, PATCH, DELETE")
+			w.Header().Set("Accept-Patch", "application/merge-patch+json")
+		}
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return
 
-		case http.MethodPut:
-			if refset == false {
-				v3error(w, "ref not specified", http.StatusNotFound)
-			} else {
-				v3put(storage, w, r, ref)
-			}
+	case http.MethodHead:
+		fallthrough
+	case http.MethodGet:
+		if refset {
+			h.getref(w, r, ref)
+		} else {
+			h.get(w, r)
+		}
 
-		case http.MethodPatch:
-			if refset == false {
-				v3error(w, "ref not specified", http.StatusNotFound)
-			} else {
-				v3patch(storage, w, r, ref)
-			}
+	case http.MethodPost:
+		if refset {
+			v3error(w, "post not allowed on reservation", http.StatusMethodNotAllowed)
+		} else {
+			h.post(w, r)
+		}
 
-		case http.MethodDelete:
-			if refset == false {
-				v3error(w, "ref not specified", http.StatusNotFound)
-			} else {
-				v3delete(storage, w, r, ref)
-			}
+	// the following all require a reference :ref
+
+	case http.MethodPut:
+		if refset == false {
+			v3error(w, "ref not specified", http.StatusNotFound)
+		} else {
+			h.put(w, r, ref)
+		}
+
+	case http.MethodPatch:
+		q := r.URL.Query()
+		if refset == false && (q.Get("resource") != "" || q.Get("name") != "") {
+			h.bulkpatch(w, r)
+		} else if refset == false {
+			v3error(w, "ref not specified", http.StatusNotFound)
+		} else {
+			h.patch(w, r, ref)
+		}
 
-		default:
-			http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+	case http.MethodDelete:
+		if refset == false && r.URL.Query().Get("resource") != "" {
+			h.bulkdelete(w, r)
+		} else if refset == false {
+			v3error(w, "ref not specified", http.StatusNotFound)
+		} else {
+			h.delete(w, r, ref)
 		}
+
+	default:
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// as v3error, a refusal for a reservation in the way names it in a
+// conflict field
+func v3refused(w http.ResponseWriter, err error, code int) {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		v3error(w, err.Error(), code)
+		return
+	}
+
+	// the blocker's owner address and notes are kept back
+	with := conflict.With
+	blocker := &Reservation{
+		ID:       with.ID,
+		Resource: with.Resource,
+		Start:    with.Start,
+		End:      with.End,
+		Loan:     with.Loan,
+		Share:    with.Share,
+		Name:     with.Name,
+		Initials: with.Initials,
+	}
+
+	reply := struct {
+		Status   string       `json:"status"`
+		Error    string       `json:"error"`
+		Conflict *Reservation `json:"conflict"`
+	}{
+		Status:   "Error",
+		Error:    err.Error(),
+		Conflict: blocker,
 	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		b = []byte{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(code)
+	w.Write(b)
 }
 
 func v3error(w http.ResponseWriter, errstr string, code int) {
@@ -134,8 +310,83 @@ func v3error(w http.ResponseWriter, errstr string, code int) {
 	w.Write(b)
 }
 
-func v3getref(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
-	res, err := storage.GetById(ref)
+// verify the requesting user owns the reservation or is an admin -
+// reservations that can't be found are left to the caller to report
+func (h *v3handler) owner(w http.ResponseWriter, r *http.Request, ref int) bool {
+	return h.ownerOf(w, r, ref, h.storage.GetById)
+}
+
+// ownership check against a reservation found by lookup, for those
+// held or deleted that GetById doesn't return
+func (h *v3handler) ownerOf(w http.ResponseWriter, r *http.Request, ref int, lookup func(int) (*Reservation, error)) bool {
+	if checkOwner == false {
+		return true
+	}
+
+	user := r.Header.Get(UserHeader)
+	owner := r.Header.Get(OwnerHeader)
+
+	if admins[user] {
+		return true
+	}
+
+	res, err := lookup(ref)
+	if err != nil {
+		return true
+	}
+
+	if user == "" || !owns(res, user, owner) {
+		v3error(w, fmt.Sprintf("reservation %d not owned by \"%s\"", ref, user), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// strong entity tag over the serialized reservations, LastModified is
+// stored to the second so the remaining fields tell apart edits landing
+// within the same second
+func v3etag(res ...*Reservation) string {
+	h := sha1.New()
+	enc := json.NewEncoder(h)
+	for _, r := range res {
+		enc.Encode(r)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil))
+}
+
+// match an If-Match or If-None-Match header against an entity tag
+func v3etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// check If-Match and If-None-Match against the current reservation
+func v3precondition(w http.ResponseWriter, r *http.Request, res *Reservation) bool {
+	etag := v3etag(res)
+
+	match := r.Header.Get("If-Match")
+	if match != "" && !v3etagMatch(match, etag) {
+		v3error(w, "reservation modified", http.StatusPreconditionFailed)
+		return false
+	}
+
+	match = r.Header.Get("If-None-Match")
+	if match != "" && v3etagMatch(match, etag) {
+		v3error(w, "reservation matches", http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+func (h *v3handler) getref(w http.ResponseWriter, r *http.Request, ref int) {
+	res, err := h.storage.GetById(ref)
 	if err != nil {
 		v3error(w, fmt.Sprintf("get %d: %v", ref, err), http.StatusNotFound)
 		return
@@ -158,12 +409,19 @@ func v3getref(storage Storage, w http.ResponseWriter, r *http.Request, ref int)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
 	w.Header().Set("Last-Modified", res.LastModified.Format(time.RFC1123))
+	w.Header().Set("ETag", v3etag(res))
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if v3etagMatch(match, v3etag(res)) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
 	since := r.Header.Get("If-Modified-Since")
 	t, err := time.Parse(time.RFC1123, since)
 	if err == nil {
-		fmt.Println(res.LastModified, t)
-		if res.LastModified.After(t) {
+		if !res.LastModified.Truncate(time.Second).After(t) {
 			w.WriteHeader(http.StatusNotModified)
 			return
 		}
@@ -176,11 +434,13 @@ func v3getref(storage Storage, w http.ResponseWriter, r *http.Request, ref int)
 	w.Write(b)
 }
 
-func v3get(storage Storage, w http.ResponseWriter, r *http.Request) {
+func (h *v3handler) get(w http.ResponseWriter, r *http.Request) {
 	var (
 		q        = r.URL.Query()
 		show     = q.Get("show")
 		resource = q.Get("resource")
+		initials = q.Get("initials")
+		tag      = q.Get("tag")
 	)
 
 	start, err := strconv.Atoi(q.Get("start"))
@@ -188,18 +448,108 @@ func v3get(storage Storage, w http.ResponseWriter, r *http.Request) {
 		start = 0
 	}
 
+	// last is a cursor, the page holds only IDs beyond it
+	if q.Get("last") != "" {
+		last, err := strconv.Atoi(q.Get("last"))
+		if err != nil || last < 0 {
+			v3error(w, "last malformed", http.StatusBadRequest)
+			return
+		}
+		if last+1 > start {
+			start = last + 1
+		}
+	}
+
 	limit, err := strconv.Atoi(q.Get("limit"))
 	if err != nil {
 		limit = 0
 	}
 
-	res, err := storage.List(resource, show, start, limit)
+	// the first page of a scroll fixes the reservations later pages see,
+	// so adds while scrolling don't shift rows between pages
+	snapshot, err := strconv.Atoi(q.Get("snapshot"))
+	if err != nil || snapshot < 0 {
+		snapshot = noSnapshot
+	}
+
+	if snapshot == noSnapshot && (start > 0 || limit > 0) {
+		snapshot = h.storage.Snapshot()
+	}
+
+	var from, to time.Time
+
+	if q.Get("from") != "" {
+		from, err = time.Parse(time.RFC3339, q.Get("from"))
+		if err != nil {
+			v3error(w, "from time malformed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if q.Get("to") != "" {
+		to, err = time.Parse(time.RFC3339, q.Get("to"))
+		if err != nil {
+			v3error(w, "to time malformed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// upcoming reservations start within the next while, the window
+	// closing then unless to closes it sooner
+	if q.Get("within") != "" {
+		if show != "upcoming" {
+			v3error(w, "within requires show=upcoming", http.StatusBadRequest)
+			return
+		}
+
+		within, err := time.ParseDuration(q.Get("within"))
+		if err != nil || within <= 0 {
+			v3error(w, "within malformed", http.StatusBadRequest)
+			return
+		}
+
+		end := time.Now().Add(within)
+		if to.IsZero() || end.Before(to) {
+			to = end
+		}
+	}
+
+	var order func([]*Reservation) sort.Interface
+
+	switch q.Get("sort") {
+	case "", "id":
+	case "resource":
+		order = func(r []*Reservation) sort.Interface { return ByResource(r) }
+	case "date":
+		order = func(r []*Reservation) sort.Interface { return ByDate(r) }
+	case "name":
+		order = func(r []*Reservation) sort.Interface { return ByName(r) }
+	default:
+		v3error(w, fmt.Sprintf("unknown sort \"%s\"", q.Get("sort")), http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.storage.ListRange(resource, show, initials, tag, from, to, start, limit, snapshot)
 	if err != nil {
 		v3error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var modified time.Time
+	// pages are cut in ID order, so the next page follows the highest ID
+	// whatever order this one is returned in
+	sort.Sort(ByID(res))
+
+	lastid := 0
+	if len(res) > 0 {
+		lastid = res[len(res)-1].ID
+	}
+
+	if order != nil {
+		sort.Stable(order(res))
+	}
+
+	// the store changes on deletes too, which leave no row behind
+	_, modified := h.storage.Generation()
 	for _, r := range res {
 		if r.LastModified.After(modified) {
 			modified = r.LastModified
@@ -219,12 +569,14 @@ func v3get(storage Storage, w http.ResponseWriter, r *http.Request) {
 			if r.Method == http.MethodHead {
 				q.Set("start", "0")
 			} else {
-				nextid := res[len(res)-1].ID + 1
-				q.Set("start", strconv.Itoa(nextid))
+				q.Set("start", strconv.Itoa(lastid+1))
 			}
 			if limit > 0 {
 				q.Set("limit", strconv.Itoa(limit))
 			}
+			if snapshot != noSnapshot {
+				q.Set("snapshot", strconv.Itoa(snapshot))
+			}
 			u.RawQuery = q.Encode()
 		}
 
@@ -258,11 +610,24 @@ func v3get(storage Storage, w http.ResponseWriter, r *http.Request) {
 	if next != "" {
 		w.Header().Set("X-Next-Reservation", next)
 	}
+	if snapshot != noSnapshot {
+		w.Header().Set("X-Reservation-Snapshot", strconv.Itoa(snapshot))
+	}
+
+	etag := v3etag(res...)
+	w.Header().Set("ETag", etag)
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if v3etagMatch(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
 	since := r.Header.Get("If-Modified-Since")
 	t, err := time.Parse(time.RFC1123, since)
 	if err == nil {
-		if modified.After(t) {
+		if !modified.Truncate(time.Second).After(t) {
 			w.WriteHeader(http.StatusNotModified)
 			return
 		}
@@ -275,104 +640,159 @@ func v3get(storage Storage, w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
-func v3readlen(r *http.Request) int64 {
+// absolute URL of path as the client addressed the server - a proxy
+// passes the original scheme and host in X-Forwarded-Proto and
+// X-Forwarded-Host, the first of each list being the client's, and
+// without any host the path is returned as is
+func v3location(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	if host == "" {
+		return path
+	}
+
+	u := &url.URL{Scheme: scheme, Host: host, Path: path}
+
+	return u.String()
+}
+
+// Content-Length of a gzip request is the compressed size, the
+// decompressed body is bounded by max alone
+func v3readlen(r *http.Request, max int64) int64 {
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		return max
+	}
+
 	clen := r.Header.Get("Content-Length")
 	if clen == "" {
-		return v3MaxRead
+		return max
 	}
 
 	len, err := strconv.Atoi(clen)
 	if err != nil {
-		return v3MaxRead
+		return max
 	}
 
-	if len > v3MaxRead {
-		return v3MaxRead
+	if int64(len) > max {
+		return max
 	}
 
 	return int64(len)
 }
 
-func v3post(storage Storage, w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Content-Type") != "application/json" {
-		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
-		return
+// decode a reservation from a request body, refusing fields it doesn't
+// have and values of the wrong type so typos like "shair" are reported
+// rather than dropped
+func v3decode(body io.Reader, req *Reservation) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(req)
+	if err == nil {
+		return nil
 	}
 
-	reply := struct {
-		Status   string `json:"status"`
-		Location string `json:"location,omitempty"`
-		ID       *int   `json:"id,omitempty"`
-	}{}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("field \"%s\" should be %s not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
 
-	var req = &Reservation{}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
 
-	err := json.NewDecoder(io.LimitReader(r.Body, v3readlen(r))).Decode(req)
-	if err != nil {
-		v3error(w, "malformed request", http.StatusBadRequest)
+	var timeErr *time.ParseError
+	if errors.As(err, &timeErr) {
+		return fmt.Errorf("time \"%s\" malformed", timeErr.Value)
+	}
+
+	return errors.New("malformed request")
+}
+
+// with waitlist=true a conflicting reservation is moved to the earliest
+// free slot after its blockers, the move reported in X-Reservation-Shift
+func (h *v3handler) post(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("validate") == "true" {
+		h.validate(w, r)
 		return
 	}
 
-	err = storage.Add(req)
-	if err != nil {
-		if strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "conflict") {
-			v3error(w, err.Error(), http.StatusConflict)
-		} else {
-			v3error(w, err.Error(), http.StatusBadRequest)
-		}
+	if r.URL.Query().Get("waitlist") != "true" {
+		h.create(w, r, nil, h.storage.Add)
 		return
 	}
 
-	location := fmt.Sprintf("%s%d", V3api, req.ID)
+	h.create(w, r, nil, func(req *Reservation) error {
+		start := req.Start
 
-	reply.Status = "Success"
-	reply.Location = location
-	reply.ID = &req.ID
+		err := h.storage.Waitlist(req)
+		if err != nil {
+			return err
+		}
 
-	b, err := json.Marshal(reply)
-	if err != nil {
-		v3error(w, fmt.Sprintf("post: %v", err), http.StatusInternalServerError)
+		if !req.Start.Equal(start) {
+			w.Header().Set("X-Reservation-Shift", req.Start.Sub(start).String())
+			w.Header().Set("X-Reservation-Start", req.Start.Format(time.RFC3339))
+			w.Header().Set("X-Reservation-End", req.End.Format(time.RFC3339))
+		}
+
+		return nil
+	})
+}
+
+// how long a hold blocks its slot before it must be confirmed
+var holdTTL = 2 * time.Minute
+
+// place a short hold on the slot in the request, confirmed with a POST
+// to <id>/confirm before holdTTL passes
+func (h *v3handler) hold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v3error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
-	w.Header().Set("Location", location)
-	w.Header().Set("ID", strconv.Itoa(req.ID))
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
-	w.Header().Set("Last-Modified", req.LastModified.Format(time.RFC1123))
-	w.WriteHeader(http.StatusCreated)
-	w.Write(b)
+	h.create(w, r, nil, func(req *Reservation) error {
+		return h.storage.Hold(req, holdTTL)
+	})
 }
 
-// maybe limit this to future reservations?
-func v3put(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
-	if r.Header.Get("Content-Type") != "application/json" {
-		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+// promote a hold to a reservation
+func (h *v3handler) confirm(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		v3error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req Reservation
-
-	err := json.NewDecoder(io.LimitReader(r.Body, v3readlen(r))).Decode(&req)
+	ref, err := strconv.Atoi(path)
 	if err != nil {
-		v3error(w, "malformed request", http.StatusBadRequest)
+		v3error(w, fmt.Sprintf("ref \"%s\" is not a number", path), http.StatusNotFound)
 		return
 	}
 
-	since := r.Header.Get("If-Unmodified-Since")
-	last, err := time.Parse(time.RFC1123, since)
-	if err == nil {
-		req.LastModified = last
+	if !h.ownerOf(w, r, ref, h.storage.GetHold) {
+		return
 	}
 
-	res, err := storage.Update(ref, &req)
+	res, err := h.storage.ConfirmHold(ref)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			v3error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		if strings.Contains(err.Error(), "range conflict") || strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "modified") {
-			v3error(w, err.Error(), http.StatusConflict)
+		if strings.Contains(err.Error(), "expired") {
+			v3error(w, err.Error(), http.StatusGone)
 			return
 		}
 		v3error(w, err.Error(), http.StatusInternalServerError)
@@ -389,24 +809,303 @@ func v3put(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
 
 	b, err := json.Marshal(reply)
 	if err != nil {
-		v3error(w, fmt.Sprintf("put %d: %v", ref, err), http.StatusInternalServerError)
+		v3error(w, fmt.Sprintf("confirm %d: %v", ref, err), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Location", v3location(r, fmt.Sprintf("%s%d", V3api, res.ID)))
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
-	w.Header().Set("Last-Modified", req.LastModified.Format(time.RFC1123))
+	w.Header().Set("Last-Modified", res.LastModified.Format(time.RFC1123))
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
 
-func v3patch(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
-	if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+// end of day for rest of day reservations, as an offset from midnight
+var endOfDay = 17 * time.Hour
+
+// the range from now until today's end of day
+func restOfDay(now time.Time, eod time.Duration) (time.Time, time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	end := midnight.Add(eod)
+	if !end.After(now) {
+		return now, end, errors.New("end of day has passed")
+	}
+
+	return now, end, nil
+}
+
+// reserve a resource from now until the end of today, the request
+// start, end and loan are ignored
+func (h *v3handler) restofday(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v3error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.create(w, r, func(req *Reservation) error {
+		start, end, err := restOfDay(time.Now(), endOfDay)
+		if err != nil {
+			return err
+		}
+
+		req.Start = start
+		req.End = end
+		req.Loan = false
+		req.OpenEnded = false
+
+		return nil
+	}, h.storage.Add)
+}
+
+// store the reservation in the request with add, adjust may fill in
+// fields first
+func (h *v3handler) create(w http.ResponseWriter, r *http.Request, adjust func(*Reservation) error, add func(*Reservation) error) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// a retried request with the same key gets the first response
+	// rather than a second reservation
+	var (
+		key   = r.Header.Get("Idempotency-Key")
+		claim *keyResult
+	)
+
+	if key != "" && h.keys != nil {
+		key = r.URL.Path + " " + key
+
+		kr, claimed := h.keys.claim(key, time.Now())
+		if !claimed {
+			kr.replay(w)
+			return
+		}
+
+		claim = kr
+		defer func() {
+			if claim != nil {
+				h.keys.release(key, claim)
+			}
+		}()
+	}
+
+	reply := struct {
+		Status   string `json:"status"`
+		Location string `json:"location,omitempty"`
+		ID       *int   `json:"id,omitempty"`
+	}{}
+
+	var req = &Reservation{}
+
+	err := v3decode(io.LimitReader(r.Body, v3readlen(r, h.maxRead)), req)
+	if err != nil {
+		v3error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if adjust != nil {
+		err = adjust(req)
+		if err != nil {
+			v3error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = add(req)
+	if err != nil {
+		v3refused(w, err, addStatus(err))
+		return
+	}
+
+	location := v3location(r, fmt.Sprintf("%s%d", V3api, req.ID))
+
+	reply.Status = "Success"
+	reply.Location = location
+	reply.ID = &req.ID
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("post: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("ID", strconv.Itoa(req.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Last-Modified", req.LastModified.Format(time.RFC1123))
+
+	if claim != nil {
+		h.keys.finish(key, claim, http.StatusCreated, w.Header(), b, time.Now())
+		claim = nil
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// refusals to add a reservation for want of room are conflicts, the
+// rest are bad requests
+func addStatus(err error) int {
+	if strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "conflict") || strings.Contains(err.Error(), "blackout") || strings.Contains(err.Error(), "too many queued") {
+		return http.StatusConflict
+	}
+
+	return http.StatusBadRequest
+}
+
+// report whether the reservation in the request would be added, nothing
+// is stored
+func (h *v3handler) validate(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req = &Reservation{}
+
+	err := v3decode(io.LimitReader(r.Body, v3readlen(r, h.maxRead)), req)
+	if err != nil {
+		v3error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.storage.CheckAdd(req)
+	if err != nil {
+		v3refused(w, err, addStatus(err))
+		return
+	}
+
+	reply := struct {
+		Status string `json:"status"`
+		Result string `json:"result"`
+	}{
+		Status: "Success",
+		Result: "would succeed",
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("validate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// maybe limit this to future reservations?
+func (h *v3handler) put(w http.ResponseWriter, r *http.Request, ref int) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if !h.owner(w, r, ref) {
+		return
+	}
+
+	var req Reservation
+
+	err := v3decode(io.LimitReader(r.Body, v3readlen(r, h.maxRead)), &req)
+	if err != nil {
+		v3error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if now := time.Now(); rejectPastEnd && !req.Loan && !req.OpenEnded && req.End.Before(now) {
+		cur, err := h.storage.GetById(ref)
+		if err == nil && cur.Start.After(now) {
+			v3error(w, "end in the past", http.StatusBadRequest)
+			return
+		}
+	}
+
+	since := r.Header.Get("If-Unmodified-Since")
+	last, err := time.Parse(time.RFC1123, since)
+	if err == nil {
+		req.LastModified = last
+	}
+
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != "" {
+		cur, err := h.storage.GetById(ref)
+		if err != nil {
+			v3error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if !v3precondition(w, r, cur) {
+			return
+		}
+
+		req.LastModified = cur.LastModified
+	}
+
+	res, err := h.storage.Update(ref, &req)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			v3error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if strings.Contains(err.Error(), "modified") {
+			v3error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if strings.Contains(err.Error(), "range conflict") || strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "blackout") {
+			v3refused(w, err, http.StatusConflict)
+			return
+		}
+		if strings.Contains(err.Error(), "maximum duration") || strings.Contains(err.Error(), "link") || strings.Contains(err.Error(), "tag") || strings.Contains(err.Error(), "initials") || strings.Contains(err.Error(), "open-ended") {
+			v3error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v3error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// reply with the reservation as GET has it, so the entity tag
+	// holds for the next request
+	if cur, err := h.storage.GetById(ref); err == nil {
+		res = cur
+	}
+
+	reply := struct {
+		Status      string       `json:"status"`
+		Reservation *Reservation `json:"reservation,omitempty"`
+	}{
+		Status:      "Success",
+		Reservation: res,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("put %d: %v", ref, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Last-Modified", req.LastModified.Format(time.RFC1123))
+	w.Header().Set("ETag", v3etag(res))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (h *v3handler) patch(w http.ResponseWriter, r *http.Request, ref int) {
+	if r.Header.Get("Content-Type") != "application/merge-patch+json" {
 		v3error(w, "unknown content type", http.StatusUnsupportedMediaType)
 		return
 	}
 
-	res, err := storage.GetById(ref)
+	if !h.owner(w, r, ref) {
+		return
+	}
+
+	res, err := h.storage.GetById(ref)
 	if err != nil {
 		v3error(w, err.Error(), http.StatusNotFound)
 		return
@@ -416,37 +1115,59 @@ func v3patch(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
 	last, err := time.Parse(time.RFC1123, since)
 	if err == nil {
 		if res.LastModified.After(last) {
-			v3error(w, "reservation modified", http.StatusConflict)
+			v3error(w, "reservation modified", http.StatusPreconditionFailed)
 			return
 		}
 	}
 
-	b, err := io.ReadAll(io.LimitReader(r.Body, v3readlen(r)))
+	if !v3precondition(w, r, res) {
+		return
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, v3readlen(r, h.maxRead)))
 	if err != nil {
 		v3error(w, "malformed request", http.StatusBadRequest)
 		return
 	}
 
-	status, err := MergePatch(res, b)
+	// patch a copy, the stored reservation is left alone should the
+	// update be refused
+	req := *res
+
+	status, err := MergePatch(&req, b)
 	if err != nil {
 		v3error(w, err.Error(), status)
 		return
 	}
 
-	res, err = storage.Update(res.ID, res)
+	res, err = h.storage.Update(req.ID, &req)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			v3error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		if strings.Contains(err.Error(), "range conflict") || strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "modified") {
-			v3error(w, err.Error(), http.StatusConflict)
+		if strings.Contains(err.Error(), "modified") {
+			v3error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if strings.Contains(err.Error(), "range conflict") || strings.Contains(err.Error(), "on loan") || strings.Contains(err.Error(), "blackout") {
+			v3refused(w, err, http.StatusConflict)
+			return
+		}
+		if strings.Contains(err.Error(), "maximum duration") || strings.Contains(err.Error(), "link") || strings.Contains(err.Error(), "tag") || strings.Contains(err.Error(), "initials") || strings.Contains(err.Error(), "open-ended") {
+			v3error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		v3error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// reply with the reservation as GET has it, so the entity tag
+	// holds for the next request
+	if cur, err := h.storage.GetById(ref); err == nil {
+		res = cur
+	}
+
 	reply := struct {
 		Status      string       `json:"status"`
 		Reservation *Reservation `json:"reservation,omitempty"`
@@ -464,24 +1185,169 @@ func v3patch(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
 	w.Header().Set("Last-Modified", res.LastModified.Format(time.RFC1123))
+	w.Header().Set("ETag", v3etag(res))
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
 
-func v3delete(storage Storage, w http.ResponseWriter, r *http.Request, ref int) {
+// apply a merge patch to all reservations matching the resource and/or
+// name filter, an optional show selects the view as for GET
+func (h *v3handler) bulkpatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/merge-patch+json" {
+		v3error(w, "unknown content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var (
+		q        = r.URL.Query()
+		resource = q.Get("resource")
+		name     = q.Get("name")
+		show     = q.Get("show")
+	)
+
+	// without admin rights only one's own reservations are patched
+	owner := ""
+	if checkOwner {
+		user := r.Header.Get(UserHeader)
+		if !admins[user] {
+			if user == "" || (name != "" && name != user) {
+				v3error(w, fmt.Sprintf("reservations not owned by \"%s\"", user), http.StatusForbidden)
+				return
+			}
+			name = user
+			owner = r.Header.Get(OwnerHeader)
+		}
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, v3readlen(r, h.maxRead)))
+	if err != nil {
+		v3error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.storage.BulkPatch(resource, name, owner, show, b)
+	if err != nil {
+		v3error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reply := struct {
+		Status  string         `json:"status"`
+		Results []*PatchResult `json:"results"`
+	}{
+		Status:  "Success",
+		Results: results,
+	}
+
+	b, err = json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("patch: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (h *v3handler) delete(w http.ResponseWriter, r *http.Request, ref int) {
+	if !h.owner(w, r, ref) {
+		return
+	}
+
 	since := r.Header.Get("If-Unmodified-Since")
 	last, err := time.Parse(time.RFC1123, since)
 	if err != nil {
 		last = time.Now()
 	}
 
-	err = storage.Delete(ref, last)
+	err = h.storage.Delete(ref, last)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			v3error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 		if strings.Contains(err.Error(), "modified") {
+			v3error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		v3error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete the future reservations on every resource whose name starts
+// with the prefix, e.g. when decommissioning "esx0*" - admins only
+func (h *v3handler) bulkdelete(w http.ResponseWriter, r *http.Request) {
+	if !v3admin(w, r, "bulk delete") {
+		return
+	}
+
+	prefix := strings.TrimSuffix(r.URL.Query().Get("resource"), "*")
+
+	removed, skipped, err := h.storage.BulkDelete(prefix)
+	if err != nil {
+		if strings.Contains(err.Error(), "not specified") {
+			v3error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v3error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reply := struct {
+		Status   string `json:"status"`
+		Removed  int    `json:"removed"`
+		Skipped  int    `json:"skipped"`
+		Deleted  []int  `json:"deleted"`
+		Retained []int  `json:"retained"`
+	}{
+		Status:   "Success",
+		Removed:  len(removed),
+		Skipped:  len(skipped),
+		Deleted:  removed,
+		Retained: skipped,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("delete: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// holder reports use of an active reservation, see the no-show sweep
+func (h *v3handler) checkin(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref, err := strconv.Atoi(path)
+	if err != nil {
+		v3error(w, fmt.Sprintf("ref \"%s\" is not a number", path), http.StatusNotFound)
+		return
+	}
+
+	if !h.owner(w, r, ref) {
+		return
+	}
+
+	res, err := h.storage.CheckIn(ref)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			v3error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if strings.Contains(err.Error(), "not active") {
 			v3error(w, err.Error(), http.StatusConflict)
 			return
 		}
@@ -489,13 +1355,265 @@ func v3delete(storage Storage, w http.ResponseWriter, r *http.Request, ref int)
 		return
 	}
 
+	reply := struct {
+		Status      string       `json:"status"`
+		Reservation *Reservation `json:"reservation,omitempty"`
+	}{
+		Status:      "Success",
+		Reservation: res,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("checkin %d: %v", ref, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Last-Modified", res.LastModified.Format(time.RFC1123))
 	w.WriteHeader(http.StatusOK)
+	w.Write(b)
 }
 
-func v3cmd(storage Storage, w http.ResponseWriter, r *http.Request) {
-	// accept commands in JSON
-	// process command
-	// return JSON response
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintln(w, "v3cmd")
+// undo the recent delete of a reservation
+func (h *v3handler) restore(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodPost {
+		v3error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref, err := strconv.Atoi(path)
+	if err != nil {
+		v3error(w, fmt.Sprintf("ref \"%s\" is not a number", path), http.StatusNotFound)
+		return
+	}
+
+	if !h.ownerOf(w, r, ref, h.storage.GetDeleted) {
+		return
+	}
+
+	res, err := h.storage.Undelete(ref)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			v3error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if strings.Contains(err.Error(), "window passed") {
+			v3error(w, err.Error(), http.StatusGone)
+			return
+		}
+		v3refused(w, err, addStatus(err))
+		return
+	}
+
+	reply := struct {
+		Status      string       `json:"status"`
+		Reservation *Reservation `json:"reservation,omitempty"`
+	}{
+		Status:      "Success",
+		Reservation: res,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("restore %d: %v", ref, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", v3location(r, fmt.Sprintf("%s%d", V3api, res.ID)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Last-Modified", res.LastModified.Format(time.RFC1123))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// the logged operations on a reservation, oldest first
+func (h *v3handler) history(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref, err := strconv.Atoi(path)
+	if err != nil {
+		v3error(w, fmt.Sprintf("ref \"%s\" is not a number", path), http.StatusNotFound)
+		return
+	}
+
+	hist, err := h.storage.History(ref)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			v3error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		v3error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reply := struct {
+		Status  string          `json:"status"`
+		History []*HistoryEntry `json:"history"`
+	}{
+		Status:  "Success",
+		History: hist,
+	}
+
+	b, err := json.Marshal(reply)
+	if err != nil {
+		v3error(w, fmt.Sprintf("history %d: %v", ref, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	w.Write(b)
+}
+
+// commands are posted as JSON
+//
+//	{"command":"rename","from":"<resource>","to":"<resource>"}
+//	{"command":"reconcile","reload":<bool>}
+//	{"command":"config"}
+func (h *v3handler) cmd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		v3error(w, fmt.Sprintf("method \"%s\" not supported", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		v3error(w, "request not JSON", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	req := struct {
+		Command string `json:"command"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Reload  bool   `json:"reload"`
+	}{}
+
+	err := json.NewDecoder(io.LimitReader(r.Body, v3readlen(r, h.maxRead))).Decode(&req)
+	if err != nil {
+		v3error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Command {
+	case "rename":
+		if !v3admin(w, r, "rename") {
+			return
+		}
+
+		if !v3writable(w) {
+			return
+		}
+
+		res, err := h.storage.Rename(req.From, req.To)
+		if err != nil {
+			if strings.Contains(err.Error(), "conflict") || strings.Contains(err.Error(), "blackout") {
+				v3error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			v3error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reply := struct {
+			Status       string         `json:"status"`
+			Reservations []*Reservation `json:"reservations"`
+		}{
+			Status:       "Success",
+			Reservations: res,
+		}
+
+		b, err := json.Marshal(reply)
+		if err != nil {
+			v3error(w, fmt.Sprintf("rename: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+
+	case "reconcile":
+		if !v3admin(w, r, "reconcile") {
+			return
+		}
+
+		if req.Reload && !v3writable(w) {
+			return
+		}
+
+		diffs, err := h.storage.Reconcile(req.Reload)
+		if err != nil {
+			v3error(w, fmt.Sprintf("reconcile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		reply := struct {
+			Status        string         `json:"status"`
+			Reloaded      bool           `json:"reloaded"`
+			Discrepancies []*Discrepancy `json:"discrepancies"`
+		}{
+			Status:        "Success",
+			Reloaded:      req.Reload && len(diffs) > 0,
+			Discrepancies: diffs,
+		}
+
+		b, err := json.Marshal(reply)
+		if err != nil {
+			v3error(w, fmt.Sprintf("reconcile: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+
+	case "config":
+		if !v3admin(w, r, "config") {
+			return
+		}
+
+		if h.config == nil {
+			v3error(w, "config not available", http.StatusNotFound)
+			return
+		}
+
+		// read-only is toggled at runtime
+		config := *h.config
+		config.ReadOnly = isReadOnly()
+
+		reply := struct {
+			Status string        `json:"status"`
+			Config *serverConfig `json:"config"`
+		}{
+			Status: "Success",
+			Config: &config,
+		}
+
+		b, err := json.Marshal(reply)
+		if err != nil {
+			v3error(w, fmt.Sprintf("config: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+
+	default:
+		v3error(w, fmt.Sprintf("unknown command \"%s\"", req.Command), http.StatusBadRequest)
+	}
 }
@@ -4,14 +4,18 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,7 +35,7 @@ func (s *apiStorage) GetById(resid int) (*Reservation, error) {
 	return s.reservations[0], s.error
 }
 
-func (s *apiStorage) List(resource, show string, start, length int) ([]*Reservation, error) {
+func (s *apiStorage) List(resource, show, initials, tag string, start, length int) ([]*Reservation, error) {
 	if s.error != nil {
 		return nil, s.error
 	}
@@ -49,18 +53,135 @@ func (s *apiStorage) List(resource, show string, start, length int) ([]*Reservat
 	return res, nil
 }
 
+func (s *apiStorage) ListRange(resource, show, initials, tag string, from, to time.Time, start, length, snapshot int) ([]*Reservation, error) {
+	return s.List(resource, show, initials, tag, start, length)
+}
+
 func (s *apiStorage) Add(res *Reservation) error {
 	res.LastModified = time.Now()
 	return s.error
 }
 
+func (s *apiStorage) CheckAdd(res *Reservation) error {
+	return s.error
+}
+
+func (s *apiStorage) Waitlist(res *Reservation) error {
+	if s.error != nil {
+		return s.error
+	}
+
+	// the fake is always blocked for an hour
+	res.Start = res.Start.Add(time.Hour)
+	res.End = res.End.Add(time.Hour)
+	res.LastModified = time.Now()
+
+	return nil
+}
+
 func (s *apiStorage) Update(ref int, res *Reservation) (*Reservation, error) {
 	res.LastModified = time.Now()
 	return res, s.error
 }
 
+func (s *apiStorage) BulkPatch(resource, name, owner, show string, patch []byte) ([]*PatchResult, error) {
+	if s.error != nil {
+		return nil, s.error
+	}
+
+	results := make([]*PatchResult, 0)
+
+	for _, res := range s.reservations {
+		results = append(results, &PatchResult{ID: res.ID, Status: "Success"})
+	}
+
+	return results, nil
+}
+
 func (s *apiStorage) Delete(ref int, last time.Time) error { return s.error }
 
+func (s *apiStorage) Rename(from, to string) ([]*Reservation, error) {
+	if s.error != nil {
+		return nil, s.error
+	}
+
+	for _, res := range s.reservations {
+		res.Resource = to
+	}
+
+	return s.reservations, nil
+}
+
+func (s *apiStorage) Reconcile(reload bool) ([]*Discrepancy, error) {
+	if s.error != nil {
+		return nil, s.error
+	}
+
+	return []*Discrepancy{}, nil
+}
+
+func (s *apiStorage) Generation() (uint64, time.Time) { return 0, time.Time{} }
+
+func (s *apiStorage) Snapshot() int { return noSnapshot }
+
+func (s *apiStorage) BulkDelete(prefix string) ([]int, []int, error) {
+	return nil, nil, s.error
+}
+
+func (s *apiStorage) CheckIn(ref int) (*Reservation, error) {
+	if len(s.reservations) == 0 {
+		return nil, s.error
+	}
+
+	s.reservations[0].CheckedIn = true
+
+	return s.reservations[0], s.error
+}
+
+func (s *apiStorage) Undelete(ref int) (*Reservation, error) {
+	if len(s.reservations) == 0 {
+		return nil, s.error
+	}
+
+	return s.reservations[0], s.error
+}
+
+func (s *apiStorage) GetDeleted(ref int) (*Reservation, error) {
+	return s.GetById(ref)
+}
+
+func (s *apiStorage) History(ref int) ([]*HistoryEntry, error) {
+	if s.error != nil {
+		return nil, s.error
+	}
+
+	hist := make([]*HistoryEntry, 0)
+
+	for _, res := range s.reservations {
+		hist = append(hist, &HistoryEntry{Operation: "add", Time: res.LastModified, Reservation: res})
+	}
+
+	return hist, nil
+}
+
+func (s *apiStorage) Hold(res *Reservation, ttl time.Duration) error {
+	return s.Add(res)
+}
+
+func (s *apiStorage) ConfirmHold(ref int) (*Reservation, error) {
+	for _, res := range s.reservations {
+		if res.ID == ref {
+			return res, s.error
+		}
+	}
+
+	return nil, errors.New("hold not found")
+}
+
+func (s *apiStorage) GetHold(ref int) (*Reservation, error) {
+	return s.ConfirmHold(ref)
+}
+
 type badReader struct{}
 
 func (r *badReader) Read([]byte) (int, error) { return 0, errors.New("fail") }
@@ -89,7 +210,7 @@ func TestV3APIOptions(t *testing.T) {
 		t.Fatalf("expected content type \"%s\" got \"%s\"", exp, resp.Header.Get("Content-Type"))
 	}
 
-	exp = "OPTIONS, HEAD, GET, POST"
+	exp = "OPTIONS, HEAD, GET, POST, PATCH, DELETE"
 	if resp.Header.Get("Allow") != exp {
 		t.Fatalf("expected allow field \"%s\" got \"%s\"", exp, resp.Header.Get("Allow"))
 	}
@@ -127,6 +248,82 @@ func TestV3APIOptions(t *testing.T) {
 	}
 }
 
+func TestV3APICORS(t *testing.T) {
+	handler := v3res(&apiStorage{})
+
+	preflight := func(path, origin string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result()
+	}
+
+	// off by default
+	resp := preflight("", "https://frontend.example.com")
+
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" || strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Origin") {
+		t.Fatalf("expected no CORS headers got %v", resp.Header)
+	}
+
+	corsOrigins["https://frontend.example.com"] = true
+	defer delete(corsOrigins, "https://frontend.example.com")
+
+	for _, path := range []string{"", "42", "hold"} {
+		resp = preflight(path, "https://frontend.example.com")
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s: expected status code 204 got %d", path, resp.StatusCode)
+		}
+
+		if resp.Header.Get("Access-Control-Allow-Origin") != "https://frontend.example.com" {
+			t.Fatalf("%s: expected allowed origin got \"%s\"", path, resp.Header.Get("Access-Control-Allow-Origin"))
+		}
+
+		if !strings.Contains(resp.Header.Get("Access-Control-Allow-Methods"), "POST") {
+			t.Fatalf("%s: expected POST allowed got \"%s\"", path, resp.Header.Get("Access-Control-Allow-Methods"))
+		}
+
+		if !strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Content-Type") {
+			t.Fatalf("%s: expected Content-Type allowed got \"%s\"", path, resp.Header.Get("Access-Control-Allow-Headers"))
+		}
+	}
+
+	resp = preflight("", "https://elsewhere.example.com")
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected plain OPTIONS status code 200 got %d", resp.StatusCode)
+	}
+
+	for _, hdr := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+		if resp.Header.Get(hdr) != "" {
+			t.Fatalf("expected no %s for a disallowed origin got \"%s\"", hdr, resp.Header.Get(hdr))
+		}
+	}
+
+	if !strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Origin") {
+		t.Fatalf("expected vary on origin got %v", resp.Header.Values("Vary"))
+	}
+
+	// the actual request carries the origin too
+	req, _ := http.NewRequest(http.MethodGet, "", nil)
+	req.Header.Set("Origin", "https://frontend.example.com")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	resp = w.Result()
+
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://frontend.example.com" {
+		t.Fatalf("expected origin echoed got \"%s\"", resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	if !strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "X-Next-Reservation") {
+		t.Fatalf("expected paging header exposed got \"%s\"", resp.Header.Get("Access-Control-Expose-Headers"))
+	}
+}
+
 func TestV3APIMethodNotAllowed(t *testing.T) {
 	handler := v3res(&apiStorage{})
 	req, _ := http.NewRequest(http.MethodConnect, "", nil)
@@ -314,6 +511,150 @@ func TestV3APIGetLimit(t *testing.T) {
 	}
 }
 
+func TestV3APIGetSnapshot(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	count := len(storage.reservations)
+
+	service, _ = url.Parse("http://localhost")
+
+	handler := v3res(storage)
+
+	page := func(query string) ([]*Reservation, string) {
+		r, _ := http.NewRequest(http.MethodGet, query, nil)
+		r.RequestURI = query
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+		}
+
+		reply := struct {
+			Reservations []*Reservation `json:"reservations"`
+		}{}
+
+		err := json.NewDecoder(resp.Body).Decode(&reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := resp.Header.Get("X-Next-Reservation")
+		if next != "" {
+			u, _ := url.Parse(next)
+			next = "?" + u.RawQuery
+		}
+
+		return reply.Reservations, next
+	}
+
+	seen, next := page("?show=all&limit=3")
+
+	// added mid-scroll, after the first page fixed the snapshot
+	err := storage.Add(&Reservation{
+		Resource: "resource N",
+		Start:    now.Add(time.Hour),
+		End:      now.Add(2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for next != "" {
+		var res []*Reservation
+
+		res, next = page(next)
+		seen = append(seen, res...)
+	}
+
+	if len(seen) != count {
+		t.Fatalf("expected %d reservations got %d", count, len(seen))
+	}
+
+	for _, r := range seen {
+		if r.Resource == "resource N" {
+			t.Fatalf("reservation %d added after snapshot listed", r.ID)
+		}
+	}
+
+	// a new scroll sees it
+	seen, _ = page("?show=all&limit=100")
+	if len(seen) != count+1 {
+		t.Fatalf("expected %d reservations got %d", count+1, len(seen))
+	}
+}
+
+func TestV3APIGetSort(t *testing.T) {
+	now := time.Now()
+
+	storage := &apiStorage{
+		reservations: []*Reservation{
+			&Reservation{ID: 40, Resource: "win-02", Name: "Carl", Start: now, End: now.Add(time.Hour)},
+			&Reservation{ID: 12, Resource: "lin-05", Name: "Bob", Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)},
+			&Reservation{ID: 27, Resource: "esx-01", Name: "Alice", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+			&Reservation{ID: 33, Resource: "lin-01", Name: "Dave", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)},
+		},
+	}
+
+	service, _ = url.Parse("http://localhost")
+
+	handler := v3res(storage)
+
+	tests := []struct {
+		sort string
+		ids  []int
+	}{
+		{sort: "", ids: []int{12, 27, 33, 40}},
+		{sort: "id", ids: []int{12, 27, 33, 40}},
+		{sort: "resource", ids: []int{33, 12, 27, 40}},
+		{sort: "date", ids: []int{40, 27, 33, 12}},
+		{sort: "name", ids: []int{27, 12, 40, 33}},
+	}
+
+	for _, tc := range tests {
+		t.Run("sort="+tc.sort, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "?sort="+tc.sort, nil)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+			}
+
+			reply := struct {
+				Reservations []*Reservation `json:"reservations"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(reply.Reservations) != len(tc.ids) {
+				t.Fatalf("expected %d reservations got %d", len(tc.ids), len(reply.Reservations))
+			}
+
+			for i, id := range tc.ids {
+				if reply.Reservations[i].ID != id {
+					t.Fatalf("expected reservation %d at %d got %d", id, i, reply.Reservations[i].ID)
+				}
+			}
+		})
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "?sort=color", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %d", w.Result().StatusCode)
+	}
+}
+
 func TestV3APIGetRef(t *testing.T) {
 	now := time.Now()
 
@@ -391,6 +732,16 @@ func TestV3APIGetRefCached(t *testing.T) {
 	if resp.Header.Get("Content-Type") != exp {
 		t.Fatalf("expected content type \"%s\" got \"%s\"", exp, resp.Header.Get("Content-Type"))
 	}
+
+	// modified since the client's copy
+	r, _ = http.NewRequest(http.MethodGet, "35", nil)
+	r.Header.Set("If-Modified-Since", res.LastModified.Add(-time.Minute).Format(time.RFC1123))
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", w.Result().StatusCode)
+	}
 }
 
 func TestV3APIGetRefFail(t *testing.T) {
@@ -727,6 +1078,253 @@ func TestV3APIPost(t *testing.T) {
 	}
 }
 
+func TestV3APIPostLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		proto    string
+		fwdhost  string
+		location string
+	}{
+		{name: "relative", location: "/v3/reservations/0"},
+		{name: "host", host: "localhost:8080", location: "http://localhost:8080/v3/reservations/0"},
+		{name: "forwarded", host: "10.0.0.5:8080", proto: "https", fwdhost: "reserve.company.com", location: "https://reserve.company.com/v3/reservations/0"},
+		{name: "chain", host: "10.0.0.5:8080", proto: "https, http", fwdhost: "reserve.company.com, proxy.internal", location: "https://reserve.company.com/v3/reservations/0"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := bytes.NewBufferString(`{"resource":"thing","name":"Some User"}`)
+
+			handler := v3res(&apiStorage{})
+			r, _ := http.NewRequest(http.MethodPost, "", b)
+			r.Host = tc.host
+			r.Header.Set("Content-Type", "application/json")
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			if tc.fwdhost != "" {
+				r.Header.Set("X-Forwarded-Host", tc.fwdhost)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("expected status code 201 got %d", resp.StatusCode)
+			}
+
+			if resp.Header.Get("Location") != tc.location {
+				t.Fatalf("expected location \"%s\" got \"%s\"", tc.location, resp.Header.Get("Location"))
+			}
+
+			reply := struct {
+				Location string `json:"location"`
+			}{}
+
+			json.NewDecoder(resp.Body).Decode(&reply)
+
+			if reply.Location != tc.location {
+				t.Fatalf("expected reply location \"%s\" got \"%s\"", tc.location, reply.Location)
+			}
+		})
+	}
+}
+
+func TestV3APIReadOnly(t *testing.T) {
+	setReadOnly(true)
+	defer setReadOnly(false)
+
+	storage, _ := fillMemory(true)
+	count := len(storage.reservations)
+
+	service, _ = url.Parse("http://localhost")
+
+	handler := v3res(storage)
+
+	b := bytes.NewBufferString(`{"resource":"thing","name":"Some User"}`)
+	r, _ := http.NewRequest(http.MethodPost, "", b)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code 503 got %d", w.Result().StatusCode)
+	}
+
+	if w.Result().Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON error got \"%s\"", w.Result().Header.Get("Content-Type"))
+	}
+
+	if len(storage.reservations) != count {
+		t.Fatal("reservation added in read-only mode")
+	}
+
+	r, _ = http.NewRequest(http.MethodDelete, "35", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code 503 got %d", w.Result().StatusCode)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", w.Result().StatusCode)
+	}
+
+	// checks that change nothing still run
+	h := &v3handler{
+		storage: storage,
+		maxRead: v3MaxRead,
+		keys:    newKeyCache(idempotencyTTL),
+		config:  &serverConfig{},
+	}
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"?validate=true", `{"resource":"thing","name":"Some User"}`, http.StatusOK},
+		{"command", `{"command":"config"}`, http.StatusOK},
+		{"command", `{"command":"reconcile"}`, http.StatusOK},
+		{"command", `{"command":"reconcile","reload":true}`, http.StatusServiceUnavailable},
+		{"command", `{"command":"rename","from":"resource C","to":"resource N"}`, http.StatusServiceUnavailable},
+		{"hold?validate=true", `{"resource":"thing","name":"Some User"}`, http.StatusServiceUnavailable},
+	}
+
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		admins = map[string]bool{}
+	}()
+
+	for _, tc := range tests {
+		r, _ = http.NewRequest(http.MethodPost, tc.path, bytes.NewBufferString(tc.body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(UserHeader, "Admin User")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Result().StatusCode != tc.status {
+			t.Fatalf("%s %s: expected status code %d got %d", tc.path, tc.body, tc.status, w.Result().StatusCode)
+		}
+
+		if tc.body != `{"command":"config"}` {
+			continue
+		}
+
+		var rpy struct {
+			Config *serverConfig `json:"config"`
+		}
+
+		err := json.NewDecoder(w.Result().Body).Decode(&rpy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rpy.Config == nil || !rpy.Config.ReadOnly {
+			t.Fatalf("expected config to report read-only got %+v", rpy.Config)
+		}
+	}
+
+	if len(storage.reservations) != count {
+		t.Fatal("reservation added in read-only mode")
+	}
+}
+
+func TestV3APIPostWaitlist(t *testing.T) {
+	start := time.Date(2021, time.April, 1, 8, 0, 0, 0, time.UTC)
+
+	res := &Reservation{
+		Resource: "thing",
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Name:     "Some User",
+	}
+
+	for _, waitlist := range []bool{false, true} {
+		b, _ := json.Marshal(res)
+
+		handler := v3res(&apiStorage{})
+		r, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("?waitlist=%t", waitlist), bytes.NewBuffer(b))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status code 201 got %d", resp.StatusCode)
+		}
+
+		shift := ""
+		if waitlist {
+			shift = "1h0m0s"
+		}
+
+		if resp.Header.Get("X-Reservation-Shift") != shift {
+			t.Fatalf("expected shift \"%s\" got \"%s\"", shift, resp.Header.Get("X-Reservation-Shift"))
+		}
+
+		if waitlist && resp.Header.Get("X-Reservation-Start") != "2021-04-01T09:00:00Z" {
+			t.Fatalf("expected start \"%s\" got \"%s\"", "2021-04-01T09:00:00Z", resp.Header.Get("X-Reservation-Start"))
+		}
+	}
+}
+
+func TestV3APIPostValidate(t *testing.T) {
+	start := time.Date(2021, time.April, 1, 8, 0, 0, 0, time.UTC)
+
+	res := &Reservation{
+		Resource: "thing",
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Name:     "Some User",
+	}
+
+	tests := []struct {
+		name   string
+		error  error
+		status int
+		body   string
+	}{
+		{name: "clear", status: http.StatusOK, body: `{"status":"Success","result":"would succeed"}`},
+		{name: "conflict", error: errors.New("reservation range conflict"), status: http.StatusConflict, body: `{"status":"Error","error":"reservation range conflict"}`},
+		{name: "duration", error: errors.New("reservation exceeds maximum duration 1h0m0s"), status: http.StatusBadRequest, body: `{"status":"Error","error":"reservation exceeds maximum duration 1h0m0s"}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, _ := json.Marshal(res)
+
+			handler := v3res(&apiStorage{error: tc.error})
+			r, _ := http.NewRequest(http.MethodPost, "?validate=true", bytes.NewBuffer(b))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+
+			if resp.Header.Get("Location") != "" {
+				t.Fatalf("expected no location got \"%s\"", resp.Header.Get("Location"))
+			}
+
+			if w.Body.String() != tc.body {
+				t.Fatalf("expected body %s got %s", tc.body, w.Body.String())
+			}
+		})
+	}
+}
+
 func TestV3APIPostContentLengthInvalid(t *testing.T) {
 	now := time.Now()
 
@@ -849,19 +1447,60 @@ func TestV3APIPostFail(t *testing.T) {
 	}
 }
 
-func TestV3APIPostBadJSON(t *testing.T) {
-	b := bytes.NewBufferString("this isn't json")
-	handler := v3res(&apiStorage{})
-	r, _ := http.NewRequest(http.MethodPost, "", b)
-	r.Header.Set("Content-Type", "application/json")
-	w := httptest.NewRecorder()
-	handler(w, r)
-
-	resp := w.Result()
+func TestV3APIPostConflict(t *testing.T) {
+	now := time.Now()
 
-	out, err := httputil.DumpResponse(resp, true)
-	if err != nil {
-		t.Fatal(err)
+	res := &Reservation{
+		Resource: "thing",
+		Start:    now,
+		End:      now.Add(30 * time.Second),
+		Name:     "Some User",
+		Initials: "SU",
+	}
+
+	for _, errstr := range []string{"reservation range conflict", "resource on loan"} {
+		resreq, _ := json.MarshalIndent(res, "", "    ")
+		b := bytes.NewBuffer(resreq)
+
+		handler := v3res(&apiStorage{error: errors.New(errstr)})
+		r, _ := http.NewRequest(http.MethodPost, "", b)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		out, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Println(string(out))
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("%s: expected status code 409 got %d", errstr, resp.StatusCode)
+		}
+
+		exp := "application/json"
+		if resp.Header.Get("Content-Type") != exp {
+			t.Fatalf("expected content type \"%s\" got \"%s\"", exp, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestV3APIPostBadJSON(t *testing.T) {
+	b := bytes.NewBufferString("this isn't json")
+	handler := v3res(&apiStorage{})
+	r, _ := http.NewRequest(http.MethodPost, "", b)
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	fmt.Println(string(out))
@@ -1274,6 +1913,45 @@ func TestV3APIPutConflict(t *testing.T) {
 	}
 }
 
+// a stale update is a failed precondition, not a scheduling conflict
+func TestV3APIPutModified(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		ID:       45,
+		Resource: "some resource",
+		Start:    now.Add(30 * time.Second),
+		End:      now.Add(60 * time.Second),
+		Name:     "Some User",
+	}
+
+	for _, tc := range []struct {
+		errstr string
+		status int
+	}{
+		{errstr: "modified", status: http.StatusPreconditionFailed},
+		{errstr: "reservation range conflict", status: http.StatusConflict},
+		{errstr: "resource on loan", status: http.StatusConflict},
+	} {
+		storage := &apiStorage{
+			error:        errors.New(tc.errstr),
+			reservations: []*Reservation{res},
+		}
+
+		handler := v3res(storage)
+
+		resreq, _ := json.Marshal(res)
+		r, _ := http.NewRequest(http.MethodPut, "45", bytes.NewBuffer(resreq))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if resp := w.Result(); resp.StatusCode != tc.status {
+			t.Fatalf("%s: expected status code %d got %s", tc.errstr, tc.status, resp.Status)
+		}
+	}
+}
+
 func TestV3APIPatch(t *testing.T) {
 	now := time.Now()
 
@@ -1317,6 +1995,236 @@ func TestV3APIPatch(t *testing.T) {
 	}
 }
 
+func TestV3APIPatchRejected(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	service, _ = url.Parse("http://localhost")
+
+	handler := v3res(storage)
+
+	b := bytes.NewBufferString(`{"notes":"changed","resource":"resource N","links":["not a link"]}`)
+	r, _ := http.NewRequest(http.MethodPatch, "78", b)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %d", w.Result().StatusCode)
+	}
+
+	res, err := storage.GetById(78)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Notes != "" || res.Resource != "resource A" || len(res.Links) != 0 {
+		t.Fatalf("rejected patch applied to stored reservation %+v", res)
+	}
+
+	b = bytes.NewBufferString(`{"tags":["not a tag"]}`)
+	r, _ = http.NewRequest(http.MethodPatch, "78", b)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 for invalid tag got %d", w.Result().StatusCode)
+	}
+}
+
+func TestV3APIPatchMoveConflict(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	service, _ = url.Parse("http://localhost")
+
+	handler := v3res(storage)
+
+	b := bytes.NewBufferString(`{"resource":"resource C"}`)
+	r, _ := http.NewRequest(http.MethodPatch, "79", b)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 got %d", w.Result().StatusCode)
+	}
+
+	var rpy struct {
+		Conflict *Reservation `json:"conflict"`
+	}
+
+	err := json.NewDecoder(w.Result().Body).Decode(&rpy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rpy.Conflict == nil || rpy.Conflict.ID != 80 {
+		t.Fatalf("expected conflict with 80 got %+v", rpy.Conflict)
+	}
+
+	res, err := storage.GetById(79)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Resource != "resource B" {
+		t.Fatalf("refused move applied, resource \"%s\"", res.Resource)
+	}
+}
+
+func TestV3APIPatchNull(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	service, _ = url.Parse("http://localhost")
+
+	res, err := storage.GetById(78)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Notes = "rack 4"
+
+	handler := v3res(storage)
+
+	patch := func(body string) *http.Response {
+		r, _ := http.NewRequest(http.MethodPatch, "78", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	resp := patch(`{"notes":null}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+	}
+
+	if res.Notes != "" {
+		t.Fatalf("expected notes cleared got \"%s\"", res.Notes)
+	}
+
+	resp = patch(`{"start":null}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %d", resp.StatusCode)
+	}
+
+	var rpy struct {
+		Error string `json:"error"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&rpy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rpy.Error != "start can't be cleared" {
+		t.Fatalf("expected start can't be cleared got \"%s\"", rpy.Error)
+	}
+
+	if res.Start.IsZero() {
+		t.Fatal("rejected patch cleared start")
+	}
+
+	resp = patch(`{"openEnded":null}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&rpy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rpy.Error != "openEnded can't be cleared" {
+		t.Fatalf("expected openEnded can't be cleared got \"%s\"", rpy.Error)
+	}
+}
+
+func TestV3APIHistory(t *testing.T) {
+	filename := time.Now().Format("reservations-20060102150405000000.jsonl")
+
+	js, err := NewJSONL(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	storage, err := NewMemory(js, &memtestMailer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	res := &Reservation{
+		Resource: "resource",
+		Start:    now.Add(time.Hour),
+		End:      now.Add(2 * time.Hour),
+		Name:     "Some User",
+	}
+
+	err = storage.Add(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, notes := range []string{"first", "second"} {
+		req := *res
+		req.Notes = notes
+
+		res, err = storage.Update(req.ID, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := v3res(storage)
+
+	r, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%d/history", res.ID), nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	reply := struct {
+		History []*HistoryEntry `json:"history"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reply.History) != 3 {
+		t.Fatalf("expected %d history entries got %d", 3, len(reply.History))
+	}
+
+	for i, exp := range []string{"add", "modify", "modify"} {
+		if reply.History[i].Operation != exp {
+			t.Fatalf("expected entry %d \"%s\" got \"%s\"", i, exp, reply.History[i].Operation)
+		}
+
+		if reply.History[i].Time.IsZero() {
+			t.Fatalf("entry %d has no time", i)
+		}
+	}
+
+	if reply.History[2].Reservation.Notes != "second" {
+		t.Fatalf("expected notes \"second\" got \"%s\"", reply.History[2].Reservation.Notes)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "999/history", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code 404 got %d", w.Result().StatusCode)
+	}
+}
+
 func TestV3APIPatchModified(t *testing.T) {
 	then := time.Now()
 	now := time.Now().Add(60 * time.Second)
@@ -1352,8 +2260,8 @@ func TestV3APIPatchModified(t *testing.T) {
 
 	fmt.Println(string(out))
 
-	if resp.StatusCode != http.StatusConflict {
-		t.Fatalf("expected status code 409 got %s", resp.Status)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected status code 412 got %s", resp.Status)
 	}
 
 	exp := "application/json"
@@ -1480,7 +2388,9 @@ func TestV3APIPatchReadError(t *testing.T) {
 func TestV3APIPatchBadJSON(t *testing.T) {
 	b := bytes.NewBufferString("this ain't json")
 
-	handler := v3res(&apiStorage{})
+	storage := &apiStorage{reservations: []*Reservation{&Reservation{}}}
+
+	handler := v3res(storage)
 	r, _ := http.NewRequest(http.MethodPatch, "0", b)
 	r.Header.Set("Content-Type", "application/merge-patch+json")
 	w := httptest.NewRecorder()
@@ -1505,14 +2415,1373 @@ func TestV3APIPatchBadJSON(t *testing.T) {
 	}
 }
 
-func TestPatchGeneration(t *testing.T) {
-	m := make(map[string]interface{})
-	m["name"] = "Some User"
-	m["loan"] = true
+func TestV3APIGetRefETag(t *testing.T) {
+	now := time.Now()
 
-	b, err := json.MarshalIndent(m, "", "    ")
+	res := &Reservation{
+		ID:           35,
+		LastModified: now,
+		Resource:     "a thing",
+		Start:        now.Add(30 * time.Second),
+		End:          now.Add(60 * time.Second),
+		Name:         "Some User",
+	}
+
+	storage := &apiStorage{reservations: []*Reservation{res}}
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodGet, "35", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected ETag header")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "35", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	out, err := httputil.DumpResponse(resp, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Println(string(b))
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected status code 304 got %d", resp.StatusCode)
+	}
+
+	// a change within the same second gives a new tag
+	res.Notes = "changed"
+
+	r, _ = http.NewRequest(http.MethodGet, "35", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+	}
+
+	if resp.Header.Get("ETag") == etag {
+		t.Fatalf("expected new ETag got \"%s\"", etag)
+	}
+}
+
+func TestV3APIPatchETag(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		ID:           45,
+		LastModified: now,
+		Resource:     "some resource",
+		Start:        now.Add(30 * time.Second),
+		End:          now.Add(60 * time.Second),
+		Name:         "Some User",
+	}
+
+	storage := &apiStorage{reservations: []*Reservation{res}}
+
+	handler := v3res(storage)
+
+	tests := []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"If-Match", `"stale"`, http.StatusPreconditionFailed},
+		{"If-None-Match", "*", http.StatusPreconditionFailed},
+		{"If-Match", v3etag(res), http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		b := bytes.NewBufferString(`{"notes":"etag"}`)
+
+		r, _ := http.NewRequest(http.MethodPatch, "45", b)
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		r.Header.Set(tc.header, tc.value)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		out, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Println(string(out))
+
+		if resp.StatusCode != tc.status {
+			t.Fatalf("expected status code %d got %s", tc.status, resp.Status)
+		}
+	}
+}
+
+func TestV3APIPatchETagRoundTrip(t *testing.T) {
+	storage, _ := fillMemory(true)
+	storage.mail = &mail{
+		names: map[string]*Email{
+			"Some User": &Email{Email: "some.user@company.com", Valid: true},
+		},
+	}
+	storage.reservations[1].Name = "Some User"
+
+	handler := v3res(storage)
+
+	patch := func(notes, etag string) *http.Response {
+		b := bytes.NewBufferString(`{"notes":"` + notes + `"}`)
+
+		r, _ := http.NewRequest(http.MethodPatch, "78", b)
+		r.Header.Set("Content-Type", "application/merge-patch+json")
+		if etag != "" {
+			r.Header.Set("If-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w.Result()
+	}
+
+	etag := ""
+
+	for _, notes := range []string{"first", "second", "third"} {
+		resp := patch(notes, etag)
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected status code 200 got %s", notes, resp.Status)
+		}
+
+		etag = resp.Header.Get("ETag")
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "78", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Result().Header.Get("ETag") != etag {
+		t.Fatalf("expected GET ETag %s got %s", etag, w.Result().Header.Get("ETag"))
+	}
+}
+
+func TestV3APIPutETag(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		ID:           45,
+		LastModified: now,
+		Resource:     "some resource",
+		Start:        now.Add(30 * time.Second),
+		End:          now.Add(60 * time.Second),
+		Name:         "Some User",
+	}
+
+	storage := &apiStorage{reservations: []*Reservation{res}}
+
+	resreq, _ := json.Marshal(res)
+	b := bytes.NewBuffer(resreq)
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodPut, "45", b)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("If-Match", `"stale"`)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("expected status code 412 got %s", resp.Status)
+	}
+}
+
+func TestV3APIPutPastEnd(t *testing.T) {
+	defer func() {
+		rejectPastEnd = true
+	}()
+
+	tests := []struct {
+		name   string
+		reject bool
+		status int
+	}{
+		{name: "rejected", reject: true, status: http.StatusBadRequest},
+		{name: "allowed", reject: false, status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rejectPastEnd = tc.reject
+
+			now := time.Now()
+
+			res := &Reservation{
+				ID:       45,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+				Name:     "Some User",
+			}
+
+			storage := &apiStorage{reservations: []*Reservation{res}}
+
+			req := *res
+			req.End = now.Add(-time.Minute)
+
+			resreq, _ := json.Marshal(&req)
+
+			handler := v3res(storage)
+			r, _ := http.NewRequest(http.MethodPut, "45", bytes.NewBuffer(resreq))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+
+			if tc.reject {
+				var rpy struct {
+					Error string `json:"error"`
+				}
+
+				json.NewDecoder(resp.Body).Decode(&rpy)
+
+				if rpy.Error != "end in the past" {
+					t.Fatalf("expected \"end in the past\" got \"%s\"", rpy.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchGeneration(t *testing.T) {
+	m := make(map[string]interface{})
+	m["name"] = "Some User"
+	m["loan"] = true
+
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+func TestV3APIOwner(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		method string
+		user   string
+		status int
+	}{
+		{name: "owner put", method: http.MethodPut, user: "Some User", status: http.StatusOK},
+		{name: "owner patch", method: http.MethodPatch, user: "Some User", status: http.StatusOK},
+		{name: "owner delete", method: http.MethodDelete, user: "Some User", status: http.StatusOK},
+		{name: "other put", method: http.MethodPut, user: "Another User", status: http.StatusForbidden},
+		{name: "other patch", method: http.MethodPatch, user: "Another User", status: http.StatusForbidden},
+		{name: "other delete", method: http.MethodDelete, user: "Another User", status: http.StatusForbidden},
+		{name: "anonymous delete", method: http.MethodDelete, user: "", status: http.StatusForbidden},
+		{name: "admin put", method: http.MethodPut, user: "Admin User", status: http.StatusOK},
+		{name: "admin patch", method: http.MethodPatch, user: "Admin User", status: http.StatusOK},
+		{name: "admin delete", method: http.MethodDelete, user: "Admin User", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				ID:       45,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+				Name:     "Some User",
+			}
+
+			storage := &apiStorage{reservations: []*Reservation{res}}
+
+			var b *bytes.Buffer
+			var content string
+
+			switch tc.method {
+			case http.MethodPut:
+				resreq, _ := json.Marshal(res)
+				b = bytes.NewBuffer(resreq)
+				content = "application/json"
+			case http.MethodPatch:
+				b = bytes.NewBufferString(`{"notes":"some notes"}`)
+				content = "application/merge-patch+json"
+			default:
+				b = &bytes.Buffer{}
+			}
+
+			handler := v3res(storage)
+			r, _ := http.NewRequest(tc.method, "45", b)
+			if content != "" {
+				r.Header.Set("Content-Type", content)
+			}
+			if tc.user != "" {
+				r.Header.Set(UserHeader, tc.user)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestV3APIOwnerRestore(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		user   string
+		status int
+	}{
+		{name: "owner", user: "Some User", status: http.StatusOK},
+		{name: "other", user: "Another User", status: http.StatusForbidden},
+		{name: "anonymous", user: "", status: http.StatusForbidden},
+		{name: "admin", user: "Admin User", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				ID:       45,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+				Name:     "Some User",
+			}
+
+			storage := &apiStorage{reservations: []*Reservation{res}}
+
+			handler := v3res(storage)
+			r, _ := http.NewRequest(http.MethodPost, "45/restore", &bytes.Buffer{})
+			if tc.user != "" {
+				r.Header.Set(UserHeader, tc.user)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestV3APIOwnerConfirm(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		user   string
+		status int
+	}{
+		{name: "owner", user: "Some User", status: http.StatusOK},
+		{name: "other", user: "Another User", status: http.StatusForbidden},
+		{name: "admin", user: "Admin User", status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				ID:       45,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+				Name:     "Some User",
+			}
+
+			storage := &apiStorage{reservations: []*Reservation{res}}
+
+			handler := v3res(storage)
+			r, _ := http.NewRequest(http.MethodPost, "45/confirm", &bytes.Buffer{})
+			r.Header.Set(UserHeader, tc.user)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestV3APIOwnerEmail(t *testing.T) {
+	checkOwner = true
+	defer func() {
+		checkOwner = false
+	}()
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		owner  string
+		status int
+	}{
+		{name: "same email", owner: "pat@a.example.com", status: http.StatusOK},
+		{name: "same email case", owner: "Pat@A.example.com", status: http.StatusOK},
+		{name: "other email", owner: "pat@b.example.com", status: http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Reservation{
+				ID:       45,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+				Name:     "Pat",
+				Owner:    "pat@a.example.com",
+			}
+
+			storage := &apiStorage{reservations: []*Reservation{res}}
+
+			handler := v3res(storage)
+			r, _ := http.NewRequest(http.MethodDelete, "45", &bytes.Buffer{})
+			r.Header.Set(UserHeader, "Pat")
+			r.Header.Set(OwnerHeader, tc.owner)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestV3APIBulkPatch(t *testing.T) {
+	now := time.Now()
+
+	storage := &apiStorage{
+		reservations: []*Reservation{
+			&Reservation{
+				ID:       35,
+				Resource: "some resource",
+				Start:    now.Add(30 * time.Second),
+				End:      now.Add(60 * time.Second),
+			},
+			&Reservation{
+				ID:       37,
+				Resource: "some resource",
+				Start:    now.Add(90 * time.Second),
+				End:      now.Add(120 * time.Second),
+			},
+		},
+	}
+
+	b := bytes.NewBufferString(`{"notes":"some notes"}`)
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodPatch, "?resource=some+resource", b)
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+	}
+
+	rpy := struct {
+		Status  string         `json:"status"`
+		Results []*PatchResult `json:"results"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&rpy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rpy.Results) != 2 {
+		t.Fatalf("expected %d results got %d", 2, len(rpy.Results))
+	}
+}
+
+func TestV3APICheckIn(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		ID:       45,
+		Resource: "some resource",
+		Start:    now.Add(-30 * time.Second),
+		End:      now.Add(60 * time.Second),
+		Name:     "Some User",
+	}
+
+	storage := &apiStorage{reservations: []*Reservation{res}}
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodPost, "45/checkin", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	if res.CheckedIn == false {
+		t.Fatalf("expected reservation checked in")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "45/checkin", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status code 405 got %s", resp.Status)
+	}
+}
+
+func TestV3APIHold(t *testing.T) {
+	defer func(ttl time.Duration) {
+		holdTTL = ttl
+	}(holdTTL)
+
+	storage, now := fillMemory(true)
+	handler := v3res(storage)
+
+	hold := func(start time.Duration) int {
+		res := &Reservation{
+			Resource: "resource D",
+			Start:    now.Add(start),
+			End:      now.Add(start + 20*time.Second),
+			Name:     "Some User",
+		}
+
+		b, _ := json.Marshal(res)
+
+		r, _ := http.NewRequest(http.MethodPost, "hold", bytes.NewBuffer(b))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status code 201 got %s", resp.Status)
+		}
+
+		id, _ := strconv.Atoi(resp.Header.Get("ID"))
+
+		return id
+	}
+
+	confirm := func(id int) int {
+		r, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%d/confirm", id), nil)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	id := hold(100 * time.Second)
+
+	if status := confirm(id); status != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", status)
+	}
+
+	if status := confirm(id); status != http.StatusNotFound {
+		t.Fatalf("expected status code 404 got %d", status)
+	}
+
+	holdTTL = -time.Second
+
+	id = hold(200 * time.Second)
+
+	if status := confirm(id); status != http.StatusGone {
+		t.Fatalf("expected status code 410 got %d", status)
+	}
+}
+
+func TestV3APIPostMaxBody(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		Resource: "some resource",
+		Start:    now.Add(30 * time.Second),
+		End:      now.Add(60 * time.Second),
+		Name:     "Some User",
+		Notes:    "notes longer than the configured body limit",
+	}
+
+	resreq, _ := json.Marshal(res)
+
+	handler := &v3handler{
+		storage: &apiStorage{},
+		maxRead: 32,
+	}
+
+	r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBuffer(resreq))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %s", resp.Status)
+	}
+
+	// the same request fits the default limit
+	r, _ = http.NewRequest(http.MethodPost, "", bytes.NewBuffer(resreq))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	v3res(&apiStorage{})(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201 got %s", resp.Status)
+	}
+}
+
+func TestV3APIPostGzip(t *testing.T) {
+	now := time.Now()
+
+	res := &Reservation{
+		Resource: "some resource",
+		Start:    now.Add(30 * time.Second),
+		End:      now.Add(60 * time.Second),
+		Name:     "Some User",
+	}
+
+	var b bytes.Buffer
+
+	gz := gzip.NewWriter(&b)
+	json.NewEncoder(gz).Encode(res)
+	gz.Close()
+
+	handler := v3res(&apiStorage{})
+	r, _ := http.NewRequest(http.MethodPost, "", &b)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Content-Length", strconv.Itoa(b.Len()))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201 got %s", resp.Status)
+	}
+}
+
+func TestV3APIGetGzip(t *testing.T) {
+	now := time.Now()
+
+	storage := &apiStorage{
+		reservations: []*Reservation{
+			&Reservation{
+				ID:           35,
+				LastModified: now,
+				Resource:     "a thing",
+				Start:        now.Add(30 * time.Second),
+				End:          now.Add(60 * time.Second),
+				Name:         "Some User",
+			},
+		},
+	}
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodGet, "", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected content encoding \"gzip\" got \"%s\"", resp.Header.Get("Content-Encoding"))
+	}
+
+	if resp.Header.Get("Content-Length") != "" {
+		t.Fatalf("expected no content length got \"%s\"", resp.Header.Get("Content-Length"))
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := struct {
+		Status       string         `json:"status"`
+		Reservations []*Reservation `json:"reservations"`
+	}{}
+
+	err = json.NewDecoder(gz).Decode(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reply.Reservations) != 1 || reply.Reservations[0].ID != 35 {
+		t.Fatalf("expected reservation 35 got %+v", reply.Reservations)
+	}
+}
+
+func TestV3APIRename(t *testing.T) {
+	res := &Reservation{
+		ID:       45,
+		Resource: "lin-build-01",
+		Name:     "Some User",
+	}
+
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		admins = map[string]bool{}
+	}()
+
+	storage := &apiStorage{reservations: []*Reservation{res}}
+
+	handler := v3res(storage)
+
+	b := bytes.NewBufferString(`{"command":"rename","from":"lin-build-01","to":"build-01"}`)
+	r, _ := http.NewRequest(http.MethodPost, "command", b)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(UserHeader, "Admin User")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	out, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	if res.Resource != "build-01" {
+		t.Fatalf("expected resource \"%s\" got \"%s\"", "build-01", res.Resource)
+	}
+
+	storage.error = errors.New("reservation 45 range conflict with 50")
+
+	b = bytes.NewBufferString(`{"command":"rename","from":"build-01","to":"build-02"}`)
+	r, _ = http.NewRequest(http.MethodPost, "command", b)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(UserHeader, "Admin User")
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 got %s", resp.Status)
+	}
+
+	b = bytes.NewBufferString(`{"command":"frobnicate"}`)
+	r, _ = http.NewRequest(http.MethodPost, "command", b)
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %s", resp.Status)
+	}
+}
+
+func TestV3APIConfig(t *testing.T) {
+	config := &serverConfig{
+		Port:        "8080",
+		Data:        "reservations.jsonl",
+		Admins:      []string{"Some User"},
+		MaxQueued:   map[string]int{"resource A": 2},
+		MaxBody:     v3MaxRead,
+		ReadTimeout: 60,
+		MaxDuration: "720h",
+		CaseFold:    true,
+	}
+
+	h := &v3handler{
+		storage: &apiStorage{},
+		maxRead: v3MaxRead,
+		config:  config,
+	}
+
+	command := func(user string) *http.Response {
+		b := bytes.NewBufferString(`{"command":"config"}`)
+		r, _ := http.NewRequest(http.MethodPost, "command", b)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(UserHeader, user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	admins["Some User"] = true
+	defer delete(admins, "Some User")
+
+	resp := command("Some User")
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %s", resp.Status)
+	}
+
+	reply := struct {
+		Status string        `json:"status"`
+		Config *serverConfig `json:"config"`
+	}{}
+
+	err := json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := json.Marshal(reply.Config)
+	exp, _ := json.Marshal(config)
+
+	if string(got) != string(exp) {
+		t.Fatalf("expected %s got %s", exp, got)
+	}
+
+	// admins only, whether or not ownership is checked
+	for _, owners := range []bool{false, true} {
+		checkOwner = owners
+
+		if resp := command("Another User"); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("owners %v: expected status code 403 got %s", owners, resp.Status)
+		}
+
+		if resp := command(""); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("owners %v: expected status code 403 got %s", owners, resp.Status)
+		}
+	}
+
+	checkOwner = false
+}
+
+func TestV3APIGetAfterDelete(t *testing.T) {
+	storage, now := fillMemory(true)
+
+	for _, res := range storage.reservations {
+		res.LastModified = now.Add(-time.Minute)
+	}
+
+	handler := v3res(storage)
+	r, _ := http.NewRequest(http.MethodGet, "?show=all", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	lastmod := resp.Header.Get("Last-Modified")
+
+	r, _ = http.NewRequest(http.MethodGet, "?show=all", nil)
+	r.Header.Set("If-Modified-Since", lastmod)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected status code 304 got %d", resp.StatusCode)
+	}
+
+	// remove a future reservation, no surviving row is newer
+	err := storage.Delete(78, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "?show=all", nil)
+	r.Header.Set("If-Modified-Since", lastmod)
+	w = httptest.NewRecorder()
+	handler(w, r)
+
+	resp = w.Result()
+
+	out, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Println(string(out))
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 got %d", resp.StatusCode)
+	}
+}
+
+func TestRestOfDay(t *testing.T) {
+	now := time.Date(2021, time.March, 3, 14, 30, 0, 0, time.Local)
+
+	start, end, err := restOfDay(now, 17*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !start.Equal(now) {
+		t.Fatalf("expected start %s got %s", now, start)
+	}
+
+	exp := time.Date(2021, time.March, 3, 17, 0, 0, 0, time.Local)
+	if !end.Equal(exp) {
+		t.Fatalf("expected end %s got %s", exp, end)
+	}
+
+	_, end, err = restOfDay(now, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp = time.Date(2021, time.March, 4, 0, 0, 0, 0, time.Local)
+	if !end.Equal(exp) {
+		t.Fatalf("expected end %s got %s", exp, end)
+	}
+
+	_, _, err = restOfDay(now, 14*time.Hour+30*time.Minute)
+	if err == nil {
+		t.Fatal("expected \"end of day has passed\" error")
+	}
+}
+
+func TestV3APIRestOfDay(t *testing.T) {
+	defer func(eod time.Duration) { endOfDay = eod }(endOfDay)
+
+	tests := []struct {
+		eod    time.Duration
+		status int
+	}{
+		{24 * time.Hour, http.StatusCreated},
+		{0, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		endOfDay = tc.eod
+
+		b := bytes.NewBufferString(`{"resource":"some resource","name":"Some User"}`)
+
+		handler := v3res(&apiStorage{})
+		r, _ := http.NewRequest(http.MethodPost, "restofday", b)
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		out, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fmt.Println(string(out))
+
+		if resp.StatusCode != tc.status {
+			t.Fatalf("expected status code %d got %s", tc.status, resp.Status)
+		}
+	}
+}
+
+// counts adds, numbering each new reservation
+type addCountStorage struct {
+	apiStorage
+	adds int
+}
+
+func (s *addCountStorage) Add(res *Reservation) error {
+	s.adds++
+	res.ID = 100 + s.adds
+	res.LastModified = time.Now()
+	return nil
+}
+
+func TestV3APIPostIdempotencyKey(t *testing.T) {
+	storage := &addCountStorage{}
+	handler := v3res(storage)
+
+	post := func(key string) *http.Response {
+		b := bytes.NewBufferString(`{"resource":"some resource","name":"Some User"}`)
+
+		r, _ := http.NewRequest(http.MethodPost, "", b)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		return w.Result()
+	}
+
+	first := post("5e3c8d1a-key-one")
+	if first.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201 got %s", first.Status)
+	}
+
+	again := post("5e3c8d1a-key-one")
+	if again.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201 got %s", again.Status)
+	}
+
+	if storage.adds != 1 {
+		t.Fatalf("expected 1 add got %d", storage.adds)
+	}
+
+	if first.Header.Get("ID") != "101" || again.Header.Get("ID") != "101" {
+		t.Fatalf("expected ID 101 twice got %s and %s", first.Header.Get("ID"), again.Header.Get("ID"))
+	}
+
+	if again.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatal("expected replayed response")
+	}
+
+	a, _ := ioutil.ReadAll(first.Body)
+	b, _ := ioutil.ReadAll(again.Body)
+	if !bytes.Equal(a, b) {
+		t.Fatalf("expected the same body got %s and %s", a, b)
+	}
+
+	other := post("5e3c8d1a-key-two")
+	if other.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code 201 got %s", other.Status)
+	}
+
+	if storage.adds != 2 || other.Header.Get("ID") != "102" {
+		t.Fatalf("expected a second reservation 102 got %d adds, ID %s", storage.adds, other.Header.Get("ID"))
+	}
+}
+
+func TestV3APIPostIdempotencyKeyFailed(t *testing.T) {
+	storage := &apiStorage{error: errors.New("conflict")}
+	handler := v3res(storage)
+
+	for i := 0; i < 2; i++ {
+		b := bytes.NewBufferString(`{"resource":"some resource","name":"Some User"}`)
+
+		r, _ := http.NewRequest(http.MethodPost, "", b)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "failed-key")
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		resp := w.Result()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected status code 409 got %s", resp.Status)
+		}
+
+		if resp.Header.Get("Idempotent-Replayed") != "" {
+			t.Fatal("failed request replayed")
+		}
+	}
+}
+
+func TestV3APIBulkDelete(t *testing.T) {
+	checkOwner = true
+	admins = map[string]bool{"Admin User": true}
+	defer func() {
+		checkOwner = false
+		admins = map[string]bool{}
+	}()
+
+	tests := []struct {
+		name      string
+		user      string
+		prefix    string
+		unchecked bool
+		status    int
+		removed   int
+		skipped   int
+	}{
+		{name: "not admin", user: "Some User", prefix: "esx0*", status: http.StatusForbidden},
+		{name: "not admin unchecked", user: "Some User", prefix: "esx0*", unchecked: true, status: http.StatusForbidden},
+		{name: "admin", user: "Admin User", prefix: "esx0*", status: http.StatusOK, removed: 2, skipped: 1},
+		{name: "no match", user: "Admin User", prefix: "db", status: http.StatusOK},
+		{name: "bare star", user: "Admin User", prefix: "*", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			storage, _ := fillMemory(true)
+			storage.reservations[1].Resource = "esx01"
+			storage.reservations[2].Resource = "esx02"
+			storage.reservations[7].Resource = "esx03"
+
+			checkOwner = !tc.unchecked
+			defer func() { checkOwner = true }()
+
+			handler := v3res(storage)
+
+			r, _ := http.NewRequest(http.MethodDelete, "?resource="+url.QueryEscape(tc.prefix), nil)
+			r.Header.Set(UserHeader, tc.user)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %s", tc.status, resp.Status)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			reply := struct {
+				Status  string `json:"status"`
+				Removed int    `json:"removed"`
+				Skipped int    `json:"skipped"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Removed != tc.removed || reply.Skipped != tc.skipped {
+				t.Fatalf("expected %d removed %d skipped got %d and %d", tc.removed, tc.skipped, reply.Removed, reply.Skipped)
+			}
+		})
+	}
+}
+
+func TestV3APIStrictBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		error  string
+	}{
+		{
+			name:   "post valid",
+			method: http.MethodPost,
+			body:   `{"resource":"thing","name":"Some User","initials":"SU","email":"","share":true}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "post unknown field",
+			method: http.MethodPost,
+			body:   `{"resource":"thing","name":"Some User","shair":true}`,
+			status: http.StatusBadRequest,
+			error:  `unknown field "shair"`,
+		},
+		{
+			name:   "post wrong type",
+			method: http.MethodPost,
+			body:   `{"resource":"thing","name":"Some User","share":"yes"}`,
+			status: http.StatusBadRequest,
+			error:  `field "share" should be bool not string`,
+		},
+		{
+			name:   "post bad time",
+			method: http.MethodPost,
+			body:   `{"resource":"thing","start":"tomorrow"}`,
+			status: http.StatusBadRequest,
+			error:  `time "tomorrow" malformed`,
+		},
+		{
+			name:   "put valid",
+			method: http.MethodPut,
+			path:   "45",
+			body:   `{"resource":"thing","name":"Some User","loan":true}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "put unknown field",
+			method: http.MethodPut,
+			path:   "45",
+			body:   `{"resource":"thing","nmae":"Some User"}`,
+			status: http.StatusBadRequest,
+			error:  `unknown field "nmae"`,
+		},
+		{
+			name:   "put wrong type",
+			method: http.MethodPut,
+			path:   "45",
+			body:   `{"resource":"thing","id":"45"}`,
+			status: http.StatusBadRequest,
+			error:  `field "id" should be int not string`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := &apiStorage{reservations: []*Reservation{{ID: 45, Resource: "thing"}}}
+			handler := v3res(storage)
+
+			r, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			resp := w.Result()
+
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected status code %d got %s", tc.status, resp.Status)
+			}
+
+			if tc.error == "" {
+				return
+			}
+
+			reply := struct {
+				Status string `json:"status"`
+				Error  string `json:"error"`
+			}{}
+
+			err := json.NewDecoder(resp.Body).Decode(&reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Error != tc.error {
+				t.Fatalf("expected error %s got %s", tc.error, reply.Error)
+			}
+		})
+	}
+}
+
+func TestV3APIConflictDetail(t *testing.T) {
+	storage, _ := fillMemory(true)
+
+	day := time.Date(2030, time.April, 3, 0, 0, 0, 0, time.Local)
+
+	blocker := storage.reservations[5] // 111, resource D
+	blocker.Name = "Some User"
+	blocker.Owner = "some.user@company.com"
+	blocker.Notes = "private"
+	blocker.Start = day.Add(10 * time.Hour)
+	blocker.End = day.Add(12 * time.Hour)
+
+	handler := v3res(storage)
+
+	req := fmt.Sprintf(`{"resource":"resource D","start":"%s","end":"%s"}`, day.Add(11*time.Hour).Format(time.RFC3339), day.Add(13*time.Hour).Format(time.RFC3339))
+
+	r, _ := http.NewRequest(http.MethodPost, "", bytes.NewBufferString(req))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	resp := w.Result()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status code 409 got %s", resp.Status)
+	}
+
+	reply := struct {
+		Status   string       `json:"status"`
+		Error    string       `json:"error"`
+		Conflict *Reservation `json:"conflict"`
+	}{}
+
+	err := json.NewDecoder(resp.Body).Decode(&reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Conflict == nil || reply.Conflict.ID != 111 || reply.Conflict.Name != "Some User" {
+		t.Fatalf("expected conflict with 111 got %+v", reply.Conflict)
+	}
+
+	if !reply.Conflict.Start.Equal(blocker.Start) || !reply.Conflict.End.Equal(blocker.End) {
+		t.Fatalf("expected window %s - %s got %s - %s", blocker.Start, blocker.End, reply.Conflict.Start, reply.Conflict.End)
+	}
+
+	if reply.Conflict.Owner != "" || reply.Conflict.Notes != "" {
+		t.Fatalf("blocker details leaked: %+v", reply.Conflict)
+	}
+
+	if !strings.Contains(reply.Error, "blocked by reservation 111") {
+		t.Fatalf("expected the blocker in the error got \"%s\"", reply.Error)
+	}
 }
@@ -10,18 +10,26 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
 	. "github.com/dbulkow/reservations/api"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
 
 var (
 	canshare bool
+	flexible bool
 	notes    string
+	links    []string
+	tags     []string
 	onloan   bool
+	openEnd  bool
 	dryrun   bool
+	nowstr   string
+	restday  bool
 )
 
 func init() {
@@ -34,11 +42,12 @@ start and end times.  The start time can be implicit:
 
     reserve add <resource> <duration>
 
-Durations can be specified in hours, days or weeks as:
+Durations can be specified in minutes, hours, days or weeks as:
 
     + 5 days
     plus 7 hours
     for 1 week
+    for 90 minutes
 
 The start and end times can be explicit, separated by to or until:
 
@@ -55,9 +64,22 @@ where the time specification for start/end can be:
     05:00pm
     21:00
     2017-04-01 08:00
-    October 15th 09:00 [optional year] (note: does not support 9am)
+    October 15th 9am
     tomorrow 8am
     Thursday noon
+    next Thursday noon (Thursday of the following week)
+
+The rest of today, up to the server's end of day, can be reserved with:
+
+    reserve add <resource> --rest-of-day
+
+A reservation can recur on weekdays, creating one reservation per day:
+
+    weekdays 9am to 5pm for 2 weeks
+    every weekday 08:00 until noon
+
+With --dryrun the parsed times are printed and checked against the
+server's schedule, nothing is reserved.
 
 Synonyms for times are:
 
@@ -68,10 +90,17 @@ Synonyms for times are:
 		RunE: add,
 	}
 
-	addCmd.Flags().BoolVar(&canshare, "share", false, "Can share")
+	addCmd.Flags().BoolVar(&canshare, "share", false, "Can share (--share=false overrides a shared resource)")
+	addCmd.Flags().BoolVar(&flexible, "flexible", false, "End may be shortened for a later booking")
 	addCmd.Flags().StringVar(&notes, "notes", "", "Notes")
+	addCmd.Flags().StringArrayVar(&links, "link", nil, "Link to a ticket or runbook, may be repeated")
+	addCmd.Flags().StringArrayVar(&tags, "tag", nil, "Tag to categorize the reservation (e.g. ci), may be repeated")
 	addCmd.Flags().BoolVar(&onloan, "loan", false, "On Loan")
+	addCmd.Flags().BoolVar(&openEnd, "open-ended", false, "Reserve from now with no end, until ended with 'end'")
 	addCmd.Flags().BoolVarP(&dryrun, "dryrun", "n", false, "Just print out parsed time")
+	addCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "JSON output with --dryrun")
+	addCmd.Flags().BoolVar(&restday, "rest-of-day", false, "Reserve from now until the server's end of day")
+	addCmd.Flags().StringVar(&nowstr, "now", "", "Parse relative to \"YYYY-MM-DD HH:MM\" with --dryrun")
 
 	RootCmd.AddCommand(addCmd)
 }
@@ -83,9 +112,11 @@ func add(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Unable to read config (%v).  Run with 'config' to initialize.", err)
 	}
 
-	service.Path = V3api
+	if onloan && openEnd {
+		return errors.New("--loan and --open-ended are exclusive")
+	}
 
-	if onloan {
+	if onloan || openEnd || restday {
 		if len(args) < 1 {
 			return errors.New("resource not specified")
 		}
@@ -95,99 +126,316 @@ func add(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	now := time.Now()
+
+	if nowstr != "" {
+		if !dryrun {
+			return errors.New("--now only allowed with --dryrun")
+		}
+
+		now, err = parseNow(nowstr)
+		if err != nil {
+			return err
+		}
+	}
+
 	resource := args[0]
-	start := time.Now()
-	end := time.Now()
 
-	if !onloan {
-		start, end, err = ParseRange(time.Now(), args[1:])
+	// an explicit --share=false keeps a resource shared by default private
+	noshare := cmd.Flags().Changed("share") && !canshare
+
+	if restday {
+		res := &Reservation{
+			Resource: resource,
+			Share:    canshare,
+			NoShare:  noshare,
+			Flexible: flexible,
+			Notes:    notes,
+			Links:    links,
+			Tags:     tags,
+			Name:     cfg.Name,
+			Initials: cfg.Abbrev,
+			Owner:    cfg.Mail,
+		}
+
+		id, err := post(V3api+"restofday", res)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added reservation %d\n", id)
+
+		return nil
+	}
+
+	ranges := [][2]time.Time{{now, now}}
+
+	if !onloan && !openEnd {
+		ranges, err = ParseRanges(now, args[1:])
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "parsetime: %v\n", err)
-			if perr, ok := err.(*ParseError); ok {
-				if perr.token == nil {
-					goto done
-				}
-				tokens, _ := tokenize(args[1:])
-				for i, t := range tokens.tokens {
-					if perr.token.count == i+1 {
-						fmt.Printf("[%s] ", t.Val)
-					} else {
-						fmt.Printf("%s ", t.Val)
-					}
-				}
-				fmt.Println()
-			}
-		done:
+			highlightToken(os.Stdout, args[1:], err)
 			os.Exit(1)
 		}
 
 		if dryrun {
-			fmt.Println(start, end)
-			return nil
+			err := printRanges(os.Stdout, ranges, jsonOutput)
+			if err != nil || jsonOutput || nowstr != "" {
+				return err
+			}
 		}
 	}
 
-	res := &Reservation{
-		Resource: resource,
-		Start:    start,
-		End:      end,
-		Loan:     onloan,
-		Share:    canshare,
-		Notes:    notes,
-		Name:     cfg.Name,
-		Initials: cfg.Abbrev,
+	// check availability with the server, skipped for a hypothetical --now
+	if dryrun {
+		for _, r := range ranges {
+			reason, err := validate(&Reservation{
+				Resource:  resource,
+				Start:     r[0],
+				End:       r[1],
+				Loan:      onloan,
+				OpenEnded: openEnd,
+				Share:     canshare,
+				Name:      cfg.Name,
+				Owner:     cfg.Mail,
+			})
+			if err != nil {
+				return err
+			}
+
+			if reason == "" {
+				reason = "available"
+			}
+
+			fmt.Printf("%s: %s\n", r[0].Format("Mon Jan _2 15:04"), reason)
+		}
+
+		return nil
 	}
 
-	data, err := json.Marshal(res)
+	for _, r := range ranges {
+		res := &Reservation{
+			Resource:  resource,
+			Start:     r[0],
+			End:       r[1],
+			Loan:      onloan,
+			OpenEnded: openEnd,
+			Share:     canshare,
+			NoShare:   noshare,
+			Flexible:  flexible,
+			Notes:     notes,
+			Links:     links,
+			Tags:      tags,
+			Name:      cfg.Name,
+			Initials:  cfg.Abbrev,
+			Owner:     cfg.Mail,
+		}
+
+		id, err := post(V3api, res)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added reservation %d\n", id)
+	}
+
+	return nil
+}
+
+func parseNow(s string) (time.Time, error) {
+	now, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
 	if err != nil {
-		return fmt.Errorf("marshal %v", err)
+		now, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return now, fmt.Errorf("now \"%s\" not in \"YYYY-MM-DD HH:MM\" format", s)
+		}
+	}
+
+	return now, nil
+}
+
+// parsed time range as printed by --dryrun --json
+type parsedRange struct {
+	Start           string `json:"start"`
+	End             string `json:"end"`
+	DurationMinutes int    `json:"durationMinutes"`
+}
+
+// print parsed ranges one per line, as JSON objects or for people
+func printRanges(w io.Writer, ranges [][2]time.Time, asJSON bool) error {
+	const datefmt = "Mon Jan _2 15:04 MST 2006"
+
+	enc := json.NewEncoder(w)
+
+	for _, r := range ranges {
+		start, end := r[0], r[1]
+
+		if asJSON {
+			err := enc.Encode(&parsedRange{
+				Start:           start.Format(time.RFC3339),
+				End:             end.Format(time.RFC3339),
+				DurationMinutes: int(end.Sub(start) / time.Minute),
+			})
+			if err != nil {
+				return fmt.Errorf("unable to marshal output %v", err)
+			}
+			continue
+		}
+
+		fmt.Fprintf(w, "%s - %s (%s)\n", start.Format(datefmt), end.Format(datefmt), end.Sub(start))
 	}
 
-	b := bytes.NewReader(data)
+	return nil
+}
+
+// attempts at a POST lost to the network
+const postTries = 2
 
-	r, err := http.NewRequest(http.MethodPost, service.String(), b)
+func post(path string, res *Reservation) (int, error) {
+	service.Path = path
+
+	data, err := json.Marshal(res)
 	if err != nil {
-		return fmt.Errorf("new request: %v", err)
+		return 0, fmt.Errorf("marshal %v", err)
 	}
-	r.Header.Set("Content-Type", "application/json")
 
-	resp, err := client.Do(r)
+	// the server answers a retry carrying the same key with the first
+	// response, so a lost reply doesn't reserve twice
+	key, err := uuid.NewRandom()
 	if err != nil {
-		return fmt.Errorf("http: %v", err)
+		return 0, fmt.Errorf("idempotency key: %v", err)
+	}
+
+	var resp *http.Response
+
+	for try := 0; try < postTries; try++ {
+		r, err := http.NewRequest(http.MethodPost, service.String(), bytes.NewReader(data))
+		if err != nil {
+			return 0, fmt.Errorf("new request: %v", err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", key.String())
+
+		resp, err = client.Do(r)
+		if err == nil {
+			break
+		}
+		if try == postTries-1 {
+			return 0, fmt.Errorf("http: %v", err)
+		}
 	}
 	if resp == nil {
-		return fmt.Errorf("empty response")
+		return 0, fmt.Errorf("empty response")
 	}
 	defer func() {
 		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
 		resp.Body.Close()
 	}()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
-		return fmt.Errorf("response status %s", resp.Status)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusBadRequest {
+		return 0, fmt.Errorf("response status %s", resp.Status)
 	}
 
 	rpy := struct {
-		Status   string `json:"status"`
-		Error    string `json:"error"`
-		Location string `json:"location"`
-		ID       *int   `json:"id"`
+		Status   string       `json:"status"`
+		Error    string       `json:"error"`
+		Conflict *Reservation `json:"conflict"`
+		Location string       `json:"location"`
+		ID       *int         `json:"id"`
 	}{}
 
 	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
 	if err != nil {
-		return fmt.Errorf("decode %v", err)
+		return 0, fmt.Errorf("decode %v", err)
 	}
 
 	if rpy.Status != "Success" {
-		return fmt.Errorf("error: %s", rpy.Error)
+		return 0, fmt.Errorf("error: %s", refusal(rpy.Error, rpy.Conflict))
 	}
 
 	if rpy.ID == nil {
-		return errors.New("empty reply")
+		return 0, errors.New("empty reply")
 	}
 
-	fmt.Printf("Added reservation %d\n", *rpy.ID)
+	return *rpy.ID, nil
+}
 
-	return nil
+// ask the server whether res would be added, the reason it would be
+// refused or empty
+func validate(res *Reservation) (string, error) {
+	service.Path = V3api
+
+	u, err := url.Parse(service.String())
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("validate", "true")
+	u.RawQuery = q.Encode()
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		return "", fmt.Errorf("marshal %v", err)
+	}
+
+	r, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("new request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return "", fmt.Errorf("http: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusBadRequest {
+		return "", fmt.Errorf("response status %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status   string       `json:"status"`
+		Error    string       `json:"error"`
+		Conflict *Reservation `json:"conflict"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return "", fmt.Errorf("decode %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return refusal(rpy.Error, rpy.Conflict), nil
+	}
+
+	return "", nil
+}
+
+// the reason a reservation was refused, naming the reservation in the
+// way when the server reports one
+func refusal(reason string, conflict *Reservation) string {
+	if conflict == nil {
+		return reason
+	}
+
+	const day = "Jan 2 15:04"
+
+	start := conflict.Start.In(time.Local)
+	end := conflict.End.In(time.Local)
+
+	var when string
+	switch {
+	case conflict.Loan:
+		when = "on loan from " + start.Format(day)
+	case start.YearDay() == end.YearDay() && start.Year() == end.Year():
+		when = start.Format(day) + "-" + end.Format("15:04")
+	default:
+		when = start.Format(day) + "-" + end.Format(day)
+	}
+
+	return fmt.Sprintf("conflicts with reservation %d (%s, %s)", conflict.ID, conflict.Name, when)
 }
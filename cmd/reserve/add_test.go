@@ -0,0 +1,181 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestAddDryrunNow(t *testing.T) {
+	tests := []struct {
+		now    string
+		args   string
+		output string
+	}{
+		{
+			now:    "2017-04-01 23:47",
+			args:   "+1day",
+			output: "Sat Apr  1 23:47 EDT 2017 - Mon Apr  3 00:00 EDT 2017 (24h13m0s)\n",
+		},
+		{
+			now:    "2017-04-03 08:00",
+			args:   "+1day",
+			output: "Mon Apr  3 08:00 EDT 2017 - Tue Apr  4 08:00 EDT 2017 (24h0m0s)\n",
+		},
+		{
+			now:  "2017-04-06T08:00:00-04:00",
+			args: "weekdays 9am to 5pm",
+			output: "Thu Apr  6 09:00 EDT 2017 - Thu Apr  6 17:00 EDT 2017 (8h0m0s)\n" +
+				"Fri Apr  7 09:00 EDT 2017 - Fri Apr  7 17:00 EDT 2017 (8h0m0s)\n" +
+				"Mon Apr 10 09:00 EDT 2017 - Mon Apr 10 17:00 EDT 2017 (8h0m0s)\n" +
+				"Tue Apr 11 09:00 EDT 2017 - Tue Apr 11 17:00 EDT 2017 (8h0m0s)\n" +
+				"Wed Apr 12 09:00 EDT 2017 - Wed Apr 12 17:00 EDT 2017 (8h0m0s)\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.now, func(t *testing.T) {
+			now, err := parseNow(tc.now)
+			if err != nil {
+				t.Fatalf("parse now: %v", err)
+			}
+
+			ranges, err := ParseRanges(now, strings.Split(tc.args, " "))
+			if err != nil {
+				t.Fatalf("parse ranges: %v", err)
+			}
+
+			var out bytes.Buffer
+
+			err = printRanges(&out, ranges, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != tc.output {
+				t.Fatalf("expected \"%s\" got \"%s\"", tc.output, out.String())
+			}
+		})
+	}
+
+	if _, err := parseNow("tomorrow"); err == nil {
+		t.Fatalf("expected error for invalid now")
+	}
+}
+
+func TestAddDryrunJSON(t *testing.T) {
+	now, err := parseNow("2017-04-01 23:47")
+	if err != nil {
+		t.Fatalf("parse now: %v", err)
+	}
+
+	ranges, err := ParseRanges(now, strings.Split("tomorrow 8am for 3 hours", " "))
+	if err != nil {
+		t.Fatalf("parse ranges: %v", err)
+	}
+
+	var out bytes.Buffer
+
+	err = printRanges(&out, ranges, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"start":"2017-04-02T08:00:00-04:00","end":"2017-04-02T11:00:00-04:00","durationMinutes":180}` + "\n"
+	if out.String() != exp {
+		t.Fatalf("expected \"%s\" got \"%s\"", exp, out.String())
+	}
+}
+
+func TestAddValidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("validate") != "true" {
+			t.Errorf("expected validate query got \"%s\"", r.URL.RawQuery)
+		}
+
+		var res Reservation
+		json.NewDecoder(r.Body).Decode(&res)
+
+		if res.Resource == "busy" {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status": "Error",
+				"error":  "reservation range conflict",
+			})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "Success",
+			"result": "would succeed",
+		})
+	}))
+	defer srv.Close()
+
+	saved := service
+	defer func() { service = saved }()
+	service, _ = url.Parse(srv.URL)
+
+	now := time.Now()
+
+	reason, err := validate(&Reservation{Resource: "free", Start: now, End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "" {
+		t.Fatalf("expected available got \"%s\"", reason)
+	}
+
+	reason, err = validate(&Reservation{Resource: "busy", Start: now, End: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "reservation range conflict" {
+		t.Fatalf("expected conflict got \"%s\"", reason)
+	}
+}
+
+func TestAddRefusal(t *testing.T) {
+	day := time.Date(2021, time.April, 3, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name     string
+		conflict *Reservation
+		exp      string
+	}{
+		{name: "no detail", exp: "reservation range conflict"},
+		{
+			name:     "same day",
+			conflict: &Reservation{ID: 78, Name: "Some User", Start: day.Add(10 * time.Hour), End: day.Add(12 * time.Hour)},
+			exp:      "conflicts with reservation 78 (Some User, Apr 3 10:00-12:00)",
+		},
+		{
+			name:     "overnight",
+			conflict: &Reservation{ID: 79, Name: "Some User", Start: day.Add(22 * time.Hour), End: day.Add(30 * time.Hour)},
+			exp:      "conflicts with reservation 79 (Some User, Apr 3 22:00-Apr 4 06:00)",
+		},
+		{
+			name:     "loan",
+			conflict: &Reservation{ID: 80, Name: "Another User", Start: day.Add(9 * time.Hour), End: day.Add(9 * time.Hour), Loan: true},
+			exp:      "conflicts with reservation 80 (Another User, on loan from Apr 3 09:00)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := refusal("reservation range conflict", tc.conflict)
+			if got != tc.exp {
+				t.Fatalf("expected \"%s\" got \"%s\"", tc.exp, got)
+			}
+		})
+	}
+}
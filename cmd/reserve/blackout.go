@@ -0,0 +1,272 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+var blackoutReason string
+
+func init() {
+	blackoutCmd := &cobra.Command{
+		Use:   "blackout",
+		Short: "Manage resource blackout windows",
+		Long: `Manage resource blackout windows
+
+A blackout is a recurring window during which a resource can not be
+reserved, such as nightly backups or weekly maintenance. Times are in
+the server's local time. A window ending before it starts runs past
+midnight.
+`,
+	}
+
+	blackoutListCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List blackout windows",
+		Long:    "List blackout windows",
+		RunE:    blackoutList,
+	}
+
+	blackoutAddCmd := &cobra.Command{
+		Use:     "add <resource> <days> <start HH:MM> <end HH:MM>",
+		Aliases: []string{"new", "create"},
+		Short:   "Add a blackout window",
+		Long: `Add a blackout window
+
+Days can be daily, weekdays, weekends or a comma separated list of
+day names:
+
+    reserve blackout add lin01 daily 01:00 03:00
+    reserve blackout add esx02 sat,sun 22:00 06:00 --reason "maintenance"
+`,
+		RunE: blackoutAdd,
+	}
+
+	blackoutAddCmd.Flags().StringVar(&blackoutReason, "reason", "", "Reason for blackout")
+
+	blackoutDeleteCmd := &cobra.Command{
+		Use:     "delete <blackout id number>",
+		Aliases: []string{"rm", "del"},
+		Short:   "Delete a blackout window",
+		Long:    "Delete a blackout window",
+		RunE:    blackoutDelete,
+	}
+
+	blackoutCmd.AddCommand(blackoutListCmd)
+	blackoutCmd.AddCommand(blackoutAddCmd)
+	blackoutCmd.AddCommand(blackoutDeleteCmd)
+
+	RootCmd.AddCommand(blackoutCmd)
+}
+
+func parseDays(spec string) ([]time.Weekday, error) {
+	switch strings.ToLower(spec) {
+	case "daily":
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, nil
+	case "weekdays":
+		return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+	case "weekends":
+		return []time.Weekday{time.Saturday, time.Sunday}, nil
+	}
+
+	days := make([]time.Weekday, 0)
+
+	for _, name := range strings.Split(strings.ToLower(spec), ",") {
+		day, ok := Days[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown day \"%s\"", name)
+		}
+		days = append(days, time.Weekday(day))
+	}
+
+	return days, nil
+}
+
+func blackoutList(cmd *cobra.Command, args []string) error {
+	service.Path = V3blackout
+
+	r, err := http.NewRequest(http.MethodGet, service.String(), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status    string      `json:"status"`
+		Error     string      `json:"error"`
+		Blackouts []*Blackout `json:"blackouts"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return errors.New(rpy.Error)
+	}
+
+	for _, b := range rpy.Blackouts {
+		if b.Reason != "" {
+			fmt.Printf("%s (%s)\n", b, b.Reason)
+		} else {
+			fmt.Println(b)
+		}
+	}
+
+	return nil
+}
+
+func blackoutAdd(cmd *cobra.Command, args []string) error {
+	if len(args) < 4 {
+		return errors.New("resource, days, start and/or end not specified")
+	}
+
+	days, err := parseDays(args[1])
+	if err != nil {
+		return err
+	}
+
+	for _, hhmm := range args[2:4] {
+		if _, err := time.Parse("15:04", hhmm); err != nil {
+			return fmt.Errorf("time \"%s\" not in HH:MM format", hhmm)
+		}
+	}
+
+	req := &Blackout{
+		Resource: args[0],
+		Days:     days,
+		Start:    args[2],
+		End:      args[3],
+		Reason:   blackoutReason,
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal %v", err)
+	}
+
+	b := bytes.NewReader(data)
+
+	service.Path = V3blackout
+
+	r, err := http.NewRequest(http.MethodPost, service.String(), b)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	setUser(cmd, r)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusBadRequest && resp.StatusCode != http.StatusForbidden {
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		ID     *int   `json:"id"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return fmt.Errorf("error: %s", rpy.Error)
+	}
+
+	if rpy.ID == nil {
+		return errors.New("empty reply")
+	}
+
+	fmt.Printf("Added blackout %d\n", *rpy.ID)
+
+	return nil
+}
+
+func blackoutDelete(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("blackout id not specified")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	service.Path = fmt.Sprintf("%s%d", V3blackout, id)
+
+	r, err := http.NewRequest(http.MethodDelete, service.String(), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	setUser(cmd, r)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("blackout %d not found", id)
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		return errors.New("blackout changes restricted to admins")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+
+	return nil
+}
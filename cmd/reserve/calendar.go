@@ -0,0 +1,156 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	calWeek     bool
+	calMonth    bool
+	calResource string
+)
+
+func init() {
+	calendarCmd := &cobra.Command{
+		Use:     "calendar",
+		Aliases: []string{"cal"},
+		Short:   "Show reservations on a week or month calendar",
+		Long: `Show reservations on a week or month calendar
+
+Each day of the current week, or month, is marked with the number of
+reservations occupying it, or '.' when the day is free.  Loans occupy
+every day from their start.
+`,
+		RunE: calendar,
+	}
+
+	calendarCmd.Flags().BoolVar(&calWeek, "week", false, "Show the current week (default)")
+	calendarCmd.Flags().BoolVar(&calMonth, "month", false, "Show the current month")
+	calendarCmd.Flags().StringVar(&calResource, "resource", "", "Show reservations for this resource only")
+
+	RootCmd.AddCommand(calendarCmd)
+}
+
+func calendar(cmd *cobra.Command, args []string) error {
+	if calWeek && calMonth {
+		return errors.New("--week and --month are exclusive")
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	first := today.AddDate(0, 0, -int(today.Weekday()))
+	last := first.AddDate(0, 0, 6)
+	title := fmt.Sprintf("Week of %s", first.Format("Jan _2 2006"))
+
+	if calMonth {
+		first = today.AddDate(0, 0, 1-today.Day())
+		last = first.AddDate(0, 1, -1)
+		title = first.Format("January 2006")
+	}
+
+	service.Path = V3api
+
+	u, err := url.Parse(service.String())
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("show", "all")
+	q.Set("from", first.Format(time.RFC3339))
+	q.Set("to", last.AddDate(0, 0, 1).Format(time.RFC3339))
+	if calResource != "" {
+		q.Set("resource", calResource)
+	}
+	q.Set("limit", strconv.Itoa(50))
+	q.Set("start", "0")
+	u.RawQuery = q.Encode()
+
+	res, err := fetchAll(u)
+	if err != nil {
+		return err
+	}
+
+	if calResource != "" {
+		title = calResource + " - " + title
+	}
+
+	fmt.Println(title)
+	fmt.Println()
+
+	calendarGrid(os.Stdout, res, first, last)
+
+	return nil
+}
+
+// lay out the days first through last, a week to a row starting on
+// Sunday, each marked with the number of reservations occupying it -
+// days of the padding weeks outside the range are left blank
+func calendarGrid(w io.Writer, res []*Reservation, first, last time.Time) {
+	sched := make([]*Reservation, len(res))
+	copy(sched, res)
+	sort.Sort(ByDate(sched))
+
+	loc := first.Location()
+	first = time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	last = time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, loc)
+
+	days := []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+	cells := make([]string, len(days))
+	for i, d := range days {
+		cells[i] = fmt.Sprintf("%-6s", d)
+	}
+	fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, " "), " "))
+
+	day := first.AddDate(0, 0, -int(first.Weekday()))
+
+	for !day.After(last) {
+		for i := range cells {
+			cells[i] = strings.Repeat(" ", 6)
+
+			if !day.Before(first) && !day.After(last) {
+				cells[i] = fmt.Sprintf("%2d %-3s", day.Day(), occupancy(sched, day, day.AddDate(0, 0, 1)))
+			}
+
+			day = day.AddDate(0, 0, 1)
+		}
+
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, " "), " "))
+	}
+}
+
+// the number of reservations in sched, sorted by start, intersecting
+// [from,to), '.' when none do
+func occupancy(sched []*Reservation, from, to time.Time) string {
+	count := 0
+
+	for _, r := range sched {
+		if !r.Start.Before(to) {
+			break
+		}
+
+		if r.Loan || r.End.After(from) {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return "."
+	}
+
+	return strconv.Itoa(count)
+}
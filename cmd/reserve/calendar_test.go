@@ -0,0 +1,66 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestCalendarGrid(t *testing.T) {
+	day := func(d, h int) time.Time {
+		return time.Date(2017, time.April, d, h, 0, 0, 0, time.UTC)
+	}
+
+	res := []*Reservation{
+		&Reservation{ID: 1, Start: day(7, 16), End: day(10, 9)}, // over the weekend
+		&Reservation{ID: 2, Start: day(8, 10), End: day(8, 12)},
+		&Reservation{ID: 3, Start: day(3, 9), End: day(3, 17)},
+		&Reservation{ID: 4, Start: day(28, 8), Loan: true},
+		&Reservation{ID: 5, Start: day(1, 8), End: day(2, 0)},
+	}
+
+	tests := []struct {
+		name  string
+		first time.Time
+		last  time.Time
+		exp   string
+	}{
+		{
+			name:  "week",
+			first: day(2, 0),
+			last:  day(8, 0),
+			exp: `Sun    Mon    Tue    Wed    Thu    Fri    Sat
+ 2 .    3 1    4 .    5 .    6 .    7 1    8 2
+`,
+		},
+		{
+			name:  "month",
+			first: day(1, 0),
+			last:  day(30, 0),
+			exp: `Sun    Mon    Tue    Wed    Thu    Fri    Sat
+                                           1 1
+ 2 .    3 1    4 .    5 .    6 .    7 1    8 2
+ 9 1   10 1   11 .   12 .   13 .   14 .   15 .
+16 .   17 .   18 .   19 .   20 .   21 .   22 .
+23 .   24 .   25 .   26 .   27 .   28 1   29 1
+30 1
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b bytes.Buffer
+
+			calendarGrid(&b, res, tc.first, tc.last)
+
+			if b.String() != tc.exp {
+				t.Fatalf("expected\n%s\ngot\n%s", tc.exp, b.String())
+			}
+		})
+	}
+}
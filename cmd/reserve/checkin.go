@@ -0,0 +1,89 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	checkinCmd := &cobra.Command{
+		Use:     "checkin <resource id number>",
+		Aliases: []string{"check-in"},
+		Short:   "Report an active reservation in use",
+		Long: `Report an active reservation in use
+
+Servers configured to sweep for no-shows end reservations that are not
+checked in within a grace period of their start.
+`,
+		RunE: checkin,
+	}
+
+	RootCmd.AddCommand(checkinCmd)
+}
+
+func checkin(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("resource id not specified")
+	}
+
+	resid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	service.Path = fmt.Sprintf("%s%d/checkin", V3api, resid)
+
+	r, err := http.NewRequest(http.MethodPost, service.String(), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	setUser(cmd, r)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("reservation %d not found", resid)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return fmt.Errorf("error: %s", rpy.Error)
+	}
+
+	fmt.Printf("Checked in reservation %d\n", resid)
+
+	return nil
+}
@@ -20,15 +20,38 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	showConfig bool
+	cfgName    string
+	cfgMail    string
+	cfgAbbrev  string
+)
+
 func init() {
 	configCmd := &cobra.Command{
 		Use:     "config",
 		Aliases: []string{"cfg"},
 		Short:   "Write or display configuration fields",
-		Long:    "Write or display configuration fields",
-		RunE:    config,
+		Long: `Write or display configuration fields
+
+Without flags this prompts for each field and writes the config file.
+With --show the current fields are printed without prompting, failing
+when no config file exists.
+
+Fields given with --name, --mail or --abbrev are written without
+prompting, the rest kept from the current config, for provisioning:
+
+    reserve config --name "First Last" --mail first.last@company.com
+`,
+		RunE: config,
 	}
 
+	configCmd.Flags().BoolVar(&showConfig, "show", false, "Display configuration without prompting")
+	configCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "JSON output with --show")
+	configCmd.Flags().StringVar(&cfgName, "name", "", "Full name, written without prompting")
+	configCmd.Flags().StringVar(&cfgMail, "mail", "", "Email address, written without prompting")
+	configCmd.Flags().StringVar(&cfgAbbrev, "abbrev", "", "Abbreviation, written without prompting")
+
 	RootCmd.AddCommand(configCmd)
 }
 
@@ -65,23 +88,85 @@ func getConfig(conffile string) (*Config, error) {
 	return cfg, nil
 }
 
-func config(cmd *cobra.Command, args []string) error {
-	conffile := cmd.Flag("config").Value.String()
+// identify the user to the server for ownership checks, requests are
+// sent anonymously when no config exists
+func setUser(cmd *cobra.Command, r *http.Request) {
+	cfg, err := getConfig(cmd.Flag("config").Value.String())
+	if err != nil || cfg.Name == "" {
+		return
+	}
 
-	validEmail := func(email string) bool {
-		re := regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
-		return re.MatchString(email)
+	r.Header.Set(UserHeader, cfg.Name)
+
+	if cfg.Mail != "" {
+		r.Header.Set(OwnerHeader, cfg.Mail)
 	}
+}
 
-	genAbbrev := func(name string) string {
-		parts := strings.Split(strings.ToUpper(name), " ")
-		var x string
-		for _, p := range parts {
-			x = x + string(p[0])
-		}
-		return x
+var emailAddress = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`)
+
+func validEmail(email string) bool {
+	return emailAddress.MatchString(email)
+}
+
+// initials of the name, "First Last" gives "FL"
+func genAbbrev(name string) string {
+	var x string
+	for _, p := range strings.Fields(strings.ToUpper(name)) {
+		x = x + string(p[0])
+	}
+	return x
+}
+
+// check the fields before the config is written, the abbreviation is
+// kept in upper case
+func checkConfig(cfg *Config) error {
+	if cfg.Name == "" {
+		return errors.New("Name not entered")
+	}
+
+	if cfg.Mail == "" {
+		return errors.New("Email address not valid")
+	}
+
+	if validEmail(cfg.Mail) == false {
+		return errors.New("Email address does not appear to be valid")
+	}
+
+	if len(cfg.Abbrev) < 1 || 3 < len(cfg.Abbrev) {
+		return errors.New("Abbreviation needs to be two or three characters")
+	}
+
+	cfg.Abbrev = strings.ToUpper(cfg.Abbrev)
+
+	return nil
+}
+
+// apply the fields given as flags over the current config, as though
+// typed at the prompts
+func setConfig(cfg *Config, name, mail, abbrev string) error {
+	if name != "" {
+		cfg.Name = strings.TrimSpace(name)
+	}
+
+	if mail != "" {
+		cfg.Mail = strings.TrimSpace(mail)
+	}
+
+	if abbrev != "" {
+		cfg.Abbrev = strings.TrimSpace(abbrev)
+	}
+
+	if cfg.Abbrev == "" {
+		cfg.Abbrev = genAbbrev(cfg.Name)
 	}
 
+	return checkConfig(cfg)
+}
+
+func config(cmd *cobra.Command, args []string) error {
+	conffile := cmd.Flag("config").Value.String()
+
 	var cfg Config
 
 	exist := false
@@ -99,9 +184,47 @@ func config(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	if jsonOutput && !showConfig {
+		return errors.New("--json only allowed with --show")
+	}
+
+	setters := cfgName != "" || cfgMail != "" || cfgAbbrev != ""
+
+	if showConfig && setters {
+		return errors.New("--show not allowed with --name, --mail or --abbrev")
+	}
+
+	if showConfig {
+		if !exist {
+			return fmt.Errorf("No config at %s.  Run with 'config' to initialize.", conffile)
+		}
+
+		if jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "    ")
+			return enc.Encode(&cfg)
+		}
+
+		fmt.Printf("Name:          %s\n", cfg.Name)
+		fmt.Printf("Email Address: %s\n", cfg.Mail)
+		fmt.Printf("Abbreviation:  %s\n", cfg.Abbrev)
+
+		return nil
+	}
 
 	oldname := cfg.Name
+	oldmail := cfg.Mail
+
+	if setters {
+		err := setConfig(&cfg, cfgName, cfgMail, cfgAbbrev)
+		if err != nil {
+			return err
+		}
+
+		return writeConfig(conffile, &cfg, oldname, oldmail)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
 
 	if cfg.Name == "" {
 		fmt.Print("Full Name     (First Last): ")
@@ -118,8 +241,6 @@ func config(cmd *cobra.Command, args []string) error {
 		return errors.New("Name not entered")
 	}
 
-	oldmail := cfg.Mail
-
 	if cfg.Mail == "" {
 		fmt.Print("Email Address: ")
 	} else {
@@ -145,18 +266,17 @@ func config(cmd *cobra.Command, args []string) error {
 		cfg.Abbrev = text
 	}
 
-	if validEmail(cfg.Mail) == false {
-		return errors.New("Email address does not appear to be valid")
-	}
-
-	if len(cfg.Abbrev) < 1 || 3 < len(cfg.Abbrev) {
-		fmt.Println(cfg.Abbrev)
-		return errors.New("Abbreviation needs to be two or three characters")
+	if err := checkConfig(&cfg); err != nil {
+		return err
 	}
 
-	cfg.Abbrev = strings.ToUpper(cfg.Abbrev)
+	return writeConfig(conffile, &cfg, oldname, oldmail)
+}
 
-	b, err = json.MarshalIndent(&cfg, "", "    ")
+// write the config, registering the address with the server when the
+// name or address changed
+func writeConfig(conffile string, cfg *Config, oldname, oldmail string) error {
+	b, err := json.MarshalIndent(cfg, "", "    ")
 	if err != nil {
 		return fmt.Errorf("Unable to marshal config data %v", err)
 	}
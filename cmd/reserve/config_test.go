@@ -0,0 +1,90 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSetConfig(t *testing.T) {
+	current := Config{Name: "Some User", Mail: "some.user@company.com", Abbrev: "SU"}
+
+	tests := []struct {
+		name   string
+		cfg    Config
+		flags  [3]string
+		expect Config
+		error  string
+	}{
+		{
+			name:   "all fields",
+			flags:  [3]string{"First Last", "first.last@company.com", "fl"},
+			expect: Config{Name: "First Last", Mail: "first.last@company.com", Abbrev: "FL"},
+		},
+		{
+			name:   "generated abbreviation",
+			flags:  [3]string{" Anne  Marie Jones ", "amj@company.com", ""},
+			expect: Config{Name: "Anne  Marie Jones", Mail: "amj@company.com", Abbrev: "AMJ"},
+		},
+		{
+			name:   "abbreviation only",
+			cfg:    current,
+			flags:  [3]string{"", "", "sux"},
+			expect: Config{Name: "Some User", Mail: "some.user@company.com", Abbrev: "SUX"},
+		},
+		{
+			name:   "mail only",
+			cfg:    current,
+			flags:  [3]string{"", "su@company.com", ""},
+			expect: Config{Name: "Some User", Mail: "su@company.com", Abbrev: "SU"},
+		},
+		{
+			name:  "missing name",
+			flags: [3]string{"", "first.last@company.com", ""},
+			error: "Name not entered",
+		},
+		{
+			name:  "missing mail",
+			flags: [3]string{"First Last", "", ""},
+			error: "Email address not valid",
+		},
+		{
+			name:  "bad mail",
+			flags: [3]string{"First Last", "first.last at company", ""},
+			error: "Email address does not appear to be valid",
+		},
+		{
+			name:  "long abbreviation",
+			cfg:   current,
+			flags: [3]string{"", "", "ABCD"},
+			error: "Abbreviation needs to be two or three characters",
+		},
+		{
+			name:  "long generated abbreviation",
+			flags: [3]string{"One Two Three Four", "four@company.com", ""},
+			error: "Abbreviation needs to be two or three characters",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+
+			err := setConfig(&cfg, tc.flags[0], tc.flags[1], tc.flags[2])
+			if err != nil {
+				if tc.error != err.Error() {
+					t.Fatalf("Error exp \"%s\" got \"%s\"", tc.error, err.Error())
+				}
+				return
+			}
+
+			if tc.error != "" {
+				t.Fatalf("Error exp \"%s\" got none", tc.error)
+			}
+
+			if cfg != tc.expect {
+				t.Fatalf("expected %+v got %+v", tc.expect, cfg)
+			}
+		})
+	}
+}
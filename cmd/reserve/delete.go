@@ -98,7 +98,7 @@ func delete(cmd *cobra.Command, args []string) error {
 	if res.Loan {
 		fmt.Printf("\n%d %s %s loan\n", res.ID, res.Resource, res.Name)
 	} else {
-		fmt.Printf("\n%d %s %s %s %s\n", res.ID, res.Resource, res.Name, res.Start.Local().Format(datefmt), res.End.Local().Format(datefmt))
+		fmt.Printf("\n%d %s %s %s %s\n", res.ID, res.Resource, res.Name, res.Start.Local().Format(datefmt), endTime(res, datefmt))
 	}
 
 	if force == false {
@@ -115,6 +115,7 @@ func delete(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("new request: %v", err)
 	}
+	setUser(cmd, r)
 
 	resp, err = client.Do(r)
 	if err != nil {
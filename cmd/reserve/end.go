@@ -20,6 +20,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// pick one of several current reservations of a shared resource
+var (
+	currentName string
+	currentID   int
+)
+
 func init() {
 	endCmd := &cobra.Command{
 		Use:     "end <resource>",
@@ -30,10 +36,50 @@ func init() {
 	}
 
 	endCmd.Flags().BoolVarP(&force, "force", "f", false, "Force remove, don't prompt")
+	endCmd.Flags().StringVar(&currentName, "name", "", "End the current reservation held by name")
+	endCmd.Flags().IntVar(&currentID, "id", 0, "End the current reservation with this ID")
 
 	RootCmd.AddCommand(endCmd)
 }
 
+// choose the current reservation to act on, a resource shared by several
+// needs name or id to tell them apart - the candidates are listed to w
+// when it can't be decided
+func pickCurrent(w io.Writer, res []*Reservation, name string, id int) (*Reservation, error) {
+	matches := make([]*Reservation, 0, len(res))
+
+	for _, r := range res {
+		if name != "" && r.Name != name {
+			continue
+		}
+		if id != 0 && r.ID != id {
+			continue
+		}
+		matches = append(matches, r)
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, errors.New("no matching reservations")
+	case 1:
+		return matches[0], nil
+	}
+
+	datefmt := "Jan _2 15:04 2006"
+	fmt.Fprintln(w, "Current reservations:")
+	fmt.Fprintln(w)
+	for _, r := range matches {
+		if r.Loan {
+			fmt.Fprintf(w, "%d %s %s loan\n", r.ID, r.Resource, r.Name)
+		} else {
+			fmt.Fprintf(w, "%d %s %s %s %s\n", r.ID, r.Resource, r.Name, r.Start.Local().Format(datefmt), endTime(r, datefmt))
+		}
+	}
+	fmt.Fprintln(w)
+
+	return nil, errors.New("multiple current reservations, choose one with --name or --id")
+}
+
 func end(cmd *cobra.Command, args []string) error {
 	if len(args) < 1 {
 		return errors.New("resource name not specified")
@@ -121,18 +167,17 @@ func end(cmd *cobra.Command, args []string) error {
 
 	// if we found a reservation, delete it
 
-	if len(rpy.Reservations) < 1 {
-		return errors.New("no matching reservations")
+	res, err := pickCurrent(os.Stdout, rpy.Reservations, currentName, currentID)
+	if err != nil {
+		return err
 	}
 
-	res := rpy.Reservations[0]
-
 	datefmt := "Jan _2 15:04 2006"
 	fmt.Println("End the following reservation:")
 	if res.Loan {
 		fmt.Printf("\n%d %s %s loan\n", res.ID, res.Resource, res.Name)
 	} else {
-		fmt.Printf("\n%d %s %s %s %s\n", res.ID, res.Resource, res.Name, res.Start.Local().Format(datefmt), res.End.Local().Format(datefmt))
+		fmt.Printf("\n%d %s %s %s %s\n", res.ID, res.Resource, res.Name, res.Start.Local().Format(datefmt), endTime(res, datefmt))
 	}
 
 	if force == false {
@@ -155,6 +200,7 @@ func end(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("new request: %v", err)
 	}
 	r.Header.Set("If-Unmodified-Since", resp.Header.Get("Last-Modified"))
+	setUser(cmd, r)
 
 	if false {
 		in, err := httputil.DumpRequest(r, false)
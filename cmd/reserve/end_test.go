@@ -0,0 +1,65 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestPickCurrent(t *testing.T) {
+	now := time.Now()
+
+	res := []*Reservation{
+		&Reservation{ID: 1, Resource: "resource A", Start: now, End: now.Add(time.Hour), Name: "Some User", Share: true},
+		&Reservation{ID: 2, Resource: "resource A", Start: now, End: now.Add(time.Hour), Name: "Another User", Share: true},
+	}
+
+	tests := []struct {
+		name  string
+		res   []*Reservation
+		who   string
+		id    int
+		want  int
+		error bool
+	}{
+		{name: "single", res: res[:1], want: 1},
+		{name: "none", res: []*Reservation{}, error: true},
+		{name: "ambiguous", res: res, error: true},
+		{name: "by name", res: res, who: "Another User", want: 2},
+		{name: "by id", res: res, id: 1, want: 1},
+		{name: "no match", res: res, who: "Third User", error: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			r, err := pickCurrent(&out, tc.res, tc.who, tc.id)
+			if tc.error {
+				if err == nil {
+					t.Fatalf("expected error got reservation %d", r.ID)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.ID != tc.want {
+				t.Fatalf("expected reservation %d got %d", tc.want, r.ID)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	pickCurrent(&out, res, "", 0)
+
+	if !bytes.Contains(out.Bytes(), []byte("1 resource A Some User")) || !bytes.Contains(out.Bytes(), []byte("2 resource A Another User")) {
+		t.Fatalf("expected candidates listed got \"%s\"", out.String())
+	}
+}
@@ -0,0 +1,250 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportResource string
+	exportMine     bool
+	exportFormat   string
+)
+
+func init() {
+	exportCmd := &cobra.Command{
+		Use:   "export <file>",
+		Short: "Save reservations to a file",
+		Long: `Save reservations to a file
+
+All reservations, history included, are fetched from the server and
+written to the file one JSON reservation per line (jsonl) or as a JSON
+array (json), suitable for re-import.
+`,
+		RunE: export,
+	}
+
+	exportCmd.Flags().StringVar(&exportResource, "resource", "", "Export reservations for this resource only")
+	exportCmd.Flags().BoolVarP(&exportMine, "mine", "m", false, "Export your reservations only")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "jsonl", "Output format [jsonl, json]")
+
+	RootCmd.AddCommand(exportCmd)
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("export file not specified")
+	}
+
+	if exportFormat != "jsonl" && exportFormat != "json" {
+		return fmt.Errorf("unknown format \"%s\"", exportFormat)
+	}
+
+	conffile := cmd.Flag("config").Value.String()
+	cfg, err := getConfig(conffile)
+	if err != nil {
+		return fmt.Errorf("Unable to read config (%v).  Run with 'config' to initialize.", err)
+	}
+
+	service.Path = V3api
+
+	u, err := url.Parse(service.String())
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("show", "all")
+	q.Set("limit", strconv.Itoa(50))
+	q.Set("start", "0")
+	u.RawQuery = q.Encode()
+
+	all, err := fetchAll(u)
+	if err != nil {
+		return err
+	}
+
+	res := make([]*Reservation, 0, len(all))
+
+	for _, r := range all {
+		if exportResource != "" && r.Resource != exportResource {
+			continue
+		}
+
+		if exportMine && !isMine(r, cfg) {
+			continue
+		}
+
+		res = append(res, r)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = writeExport(file, res, exportFormat)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d reservations to %s\n", len(res), args[0])
+
+	return nil
+}
+
+// reservations carrying an owner email are matched on it, older ones on
+// the configured name
+func isMine(r *Reservation, cfg *Config) bool {
+	if r.Owner != "" && cfg.Mail != "" {
+		return r.Owner == cfg.Mail
+	}
+
+	return r.Name == cfg.Name
+}
+
+// fetch every page of reservations starting at u
+func fetchAll(u *url.URL) ([]*Reservation, error) {
+	var res []*Reservation
+
+	for {
+		rpy, next, err := fetchPage(u)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, rpy...)
+
+		if next == "" {
+			break
+		}
+
+		u, err = url.Parse(next)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func fetchPage(u *url.URL) ([]*Reservation, string, error) {
+	r, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("new request: %v", err)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return nil, "", fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status       string         `json:"status"`
+		Error        string         `json:"error"`
+		Reservations []*Reservation `json:"reservations"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode: %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return nil, "", errors.New(rpy.Error)
+	}
+
+	if rpy.Reservations == nil {
+		return nil, "", nil
+	}
+
+	return rpy.Reservations, resp.Header.Get("X-Next-Reservation"), nil
+}
+
+func writeExport(w io.Writer, res []*Reservation, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(res)
+	}
+
+	enc := json.NewEncoder(w)
+
+	for _, r := range res {
+		err := enc.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// read an export in either format
+func readExport(r io.Reader) ([]*Reservation, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Reservation, 0)
+
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &res)
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxRead)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var rec Reservation
+
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		res = append(res, &rec)
+	}
+
+	return res, scanner.Err()
+}
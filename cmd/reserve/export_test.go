@@ -0,0 +1,82 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestExport(t *testing.T) {
+	now := time.Now().Round(time.Second)
+
+	pages := [][]*Reservation{
+		{
+			&Reservation{ID: 1, Resource: "resource A", Start: now, End: now.Add(time.Hour), Name: "Some User"},
+			&Reservation{ID: 2, Resource: "resource B", Start: now, End: now, Loan: true, Name: "Another User"},
+		},
+		{
+			&Reservation{ID: 3, Resource: "resource A", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour), Name: "Some User", Notes: "some notes"},
+		},
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := 0
+		if r.URL.Query().Get("start") == "2" {
+			page = 1
+		} else {
+			w.Header().Set("X-Next-Reservation", srv.URL+"/?start=2")
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "Success",
+			"reservations": pages[page],
+		})
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + "/?start=0")
+
+	res, err := fetchAll(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 3 {
+		t.Fatalf("expected %d reservations got %d", 3, len(res))
+	}
+
+	for _, format := range []string{"jsonl", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var b bytes.Buffer
+
+			err := writeExport(&b, res, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			back, err := readExport(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(back) != len(res) {
+				t.Fatalf("expected %d reservations got %d", len(res), len(back))
+			}
+
+			for i := range res {
+				if back[i].ID != res[i].ID || back[i].Resource != res[i].Resource || !back[i].Start.Equal(res[i].Start) || back[i].Notes != res[i].Notes {
+					t.Fatalf("expected %s got %s", res[i], back[i])
+				}
+			}
+		})
+	}
+}
@@ -21,12 +21,30 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	fromNow   bool
+	untilFree bool
+)
+
 func init() {
 	extendCmd := &cobra.Command{
 		Use:   "extend <resource> <time specification>",
 		Short: "Extend an active reservation",
 		Long: `Extend an active reservation by the duration or specified end time
 
+A duration is added to the current end of the reservation, or with
+--from-now to the present time, for a reservation nearly over:
+
+    reserve extend <resource> --from-now + 2 hours
+
+The end is never moved earlier by --from-now.
+
+With --until-free the end stops at the start of the next reservation
+of the resource, when that comes first.  Without a time specification
+the reservation runs right up to the next one:
+
+    reserve extend <resource> --until-free
+
 See add command for details of time specification
 `,
 		RunE: extend,
@@ -34,12 +52,16 @@ See add command for details of time specification
 
 	extendCmd.Flags().BoolVar(&canshare, "share", false, "Can share")
 	extendCmd.Flags().StringVar(&notes, "notes", "", "Notes")
+	extendCmd.Flags().StringVar(&currentName, "name", "", "Extend the current reservation held by name")
+	extendCmd.Flags().IntVar(&currentID, "id", 0, "Extend the current reservation with this ID")
+	extendCmd.Flags().BoolVar(&fromNow, "from-now", false, "Extend by the duration from now rather than the current end")
+	extendCmd.Flags().BoolVar(&untilFree, "until-free", false, "Extend no further than the next reservation of the resource")
 
 	RootCmd.AddCommand(extendCmd)
 }
 
 func extend(cmd *cobra.Command, args []string) error {
-	if len(args) < 2 {
+	if len(args) < 2 && !(untilFree && len(args) == 1) {
 		return fmt.Errorf("resource and/or duration not specified")
 	}
 
@@ -115,8 +137,20 @@ func extend(cmd *cobra.Command, args []string) error {
 		return errors.New("empty reservation in response")
 	}
 
-	res := rpy.Reservations[0]
+	res, err := pickCurrent(os.Stdout, rpy.Reservations, currentName, currentID)
+	if err != nil {
+		return err
+	}
+
 	end := res.End.In(time.Local)
+	if fromNow {
+		end = time.Now()
+	}
+
+	if len(args) == 1 {
+		end = time.Time{}
+		goto clamp
+	}
 
 	end, err = ParseDuration(end, args[1:])
 	if err != nil {
@@ -139,19 +173,31 @@ func extend(cmd *cobra.Command, args []string) error {
 		os.Exit(1)
 	}
 
-	// send a Patch request with updated fields
+clamp:
+	if untilFree {
+		later, err := resourceReservations(res.Resource)
+		if err != nil {
+			return err
+		}
 
-	var patch strings.Builder
-	fmt.Fprintf(&patch, `{"end":"%s"`, end.Format(time.RFC3339Nano))
-	if notes != "" {
-		fmt.Fprintf(&patch, `, "notes":"%s"`, notes)
+		end = clampToNext(res, end, later)
+
+		if end.IsZero() {
+			return fmt.Errorf("nothing booked after reservation %d, give an end", res.ID)
+		}
+
+		if !end.After(res.End) {
+			return fmt.Errorf("next reservation of %s starts at the current end %s", res.Resource, res.End.In(time.Local).Format(time.RFC1123))
+		}
 	}
-	if canshare != res.Share {
-		fmt.Fprintf(&patch, `, "share":%t`, canshare)
+
+	if fromNow && end.Before(res.End) {
+		return fmt.Errorf("new end %s is before the current end %s", end.Format(time.RFC1123), res.End.In(time.Local).Format(time.RFC1123))
 	}
-	fmt.Fprintf(&patch, `}`)
 
-	b := bytes.NewBufferString(patch.String())
+	// send a Patch request with updated fields
+
+	b := bytes.NewBufferString(extendPatch(res, end, notes, canshare))
 
 	u, err = url.Parse(fmt.Sprintf("%s%d", service.String(), res.ID))
 	if err != nil {
@@ -164,6 +210,7 @@ func extend(cmd *cobra.Command, args []string) error {
 	}
 	r.Header.Set("Content-Type", "application/merge-patch+json")
 	r.Header.Set("If-Unmodified-Since", resp.Header.Get("Last-Modified"))
+	setUser(cmd, r)
 
 	resp, err = client.Do(r)
 	if err != nil {
@@ -200,3 +247,53 @@ func extend(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// merge patch moving the end of res, with notes and share when changed
+func extendPatch(res *Reservation, end time.Time, notes string, share bool) string {
+	var patch strings.Builder
+
+	fmt.Fprintf(&patch, `{"end":"%s"`, end.Format(time.RFC3339Nano))
+	if notes != "" {
+		quoted, _ := json.Marshal(notes)
+		fmt.Fprintf(&patch, `, "notes":%s`, quoted)
+	}
+	if share != res.Share {
+		fmt.Fprintf(&patch, `, "share":%t`, share)
+	}
+	fmt.Fprintf(&patch, `}`)
+
+	return patch.String()
+}
+
+// the unexpired reservations of resource
+func resourceReservations(resource string) ([]*Reservation, error) {
+	service.Path = V3api
+
+	u, err := url.Parse(service.String())
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("show", "active")
+	q.Set("resource", resource)
+	u.RawQuery = q.Encode()
+
+	return fetchAll(u)
+}
+
+// the end res can be extended to without running into a later
+// reservation - the first start after res, when sooner than the
+// requested end, a zero end asks for the first start alone
+func clampToNext(res *Reservation, end time.Time, others []*Reservation) time.Time {
+	for _, o := range others {
+		if o.ID == res.ID || o.Start.Before(res.End) {
+			continue
+		}
+
+		if end.IsZero() || o.Start.Before(end) {
+			end = o.Start
+		}
+	}
+
+	return end
+}
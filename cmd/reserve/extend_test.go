@@ -0,0 +1,75 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestExtendPatch(t *testing.T) {
+	end := time.Date(2021, time.April, 1, 17, 0, 0, 0, time.UTC)
+	res := &Reservation{ID: 1, Share: false}
+
+	tests := []struct {
+		name  string
+		notes string
+		share bool
+		patch string
+	}{
+		{name: "end", patch: `{"end":"2021-04-01T17:00:00Z"}`},
+		{name: "share", share: true, patch: `{"end":"2021-04-01T17:00:00Z", "share":true}`},
+		{name: "notes", notes: `say "hi"`, patch: `{"end":"2021-04-01T17:00:00Z", "notes":"say \"hi\""}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			patch := extendPatch(res, end, tc.notes, tc.share)
+
+			if patch != tc.patch {
+				t.Fatalf("expected %s got %s", tc.patch, patch)
+			}
+
+			var v map[string]interface{}
+			if err := json.Unmarshal([]byte(patch), &v); err != nil {
+				t.Fatalf("patch not JSON: %v", err)
+			}
+		})
+	}
+}
+
+func TestClampToNext(t *testing.T) {
+	now := time.Date(2021, time.April, 1, 12, 0, 0, 0, time.UTC)
+	res := &Reservation{ID: 1, Start: now.Add(-time.Hour), End: now.Add(time.Hour)}
+
+	blocker := &Reservation{ID: 3, Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)}
+	later := &Reservation{ID: 4, Start: now.Add(6 * time.Hour), End: now.Add(7 * time.Hour)}
+	shared := &Reservation{ID: 2, Start: now, End: now.Add(5 * time.Hour)}
+
+	tests := []struct {
+		name   string
+		end    time.Time
+		others []*Reservation
+		expect time.Time
+	}{
+		{name: "no blocker", end: now.Add(5 * time.Hour), others: []*Reservation{res}, expect: now.Add(5 * time.Hour)},
+		{name: "blocker sooner", end: now.Add(5 * time.Hour), others: []*Reservation{res, later, blocker}, expect: blocker.Start},
+		{name: "blocker later", end: now.Add(2 * time.Hour), others: []*Reservation{res, blocker}, expect: now.Add(2 * time.Hour)},
+		{name: "shared holder ignored", end: now.Add(2 * time.Hour), others: []*Reservation{shared}, expect: now.Add(2 * time.Hour)},
+		{name: "up to next", others: []*Reservation{later, blocker}, expect: blocker.Start},
+		{name: "nothing next", others: []*Reservation{res, shared}, expect: time.Time{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			end := clampToNext(res, tc.end, tc.others)
+
+			if !end.Equal(tc.expect) {
+				t.Fatalf("expected %s got %s", tc.expect, end)
+			}
+		})
+	}
+}
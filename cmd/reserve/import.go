@@ -0,0 +1,143 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+var importDryrun bool
+
+func init() {
+	importCmd := &cobra.Command{
+		Use:   "import <file.jsonl>",
+		Short: "Add reservations from a file",
+		Long: `Add reservations from a file
+
+The file holds one JSON reservation per line, the same records the
+server keeps and export writes.  Each reservation is added in turn and
+reported by line number; a failure, such as a conflict with an existing
+reservation, is reported and the import continues.  Reservations without
+a name are added under the configured name.
+`,
+		RunE: importFile,
+	}
+
+	importCmd.Flags().BoolVarP(&importDryrun, "dry-run", "n", false, "Check the file without adding reservations")
+
+	RootCmd.AddCommand(importCmd)
+}
+
+func importFile(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("import file not specified")
+	}
+
+	conffile := cmd.Flag("config").Value.String()
+	cfg, err := getConfig(conffile)
+	if err != nil {
+		return fmt.Errorf("Unable to read config (%v).  Run with 'config' to initialize.", err)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	added, failed, err := importReservations(os.Stdout, file, cfg, importDryrun)
+	if err != nil {
+		return err
+	}
+
+	if importDryrun {
+		fmt.Printf("%d reservations ok, %d failed\n", added, failed)
+	} else {
+		fmt.Printf("imported %d reservations, %d failed\n", added, failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d reservations not imported", failed)
+	}
+
+	return nil
+}
+
+// add each line of r as a reservation, reporting per line to w and
+// carrying on past failures
+func importReservations(w io.Writer, r io.Reader, cfg *Config, dry bool) (int, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxRead)
+
+	added, failed := 0, 0
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var res Reservation
+
+		err := json.Unmarshal(scanner.Bytes(), &res)
+		if err == nil {
+			err = checkImport(&res)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+
+		// the server hands out ids
+		res.ID = 0
+
+		if res.Name == "" {
+			res.Name = cfg.Name
+			res.Initials = cfg.Abbrev
+			res.Owner = cfg.Mail
+		}
+
+		if dry {
+			fmt.Fprintf(w, "line %d: ok %s\n", line, &res)
+			added++
+			continue
+		}
+
+		id, err := post(V3api, &res)
+		if err != nil {
+			fmt.Fprintf(w, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(w, "line %d: added reservation %d\n", line, id)
+		added++
+	}
+
+	return added, failed, scanner.Err()
+}
+
+func checkImport(res *Reservation) error {
+	if res.Resource == "" {
+		return errors.New("resource not specified")
+	}
+
+	if res.Start.IsZero() {
+		return errors.New("start time not specified")
+	}
+
+	if !res.Loan && !res.End.After(res.Start) {
+		return errors.New("end time not after start time")
+	}
+
+	return nil
+}
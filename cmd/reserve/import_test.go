@@ -0,0 +1,83 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestImport(t *testing.T) {
+	var added []*Reservation
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res Reservation
+		json.NewDecoder(r.Body).Decode(&res)
+
+		for _, a := range added {
+			if a.Resource == res.Resource && res.Start.Before(a.End) && a.Start.Before(res.End) {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"status": "Error",
+					"error":  "reservation conflict",
+				})
+				return
+			}
+		}
+
+		added = append(added, &res)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "Success",
+			"id":     len(added),
+		})
+	}))
+	defer srv.Close()
+
+	saved := service
+	defer func() { service = saved }()
+	service, _ = url.Parse(srv.URL)
+
+	now := time.Now().Round(time.Second)
+
+	var file bytes.Buffer
+	enc := json.NewEncoder(&file)
+	enc.Encode(&Reservation{Resource: "resource A", Start: now, End: now.Add(2 * time.Hour), Name: "Some User"})
+	enc.Encode(&Reservation{Resource: "resource A", Start: now.Add(time.Hour), End: now.Add(3 * time.Hour), Name: "Another User"})
+
+	cfg := &Config{Name: "Config User"}
+
+	for _, dry := range []bool{true, false} {
+		var out bytes.Buffer
+
+		ok, failed, err := importReservations(&out, bytes.NewReader(file.Bytes()), cfg, dry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dry {
+			if ok != 2 || failed != 0 || len(added) != 0 {
+				t.Fatalf("dry run: expected 2 ok 0 failed 0 sent, got %d ok %d failed %d sent", ok, failed, len(added))
+			}
+			continue
+		}
+
+		if ok != 1 || failed != 1 {
+			t.Fatalf("expected 1 added 1 failed, got %d added %d failed", ok, failed)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "line 1: added") || !strings.HasPrefix(lines[1], "line 2: ") || !strings.Contains(lines[1], "conflict") {
+			t.Fatalf("unexpected report \"%s\"", out.String())
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,9 +13,13 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	. "github.com/dbulkow/reservations/api"
 	"github.com/spf13/cobra"
@@ -29,10 +34,30 @@ var (
 	showres    bool
 	history    bool
 	showall    bool
+	loans      bool
 	mine       bool
 	numres     int
+	expiring   time.Duration
+	upcoming   time.Duration
+	freeOnly   bool
+	meFirst    bool
+	notelen    int
+	fromspec   string
+	tospec     string
+	sincespec  string
+	initials   string
+	tag        string
+	watch      bool
+	interval   time.Duration
+	tmplspec   string
+	listFormat string
 )
 
+// tab separated columns for --format=tsv, times in RFC3339 and the end
+// left empty for a loan
+const tsvTemplate = "{{.ID}}\t{{.Resource}}\t{{.Name}}\t{{.Start.Local.Format \"2006-01-02T15:04:05Z07:00\"}}\t" +
+	"{{if not .Loan}}{{.End.Local.Format \"2006-01-02T15:04:05Z07:00\"}}{{end}}"
+
 func init() {
 	listCmd := &cobra.Command{
 		Use:     "list [<resource name or prefix>]",
@@ -53,13 +78,28 @@ sort order, list the history of a resource and more.
 	listCmd.Flags().BoolVarP(&long, "long", "l", false, "Long listing")
 	listCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Don't display header")
 	listCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "JSON output")
-	listCmd.Flags().StringVar(&sortby, "sort-by", "resource", "Sort by [date, resource, name, id]")
+	listCmd.Flags().StringVar(&tmplspec, "template", "", "Print each reservation with a Go template (e.g. '{{.Resource}} {{.Name}}')")
+	listCmd.Flags().StringVar(&listFormat, "format", "", "Output format [tsv]")
+	listCmd.Flags().StringVar(&sortby, "sort-by", "resource", "Sort by [date, end, duration, resource, name, id]")
+	listCmd.Flags().BoolVar(&meFirst, "me-first", false, "List your reservations first, in sort order")
+	listCmd.Flags().IntVar(&notelen, "truncate-notes", 60, "Shorten notes in long listings to this many characters, 0 for all")
 	listCmd.Flags().BoolVarP(&showres, "showres", "r", false, "Show reservation number")
 	listCmd.Flags().BoolVar(&history, "history", false, "Include reservation history")
 	listCmd.Flags().BoolVar(&showall, "all", false, "Show all reservations, history, current, future")
+	listCmd.Flags().BoolVar(&loans, "loans", false, "Show resources on loan only")
 	listCmd.Flags().BoolVarP(&mine, "mine", "m", false, "Show your reservations only")
+	listCmd.Flags().StringVar(&initials, "initials", "", "Show reservations made under these initials")
+	listCmd.Flags().StringVar(&tag, "tag", "", "Show reservations carrying this tag")
 	listCmd.Flags().BoolVarP(&current, "current", "c", false, "List active reservations")
 	listCmd.Flags().IntVarP(&numres, "num", "n", 50, "Number of reservations to retrieve each request")
+	listCmd.Flags().StringVar(&fromspec, "from", "", "Show reservations ending after time specification")
+	listCmd.Flags().StringVar(&tospec, "to", "", "Show reservations starting before time specification")
+	listCmd.Flags().StringVar(&sincespec, "since", "", "Show reservations ending after a past time (e.g. \"last monday\", \"3 days ago\")")
+	listCmd.Flags().DurationVar(&expiring, "expiring", 0, "Show reservations ending within duration (e.g. 4h)")
+	listCmd.Flags().DurationVar(&upcoming, "upcoming", 0, "Show reservations starting within duration (e.g. 4h)")
+	listCmd.Flags().BoolVarP(&watch, "watch", "w", false, "Redraw the listing as reservations change, until interrupted")
+	listCmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "Time between checks for changes with --watch")
+	listCmd.Flags().BoolVar(&freeOnly, "free", false, "Show free time for a resource between --from (or now) and --to (or a week out)")
 
 	RootCmd.AddCommand(listCmd)
 }
@@ -77,6 +117,11 @@ func list(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Unable to read config (%v).  Run with 'config' to initialize.", err)
 	}
 
+	tmpl, err := listTemplate(tmplspec, listFormat)
+	if err != nil {
+		return err
+	}
+
 	service.Path = V3api
 
 	u, err := url.Parse(service.String())
@@ -85,24 +130,135 @@ func list(cmd *cobra.Command, args []string) error {
 	}
 	q := u.Query()
 
+	if freeOnly && len(args) < 1 {
+		return errors.New("resource name required with --free")
+	}
+
 	if current {
 		q.Set("show", "current")
 	} else if history {
 		q.Set("show", "history")
 	} else if showall {
 		q.Set("show", "all")
+	} else if loans {
+		q.Set("show", "loans")
+	} else if upcoming > 0 {
+		q.Set("show", "upcoming")
+		q.Set("within", upcoming.String())
+	}
+
+	if initials != "" {
+		q.Set("initials", initials)
+	}
+
+	if tag != "" {
+		q.Set("tag", tag)
+	}
+
+	from := time.Now()
+	to := from.AddDate(0, 0, 7)
+
+	if fromspec != "" {
+		from, err = ParseTime(time.Now(), strings.Fields(fromspec))
+		if err != nil {
+			return fmt.Errorf("from: %v", err)
+		}
+		q.Set("from", from.Format(time.RFC3339))
+	}
+
+	if tospec != "" {
+		to, err = ParseTime(time.Now(), strings.Fields(tospec))
+		if err != nil {
+			return fmt.Errorf("to: %v", err)
+		}
+		q.Set("to", to.Format(time.RFC3339))
+	}
+
+	var since time.Time
+
+	if sincespec != "" {
+		since, err = ParsePastTime(time.Now(), strings.Fields(sincespec))
+		if err != nil {
+			return fmt.Errorf("since: %v", err)
+		}
 	}
 
 	q.Set("limit", strconv.Itoa(numres))
 	q.Set("start", "0")
 	u.RawQuery = q.Encode()
 
-	var res []*Reservation
+	if !watch {
+		res, _, _, err := listFetch(u, "")
+		if err != nil {
+			return err
+		}
+
+		return listRender(res, cfg, args, from, to, since, tmpl)
+	}
+
+	if interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var lastmod string
+
+	for {
+		res, mod, changed, err := listFetch(u, lastmod)
+		if err != nil {
+			return err
+		}
+
+		if watchChanged(changed, mod, lastmod) {
+			// clear the screen and home the cursor
+			fmt.Print("\033[H\033[2J")
+
+			err = listRender(res, cfg, args, from, to, since, tmpl)
+			if err != nil {
+				return err
+			}
+
+			lastmod = mod
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// a watch redraws unless the server answered not modified or reports
+// the same modification time as the listing on screen - the first
+// fetch, with nothing on screen, always draws
+func watchChanged(changed bool, lastmod, prev string) bool {
+	if prev == "" {
+		return true
+	}
+
+	return changed && lastmod != prev
+}
+
+// fetch every page of the listing at u, with modsince set the first
+// page is asked for only if modified since then - the Last-Modified of
+// the first page is returned along with whether the listing changed
+func listFetch(u *url.URL, modsince string) ([]*Reservation, string, bool, error) {
+	var (
+		res     []*Reservation
+		lastmod string
+	)
 
 	for {
 		r, err := http.NewRequest(http.MethodGet, u.String(), nil)
 		if err != nil {
-			return fmt.Errorf("new request: %v", err)
+			return nil, "", false, fmt.Errorf("new request: %v", err)
+		}
+
+		if modsince != "" && lastmod == "" {
+			r.Header.Set("If-Modified-Since", modsince)
 		}
 
 		if false {
@@ -116,10 +272,10 @@ func list(cmd *cobra.Command, args []string) error {
 
 		resp, err := client.Do(r)
 		if err != nil {
-			return fmt.Errorf("http: %v", err)
+			return nil, "", false, fmt.Errorf("http: %v", err)
 		}
 		if resp == nil {
-			return fmt.Errorf("empty response")
+			return nil, "", false, fmt.Errorf("empty response")
 		}
 		defer func() {
 			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
@@ -135,8 +291,16 @@ func list(cmd *cobra.Command, args []string) error {
 			fmt.Println(string(out))
 		}
 
+		if resp.StatusCode == http.StatusNotModified {
+			return nil, modsince, false, nil
+		}
+
 		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("response status: %s", resp.Status)
+			return nil, "", false, fmt.Errorf("response status: %s", resp.Status)
+		}
+
+		if lastmod == "" {
+			lastmod = resp.Header.Get("Last-Modified")
 		}
 
 		rpy := struct {
@@ -147,11 +311,11 @@ func list(cmd *cobra.Command, args []string) error {
 
 		err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
 		if err != nil {
-			return fmt.Errorf("decode: %v", err)
+			return nil, "", false, fmt.Errorf("decode: %v", err)
 		}
 
 		if rpy.Status != "Success" {
-			return errors.New(rpy.Error)
+			return nil, "", false, errors.New(rpy.Error)
 		}
 
 		if rpy.Reservations == nil {
@@ -169,15 +333,95 @@ func list(cmd *cobra.Command, args []string) error {
 
 		u, err = url.Parse(next)
 		if err != nil {
-			return err
+			return nil, "", false, err
 		}
 	}
 
+	return res, lastmod, true, nil
+}
+
+// the template set by --template or --format, parsed before any request
+// is made - nil when neither is given
+func listTemplate(spec, format string) (*template.Template, error) {
+	switch format {
+	case "":
+	case "tsv":
+		if spec != "" {
+			return nil, errors.New("--format and --template are exclusive")
+		}
+		spec = tsvTemplate
+	default:
+		return nil, fmt.Errorf("unknown format \"%s\"", format)
+	}
+
+	if spec == "" {
+		return nil, nil
+	}
+
+	if long || jsonOutput {
+		return nil, errors.New("--template and --format can't be used with --long or --json")
+	}
+
+	tmpl, err := template.New("list").Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+
+	return tmpl, nil
+}
+
+// print r with tmpl, a line each
+func printTemplate(w io.Writer, tmpl *template.Template, r *Reservation) error {
+	var b strings.Builder
+
+	err := tmpl.Execute(&b, r)
+	if err != nil {
+		return fmt.Errorf("template: %v", err)
+	}
+
+	fmt.Fprintln(w, strings.TrimSuffix(b.String(), "\n"))
+
+	return nil
+}
+
+// print the fetched reservations as selected by the list flags, with
+// tmpl each reservation is printed by the template alone
+func listRender(res []*Reservation, cfg *Config, args []string, from, to, since time.Time, tmpl *template.Template) error {
+	if expiring > 0 {
+		res = endingWithin(res, time.Now(), expiring)
+	}
+
+	if !since.IsZero() {
+		res = endedAfter(res, since)
+	}
+
 	var filter string
 	if len(args) > 0 {
 		filter = args[0]
 	}
 
+	if freeOnly {
+		sched := make([]*Reservation, 0, len(res))
+		for _, r := range res {
+			if r.Resource == filter {
+				sched = append(sched, r)
+			}
+		}
+
+		gaps := freeIntervals(sched, from, to)
+		if gaps == nil {
+			fmt.Printf("%s is on loan\n", filter)
+			return nil
+		}
+
+		if len(gaps) == 0 && !jsonOutput {
+			fmt.Printf("%s has no free time\n", filter)
+			return nil
+		}
+
+		return printRanges(os.Stdout, gaps, jsonOutput)
+	}
+
 	datefmt := "Jan _2 15:04 2006"
 
 	var (
@@ -189,7 +433,7 @@ func list(cmd *cobra.Command, args []string) error {
 		hasShare = false
 	)
 
-	if !long && !jsonOutput {
+	if !long && !jsonOutput && tmpl == nil {
 		for _, r := range res {
 			if !strings.HasPrefix(r.Resource, filter) {
 				continue
@@ -218,16 +462,24 @@ func list(cmd *cobra.Command, args []string) error {
 
 	switch sortby {
 	case "resource":
-		sort.Sort(byResource(res))
+		sort.Sort(ByResource(res))
 	case "name":
-		sort.Sort(byName(res))
+		sort.Sort(ByName(res))
 	case "date":
-		sort.Sort(byDate(res))
+		sort.Sort(ByDate(res))
+	case "end":
+		sort.Sort(ByEnd(res))
+	case "duration":
+		sort.Sort(ByDuration(res))
 	case "id":
-		sort.Sort(byID(res))
+		sort.Sort(ByID(res))
+	}
+
+	if meFirst {
+		res = ownFirst(res, cfg.Name)
 	}
 
-	if !quiet && !jsonOutput {
+	if !quiet && !jsonOutput && tmpl == nil {
 		if long {
 			fmt.Println("reservation          details")
 			fmt.Println("-----------          -------")
@@ -274,28 +526,14 @@ func list(cmd *cobra.Command, args []string) error {
 			continue
 		}
 		start := r.Start.Local().Format(datefmt)
-		end := r.End.Local().Format(datefmt)
-		if long {
-			canshare := ""
-			if r.Share {
-				canshare = " (can share)"
-			}
-			fmt.Printf("%5d\t   Resource: %s%s\n", r.ID, r.Resource, canshare)
-			if r.Loan {
-				fmt.Printf("\tReservation: On Loan\n")
-			} else {
-				fmt.Printf("\tReservation: %s - %s\n", start, end)
-			}
-			fmt.Printf("\t       Name: %s", r.Name)
-			if r.Email == "" {
-				fmt.Printf("\n")
-			} else {
-				fmt.Printf("(%s)\n", r.Email)
-			}
-			if r.Notes != "" {
-				fmt.Printf("\t      Notes: %s\n", r.Notes)
+		end := endTime(r, datefmt)
+		if tmpl != nil {
+			err := printTemplate(os.Stdout, tmpl, r)
+			if err != nil {
+				return err
 			}
-			fmt.Println()
+		} else if long {
+			printLong(r, datefmt, notelen)
 		} else if jsonOutput {
 			b, err := json.Marshal(&r)
 			if err != nil {
@@ -336,3 +574,146 @@ func list(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// the free windows of one resource's schedule between from and to, nil
+// when on loan as a loan occupies the resource indefinitely
+func freeIntervals(res []*Reservation, from, to time.Time) [][2]time.Time {
+	for _, r := range res {
+		if r.Loan {
+			return nil
+		}
+	}
+
+	sched := make([]*Reservation, len(res))
+	copy(sched, res)
+	sort.Sort(ByDate(sched))
+
+	gaps := make([][2]time.Time, 0)
+	cursor := from
+
+	for _, r := range sched {
+		if !cursor.Before(to) {
+			break
+		}
+
+		if !r.End.After(cursor) {
+			continue
+		}
+
+		if r.Start.After(cursor) {
+			end := r.Start
+			if end.After(to) {
+				end = to
+			}
+			gaps = append(gaps, [2]time.Time{cursor, end})
+		}
+
+		cursor = r.End
+	}
+
+	if cursor.Before(to) {
+		gaps = append(gaps, [2]time.Time{cursor, to})
+	}
+
+	return gaps
+}
+
+// move reservations held under name to the front, keeping the sorted
+// order within each group
+func ownFirst(res []*Reservation, name string) []*Reservation {
+	out := make([]*Reservation, 0, len(res))
+	rest := make([]*Reservation, 0, len(res))
+
+	for _, r := range res {
+		if r.Name == name {
+			out = append(out, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+
+	return append(out, rest...)
+}
+
+// reservations ending between now and now plus window, loans never end
+func endingWithin(res []*Reservation, now time.Time, window time.Duration) []*Reservation {
+	ending := make([]*Reservation, 0)
+
+	for _, r := range res {
+		if r.Loan || r.End.Before(now) || r.End.After(now.Add(window)) {
+			continue
+		}
+		ending = append(ending, r)
+	}
+
+	return ending
+}
+
+// reservations ending after since, loans have not ended and are kept
+func endedAfter(res []*Reservation, since time.Time) []*Reservation {
+	ended := make([]*Reservation, 0)
+
+	for _, r := range res {
+		if !r.Loan && !r.End.After(since) {
+			continue
+		}
+		ended = append(ended, r)
+	}
+
+	return ended
+}
+
+// the end of r in datefmt, an open-ended reservation has none yet
+func endTime(r *Reservation, datefmt string) string {
+	if r.OpenEnded {
+		return "open"
+	}
+
+	return r.End.Local().Format(datefmt)
+}
+
+// notes longer than notelen are cut short, 0 prints them in full
+func printLong(r *Reservation, datefmt string, notelen int) {
+	canshare := ""
+	if r.Share {
+		canshare = " (can share)"
+	}
+	if r.Flexible {
+		canshare += " (flexible)"
+	}
+	fmt.Printf("%5d\t   Resource: %s%s\n", r.ID, r.Resource, canshare)
+	if r.Loan {
+		fmt.Printf("\tReservation: On Loan\n")
+	} else {
+		start := r.Start.Local().Format(datefmt)
+		end := endTime(r, datefmt)
+		fmt.Printf("\tReservation: %s - %s\n", start, end)
+	}
+	fmt.Printf("\t       Name: %s", r.Name)
+	if r.Email == "" {
+		fmt.Printf("\n")
+	} else {
+		fmt.Printf("(%s)\n", r.Email)
+	}
+	if r.Notes != "" {
+		fmt.Printf("\t      Notes: %s\n", truncateNotes(r.Notes, notelen))
+	}
+	for _, l := range r.Links {
+		fmt.Printf("\t       Link: %s\n", l)
+	}
+	if len(r.Tags) > 0 {
+		fmt.Printf("\t       Tags: %s\n", strings.Join(r.Tags, ", "))
+	}
+	fmt.Println()
+}
+
+// cut s to n characters, the last an ellipsis when anything was dropped
+func truncateNotes(s string, n int) string {
+	r := []rune(s)
+
+	if n <= 0 || len(r) <= n {
+		return s
+	}
+
+	return string(r[:n-1]) + "…"
+}
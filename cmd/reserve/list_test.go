@@ -0,0 +1,323 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestListEndingWithin(t *testing.T) {
+	now := time.Now()
+
+	res := []*Reservation{
+		&Reservation{ID: 1, Start: now.Add(-time.Hour), End: now.Add(-time.Second)},
+		&Reservation{ID: 2, Start: now.Add(-time.Hour), End: now},
+		&Reservation{ID: 3, Start: now.Add(-time.Hour), End: now.Add(4 * time.Hour)},
+		&Reservation{ID: 4, Start: now.Add(-time.Hour), End: now.Add(4*time.Hour + time.Second)},
+		&Reservation{ID: 5, Start: now.Add(-time.Hour), End: now.Add(-time.Hour), Loan: true},
+	}
+
+	ending := endingWithin(res, now, 4*time.Hour)
+
+	if len(ending) != 2 {
+		t.Fatalf("expected %d reservations got %d", 2, len(ending))
+	}
+
+	if ending[0].ID != 2 || ending[1].ID != 3 {
+		t.Fatalf("expected reservations 2 and 3 got %d and %d", ending[0].ID, ending[1].ID)
+	}
+}
+
+func TestListSince(t *testing.T) {
+	now, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2017-04-05 10:00:00 -0400 EDT")
+
+	since, err := ParsePastTime(now, []string{"last", "monday"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := []*Reservation{
+		&Reservation{ID: 1, Start: now.AddDate(0, 0, -7), End: now.AddDate(0, 0, -6)},
+		&Reservation{ID: 2, Start: now.AddDate(0, 0, -3), End: now.AddDate(0, 0, -2)},
+		&Reservation{ID: 3, Start: now.AddDate(0, 0, -9), Loan: true},
+		&Reservation{ID: 4, Start: since.Add(-time.Hour), End: since},
+	}
+
+	ended := endedAfter(res, since)
+
+	if len(ended) != 2 {
+		t.Fatalf("expected %d reservations got %d", 2, len(ended))
+	}
+
+	if ended[0].ID != 2 || ended[1].ID != 3 {
+		t.Fatalf("expected reservations 2 and 3 got %d and %d", ended[0].ID, ended[1].ID)
+	}
+}
+
+func TestListOwnFirst(t *testing.T) {
+	res := []*Reservation{
+		&Reservation{ID: 1, Name: "Another User"},
+		&Reservation{ID: 2, Name: "Some User"},
+		&Reservation{ID: 3, Name: "Third User"},
+		&Reservation{ID: 4, Name: "Some User"},
+		&Reservation{ID: 5, Name: "Another User"},
+	}
+
+	res = ownFirst(res, "Some User")
+
+	expected := []int{2, 4, 1, 3, 5}
+
+	for i, id := range expected {
+		if res[i].ID != id {
+			t.Fatalf("expected reservation %d at %d got %d", id, i, res[i].ID)
+		}
+	}
+}
+
+func TestListFreeIntervals(t *testing.T) {
+	now := time.Now()
+	to := now.Add(10 * time.Hour)
+
+	res := []*Reservation{
+		&Reservation{ID: 3, Start: now.Add(6 * time.Hour), End: now.Add(7 * time.Hour)},
+		&Reservation{ID: 1, Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+		&Reservation{ID: 2, Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)},
+		&Reservation{ID: 4, Start: now.Add(9 * time.Hour), End: now.Add(12 * time.Hour)},
+	}
+
+	gaps := freeIntervals(res, now, to)
+
+	expect := [][2]time.Time{
+		{now.Add(time.Hour), now.Add(3 * time.Hour)},
+		{now.Add(4 * time.Hour), now.Add(6 * time.Hour)},
+		{now.Add(7 * time.Hour), now.Add(9 * time.Hour)},
+	}
+
+	if len(gaps) != len(expect) {
+		t.Fatalf("expected %d gaps got %d", len(expect), len(gaps))
+	}
+
+	for i := range expect {
+		if !gaps[i][0].Equal(expect[i][0]) || !gaps[i][1].Equal(expect[i][1]) {
+			t.Fatalf("gap %d expected %s - %s got %s - %s", i, expect[i][0], expect[i][1], gaps[i][0], gaps[i][1])
+		}
+	}
+
+	if gaps := freeIntervals(nil, now, to); len(gaps) != 1 || !gaps[0][0].Equal(now) || !gaps[0][1].Equal(to) {
+		t.Fatalf("expected whole window free got %v", gaps)
+	}
+
+	loan := append(res, &Reservation{ID: 5, Start: now.Add(-time.Hour), End: now.Add(-time.Hour), Loan: true})
+
+	if gaps := freeIntervals(loan, now, to); gaps != nil {
+		t.Fatalf("expected no free time on loan got %v", gaps)
+	}
+}
+
+func TestListTruncateNotes(t *testing.T) {
+	tests := []struct {
+		notes string
+		n     int
+		exp   string
+	}{
+		{notes: "0123456789", n: 0, exp: "0123456789"},
+		{notes: "0123456789", n: 11, exp: "0123456789"},
+		{notes: "0123456789", n: 10, exp: "0123456789"},
+		{notes: "0123456789", n: 9, exp: "01234567…"},
+		{notes: "0123456789", n: 1, exp: "…"},
+		{notes: "ééééé", n: 4, exp: "ééé…"},
+	}
+
+	for _, tc := range tests {
+		out := truncateNotes(tc.notes, tc.n)
+		if out != tc.exp {
+			t.Fatalf("expected \"%s\" for %d got \"%s\"", tc.exp, tc.n, out)
+		}
+	}
+}
+
+func TestListWatchChanged(t *testing.T) {
+	const (
+		then = "Mon, 03 Apr 2017 08:00:00 EDT"
+		now  = "Mon, 03 Apr 2017 08:05:00 EDT"
+	)
+
+	tests := []struct {
+		name    string
+		changed bool
+		lastmod string
+		prev    string
+		exp     bool
+	}{
+		{name: "first fetch", changed: true, lastmod: then, prev: "", exp: true},
+		{name: "first fetch no last modified", changed: true, lastmod: "", prev: "", exp: true},
+		{name: "not modified", changed: false, lastmod: then, prev: then, exp: false},
+		{name: "modified", changed: true, lastmod: now, prev: then, exp: true},
+		{name: "same last modified", changed: true, lastmod: then, prev: then, exp: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if out := watchChanged(tc.changed, tc.lastmod, tc.prev); out != tc.exp {
+				t.Fatalf("expected %t got %t", tc.exp, out)
+			}
+		})
+	}
+}
+
+func TestListFetchNotModified(t *testing.T) {
+	const lastmod = "Mon, 03 Apr 2017 08:00:00 EDT"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Modified-Since") == lastmod {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Last-Modified", lastmod)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "Success",
+			"reservations": []*Reservation{&Reservation{ID: 1, Resource: "resource A"}},
+		})
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + "/?start=0")
+
+	res, mod, changed, err := listFetch(u, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed || mod != lastmod || len(res) != 1 {
+		t.Fatalf("expected 1 reservation modified %s got %d modified %s (changed %t)", lastmod, len(res), mod, changed)
+	}
+
+	res, mod, changed, err = listFetch(u, mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed || mod != lastmod || len(res) != 0 {
+		t.Fatalf("expected unchanged listing got %d reservations modified %s (changed %t)", len(res), mod, changed)
+	}
+}
+
+func TestListTemplate(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2017-04-05T10:00:00-04:00")
+	end := start.Add(2 * time.Hour)
+
+	res := []*Reservation{
+		&Reservation{ID: 1, Resource: "alpha", Name: "Some User", Start: start, End: end},
+		&Reservation{ID: 2, Resource: "beta", Name: "Other User", Start: start, Loan: true},
+	}
+
+	tests := []struct {
+		name   string
+		spec   string
+		format string
+		expect string
+	}{
+		{
+			name:   "simple",
+			spec:   "{{.Resource}} {{.Name}}",
+			expect: "alpha Some User\nbeta Other User\n",
+		},
+		{
+			name:   "trailing newline",
+			spec:   "{{.ID}}\n",
+			expect: "1\n2\n",
+		},
+		{
+			name:   "tsv",
+			format: "tsv",
+			expect: "1\talpha\tSome User\t" + start.Local().Format(time.RFC3339) + "\t" + end.Local().Format(time.RFC3339) + "\n" +
+				"2\tbeta\tOther User\t" + start.Local().Format(time.RFC3339) + "\t\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := listTemplate(tc.spec, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+
+			for _, r := range res {
+				err = printTemplate(&b, tmpl, r)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if b.String() != tc.expect {
+				t.Fatalf("expected %q got %q", tc.expect, b.String())
+			}
+		})
+	}
+}
+
+func TestListTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		format string
+		expect string
+	}{
+		{name: "parse", spec: "{{.Resource", expect: "template: "},
+		{name: "format", format: "csv", expect: "unknown format \"csv\""},
+		{name: "both", spec: "{{.ID}}", format: "tsv", expect: "exclusive"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := listTemplate(tc.spec, tc.format)
+			if err == nil || !strings.Contains(err.Error(), tc.expect) {
+				t.Fatalf("expected error containing %q got %v", tc.expect, err)
+			}
+		})
+	}
+
+	tmpl, err := listTemplate("{{.Nope}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = printTemplate(ioutil.Discard, tmpl, &Reservation{})
+	if err == nil {
+		t.Fatal("expected execute error for unknown field")
+	}
+
+	tmpl, err = listTemplate("", "")
+	if err != nil || tmpl != nil {
+		t.Fatalf("expected no template got %v, %v", tmpl, err)
+	}
+}
+
+func TestListEndTime(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2017-04-05T10:00:00-04:00")
+
+	datefmt := "Jan _2 15:04 2006"
+
+	r := &Reservation{Start: start, End: start.Add(time.Hour)}
+	if exp := start.Add(time.Hour).Local().Format(datefmt); endTime(r, datefmt) != exp {
+		t.Fatalf("expected \"%s\" got \"%s\"", exp, endTime(r, datefmt))
+	}
+
+	r.OpenEnded = true
+	if endTime(r, datefmt) != "open" {
+		t.Fatalf("expected \"open\" got \"%s\"", endTime(r, datefmt))
+	}
+}
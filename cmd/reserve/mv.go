@@ -0,0 +1,155 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mvCmd := &cobra.Command{
+		Use:   "mv <resource id number> <new resource>",
+		Short: "Move a reservation to another resource",
+		Long: `Move a reservation to another resource
+
+The reservation keeps its ID, times and history.  The new resource must
+be free for the whole reservation.
+
+Only future reservations can move, the server refuses to change the
+resource of one already active.
+`,
+		RunE: mv,
+	}
+
+	RootCmd.AddCommand(mvCmd)
+}
+
+func mv(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("reservation id and/or new resource not specified")
+	}
+
+	resid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	// read the current reservation for the resid
+
+	service.Path = V3api
+
+	u, err := url.Parse(fmt.Sprintf("%s%d", service, resid))
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status      string       `json:"status"`
+		Error       string       `json:"error"`
+		Reservation *Reservation `json:"reservation"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return errors.New(rpy.Error)
+	}
+
+	if rpy.Reservation == nil {
+		return errors.New("empty reservation in response")
+	}
+
+	res := rpy.Reservation
+
+	if err := mvCheck(res, time.Now()); err != nil {
+		return err
+	}
+
+	// send a Patch request, the server checks the new resource is free
+
+	b := bytes.NewBufferString(mvPatch(args[1]))
+
+	r, err := http.NewRequest(http.MethodPatch, u.String(), b)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	r.Header.Set("If-Unmodified-Since", resp.Header.Get("Last-Modified"))
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-Match", etag)
+	}
+	setUser(cmd, r)
+
+	resp, err = client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	rpy.Reservation = nil
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+
+	if rpy.Status != "Success" {
+		return fmt.Errorf("error: %s", rpy.Error)
+	}
+
+	if rpy.Reservation == nil {
+		return errors.New("empty reservation in response")
+	}
+
+	res = rpy.Reservation
+
+	fmt.Printf("moved reservation %d to %s\n", res.ID, res.Resource)
+
+	return nil
+}
+
+// only reservations yet to start can change resource
+func mvCheck(res *Reservation, now time.Time) error {
+	if res.Start.Before(now) {
+		return fmt.Errorf("reservation %d already active, only future reservations can move", res.ID)
+	}
+
+	return nil
+}
+
+// merge patch moving a reservation to resource
+func mvPatch(resource string) string {
+	quoted, _ := json.Marshal(resource)
+	return fmt.Sprintf(`{"resource":%s}`, quoted)
+}
@@ -0,0 +1,49 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestMvPatch(t *testing.T) {
+	patch := mvPatch(`lab "7"`)
+
+	exp := `{"resource":"lab \"7\""}`
+	if patch != exp {
+		t.Fatalf("expected %s got %s", exp, patch)
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &v); err != nil {
+		t.Fatalf("patch not JSON: %v", err)
+	}
+
+	if v["resource"] != `lab "7"` || len(v) != 1 {
+		t.Fatalf("expected only the resource got %v", v)
+	}
+}
+
+func TestMvCheck(t *testing.T) {
+	now := time.Date(2021, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	future := &Reservation{ID: 7, Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)}
+	if err := mvCheck(future, now); err != nil {
+		t.Fatalf("future reservation refused: %v", err)
+	}
+
+	active := &Reservation{ID: 8, Start: now.Add(-time.Hour), End: now.Add(time.Hour)}
+	err := mvCheck(active, now)
+	if err == nil {
+		t.Fatal("active reservation allowed to move")
+	}
+
+	exp := "reservation 8 already active, only future reservations can move"
+	if err.Error() != exp {
+		t.Fatalf("expected \"%s\" got \"%s\"", exp, err)
+	}
+}
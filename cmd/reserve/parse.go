@@ -0,0 +1,72 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var parseNowStr string
+
+func init() {
+	parseCmd := &cobra.Command{
+		Use:     "parse <time specification>",
+		Aliases: []string{"timespec"},
+		Short:   "Show how a time specification is understood",
+		Long: `Show how a time specification is understood
+
+The specification is parsed as for the add command and the resulting
+start and end are shown along with the grammar rule that matched.
+Parse errors show the arguments as tokens with the offending token in
+brackets.
+
+Examples:
+
+	reserve parse noon tomorrow + 5 hours
+	reserve parse --now "2017-04-03 08:00" friday 11:30am to 4pm
+	reserve parse every weekday 08:00 until noon
+`,
+		RunE: parse,
+	}
+
+	parseCmd.Flags().StringVar(&parseNowStr, "now", "", "Parse relative to \"YYYY-MM-DD HH:MM\"")
+
+	RootCmd.AddCommand(parseCmd)
+}
+
+func parse(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("time specification not specified")
+	}
+
+	now := time.Now()
+
+	if parseNowStr != "" {
+		var err error
+		now, err = parseNow(parseNowStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return explainSpec(os.Stdout, now, args)
+}
+
+// print the rule matched and the ranges parsed from args
+func explainSpec(w io.Writer, now time.Time, args []string) error {
+	ranges, err := ParseRanges(now, args)
+	if err != nil {
+		highlightToken(w, args, err)
+		return fmt.Errorf("parsetime: %v", err)
+	}
+
+	fmt.Fprintf(w, "rule: %s\n", specRule(args))
+
+	return printRanges(w, ranges, false)
+}
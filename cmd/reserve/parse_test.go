@@ -0,0 +1,81 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExplainSpec(t *testing.T) {
+	tests := []struct {
+		args   string
+		output string
+	}{
+		{
+			args:   "+1day",
+			output: "rule: plustime\nMon Apr  3 08:00 EDT 2017 - Tue Apr  4 08:00 EDT 2017 (24h0m0s)\n",
+		},
+		{
+			args:   "to 5pm",
+			output: "rule: explicit_end\nMon Apr  3 08:00 EDT 2017 - Mon Apr  3 17:00 EDT 2017 (9h0m0s)\n",
+		},
+		{
+			args:   "noon tomorrow + 5 hours",
+			output: "rule: start_plus\nTue Apr  4 12:00 EDT 2017 - Tue Apr  4 17:00 EDT 2017 (5h0m0s)\n",
+		},
+		{
+			args:   "friday 11:30am to 4pm",
+			output: "rule: start_end\nFri Apr  7 11:30 EDT 2017 - Fri Apr  7 16:00 EDT 2017 (4h30m0s)\n",
+		},
+		{
+			args:   "noon",
+			output: "rule: timespec\nMon Apr  3 08:00 EDT 2017 - Mon Apr  3 12:00 EDT 2017 (4h0m0s)\n",
+		},
+		{
+			args: "weekdays 9am to 5pm for 2 days",
+			output: "rule: weekdays\n" +
+				"Mon Apr  3 09:00 EDT 2017 - Mon Apr  3 17:00 EDT 2017 (8h0m0s)\n" +
+				"Tue Apr  4 09:00 EDT 2017 - Tue Apr  4 17:00 EDT 2017 (8h0m0s)\n",
+		},
+	}
+
+	now, err := parseNow("2017-04-03 08:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.args, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := explainSpec(&out, now, strings.Split(tc.args, " "))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != tc.output {
+				t.Fatalf("expected \"%s\" got \"%s\"", tc.output, out.String())
+			}
+		})
+	}
+}
+
+func TestExplainSpecError(t *testing.T) {
+	now, err := parseNow("2017-04-03 08:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	err = explainSpec(&out, now, strings.Split("noon tomorrow to 5pm because", " "))
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+
+	if out.String() != "noon tomorrow to 5 pm [because] \n" {
+		t.Fatalf("expected highlighted token got \"%s\"", out.String())
+	}
+}
@@ -17,10 +17,11 @@ Grammar:
 
 	plus:         'plus' | '+' | 'for'
 	num:          [0-9]+
+	minute:       'm' | 'min' | 'mins' | 'minute' | 'minutes'
 	hour:         'h' | 'hour' | 'hours'
 	day:          'd' | 'day' | 'days'
 	week:         'w' | 'week' | 'weeks'
-	rel:          hour | day | week
+	rel:          minute | hour | day | week
 	duration:     number [ rel ]
 	dayname:      mon | tue | wed | thu ...
 	month:        jan | feb | mar | apr ...
@@ -32,7 +33,7 @@ Grammar:
 	ordinal:      nd | rd | st | th
 	datetime:     date time
 	longdate:     month num [ ordinal ] std_time [ yyyy ]
-	dayspec:      [ 'next' ] dayname time
+	dayspec:      [ 'this' | 'next' ] dayname time
 	tomorrow:     time 'tomorrow' | 'tomorrow' time
 	timespec:     time | longdate | datetime | tomorrow
 
@@ -40,6 +41,8 @@ Grammar:
 	explicit_end: ( until | to ) timespec
 	start_plus:   timespec plus duration
 	start_end:    timespec ( until | to ) timespec
+	weekdays:     [ 'every' ] ( 'weekdays' | 'weekday' ) time ( until | to ) time [ plus duration ]
+	pastspec:     'last' dayname [ time ] | 'yesterday' [ time ] | duration 'ago' | timespec
 
 	now           now
 	noon          12:00
@@ -53,6 +56,8 @@ Example time specifications
 	2
 	plus 5 days
 	now + 1 hour
+	now + 90 minutes
+	for 45m
 	6
 	NoW +1hour
 	15:00
@@ -63,6 +68,8 @@ Example time specifications
 	friday
 	friday 11:30am
 	friday 11:30pm
+	this friday
+	next friday 9am
 	2019-02-22
 	2019-02-22 7:45pm
 	april 1 11:59
@@ -75,10 +82,26 @@ Example time specifications
 	from noon tomorrow + 5 hours
 	noon tomorrow to 5pm tomorrow
 	from5:45PM to noon tomorrow
+	weekdays 9am to 5pm for 2 weeks
+	every weekday 08:00 until noon
+	last monday
+	yesterday 3pm
+	3 days ago
 
 Use of 'tomorrow' is relative to _now_ rather than the start date.
 
+A day name alone, or with 'this', is the nearest such day from today
+on. With 'next' it is the one in the following week, weeks starting on
+Sunday.
+
 End times without a date will be relative to the start time.
+
+Weekday recurrences produce one range per weekday, for one week unless
+a duration is given. Today is included only if its range has not yet
+started.
+
+A pastspec, used to scope history, looks backward: 'last' names the
+most recent such day before today, at midnight unless a time is given.
 */
 
 type token struct {
@@ -118,33 +141,47 @@ const (
 	TokRelDay
 	TokRelWeek
 	TokOrdinal
+	TokEvery
+	TokWeekdays
+	TokRelMinute
+	TokLast
+	TokYesterday
+	TokAgo
+	TokThis
 )
 
 var tokTypes = map[int]string{
-	TokNone:     "none",
-	TokText:     "text",
-	TokNumber:   "number",
-	TokTime:     "time",
-	TokPlus:     "plus",
-	TokNow:      "now",
-	TokNext:     "next",
-	TokFrom:     "from",
-	TokTo:       "to",
-	TokUntil:    "until",
-	TokFor:      "for",
-	TokDay:      "day",
-	TokMonth:    "month",
-	TokDate:     "date",
-	TokTomorrow: "tomorrow",
-	TokNoon:     "noon",
-	TokMidnight: "midnight",
-	TokEOD:      "eod",
-	TokAM:       "am",
-	TokPM:       "pm",
-	TokRelHour:  "hour",
-	TokRelDay:   "day",
-	TokRelWeek:  "week",
-	TokOrdinal:  "ord",
+	TokNone:      "none",
+	TokText:      "text",
+	TokNumber:    "number",
+	TokTime:      "time",
+	TokPlus:      "plus",
+	TokNow:       "now",
+	TokNext:      "next",
+	TokFrom:      "from",
+	TokTo:        "to",
+	TokUntil:     "until",
+	TokFor:       "for",
+	TokDay:       "day",
+	TokMonth:     "month",
+	TokDate:      "date",
+	TokTomorrow:  "tomorrow",
+	TokNoon:      "noon",
+	TokMidnight:  "midnight",
+	TokEOD:       "eod",
+	TokAM:        "am",
+	TokPM:        "pm",
+	TokRelHour:   "hour",
+	TokRelDay:    "day",
+	TokRelWeek:   "week",
+	TokOrdinal:   "ord",
+	TokEvery:     "every",
+	TokWeekdays:  "weekdays",
+	TokRelMinute: "minute",
+	TokLast:      "last",
+	TokYesterday: "yesterday",
+	TokAgo:       "ago",
+	TokThis:      "this",
 }
 
 var Text2Tok = map[string]int{
@@ -198,6 +235,11 @@ var Text2Tok = map[string]int{
 	"eod":       TokEOD,
 	"am":        TokAM,
 	"pm":        TokPM,
+	"m":         TokRelMinute,
+	"min":       TokRelMinute,
+	"mins":      TokRelMinute,
+	"minute":    TokRelMinute,
+	"minutes":   TokRelMinute,
 	"h":         TokRelHour,
 	"hour":      TokRelHour,
 	"hours":     TokRelHour,
@@ -211,6 +253,13 @@ var Text2Tok = map[string]int{
 	"rd":        TokOrdinal,
 	"st":        TokOrdinal,
 	"th":        TokOrdinal,
+	"every":     TokEvery,
+	"weekday":   TokWeekdays,
+	"weekdays":  TokWeekdays,
+	"last":      TokLast,
+	"yesterday": TokYesterday,
+	"ago":       TokAgo,
+	"this":      TokThis,
 }
 
 var Days = map[string]int{
@@ -444,6 +493,18 @@ func (s *fifo) Peek() (*token, error) {
 	return s.tokens[0], nil
 }
 
+// the token after next is am or pm, making a number before it an hour
+// of the day rather than a count
+func (s *fifo) meridiem() bool {
+	if len(s.tokens) < 2 {
+		return false
+	}
+
+	t := s.tokens[1].Type
+
+	return t == TokAM || t == TokPM
+}
+
 func (s *fifo) GetToken(toktype int) (*token, error) {
 	tok, err := s.Peek()
 	if err != nil {
@@ -557,6 +618,8 @@ func tokenize(args []string) (*fifo, error) {
 
 func isRelative(tok *token) bool {
 	switch tok.Type {
+	case TokRelMinute:
+		return true
 	case TokRelHour:
 		return true
 	case TokRelDay:
@@ -610,12 +673,14 @@ func parseRelativeDuration(tokens *fifo) (time.Duration, error) {
 
 	dur := 0
 	switch rel.Type {
-	case TokRelHour:
+	case TokRelMinute:
 		dur = num.Num
+	case TokRelHour:
+		dur = 60 * num.Num
 	case TokRelDay:
-		dur = 24 * num.Num
+		dur = 60 * 24 * num.Num
 	case TokRelWeek:
-		dur = 24 * 7 * num.Num
+		dur = 60 * 24 * 7 * num.Num
 	default:
 		return 0, &ParseError{
 			msg:     fmt.Sprintf("unsupported relative duration: %s", rel.Val),
@@ -624,7 +689,7 @@ func parseRelativeDuration(tokens *fifo) (time.Duration, error) {
 		}
 	}
 
-	d, err = time.ParseDuration(fmt.Sprintf("%dh", dur))
+	d, err = time.ParseDuration(fmt.Sprintf("%dm", dur))
 	if err != nil {
 		panic(fmt.Sprintf("ParseDuration failed: %v", err))
 	}
@@ -725,11 +790,15 @@ func (t *Time) AddDays(days int) *Time {
 	return t
 }
 
+// durations are added to the absolute instant, across a DST change the
+// wall clock moves by an hour more or less - the result is rounded to
+// RoundTo but never short of d
 func (t *Time) AddMinutes(d time.Duration) *Time {
-	ts := t.time.Add(d).Round(30 * time.Minute)
+	end := t.time.Add(d)
 
-	if ts.Sub(t.time) < d {
-		ts = t.time.Add(d)
+	ts := end.Round(roundTo())
+	if ts.Before(end) {
+		ts = end
 		roundUp(&ts)
 	}
 
@@ -737,14 +806,15 @@ func (t *Time) AddMinutes(d time.Duration) *Time {
 	return t
 }
 
+// as for AddMinutes the hours are added to the absolute instant
 func (t *Time) AddHours(hours int) *Time {
-	d := time.Duration(hours) * time.Hour
-
-	ts := t.time.Add(d)
+	return t.AddUnits(hours, time.Hour)
+}
 
-	if ts.Sub(t.time) < d {
-		ts = ts.Add(d)
-	}
+// add count of unit to the absolute instant, rounding up as for
+// AddHours
+func (t *Time) AddUnits(count int, unit time.Duration) *Time {
+	ts := t.time.Add(time.Duration(count) * unit)
 
 	roundUp(&ts)
 	t.time = ts
@@ -778,7 +848,7 @@ func (t *Time) Parse(tokens *fifo, timeOnly bool) (*Time, error) {
 			return t, err
 		}
 		t.Hour(ts.Hour).Minute(ts.Minute)
-	} else if !timeOnly && ts.Type == TokNumber {
+	} else if ts.Type == TokNumber && (!timeOnly || tokens.meridiem()) {
 		tokens.Pop()
 		t.Hour(ts.Num).Minute(0)
 	} else {
@@ -827,12 +897,45 @@ func (t *Time) String() string {
 	return t.Time().String()
 }
 
+// granularity ends computed from a duration are rounded to, set before
+// parsing - zero keeps the half hour
+var RoundTo time.Duration
+
+func roundTo() time.Duration {
+	if RoundTo <= 0 {
+		return 30 * time.Minute
+	}
+	return RoundTo
+}
+
+// round to the nearest RoundTo, going up from a minute short of half
+// way
 func roundUp(val *time.Time) {
+	g := roundTo()
 	t := *val
-	*val = t.Add(14 * time.Minute).Round(30 * time.Minute)
+	*val = t.Add(g/2 - time.Minute).Round(g)
+}
+
+// days from start to weekday day - the nearest one, today included, or
+// with next the one in the following week
+func dayDistance(start time.Time, day int, next bool) int {
+	today := int(start.Weekday())
+
+	if next {
+		return 7 - today + day
+	}
+
+	if day < today {
+		day += 7
+	}
+
+	return day - today
 }
 
-func parseTimeSpec(now time.Time, start time.Time, tokens *fifo) (*Time, error) {
+// a bare number is a count of DefaultUnit, "6" being six hours from now
+const DefaultUnit = time.Hour
+
+func parseTimeSpec(now time.Time, start time.Time, tokens *fifo, unit time.Duration) (*Time, error) {
 	var timespec *Time
 
 loop:
@@ -864,16 +967,31 @@ loop:
 
 		case TokDay:
 			// <day> [<time>]
-			day := Days[t.Val]
-			today := int(start.Weekday())
+			timespec = NewTime(start).AddDays(dayDistance(start, Days[t.Val], false))
 
-			if day < today {
-				day += 7
+			if _, err := timespec.Parse(tokens, TimeAndNumber); err != nil {
+				if perr, ok := err.(*ParseError); ok && !perr.EndOfInput() {
+					return nil, err
+				}
 			}
 
-			dist := day - today
+			break loop
+
+		case TokThis, TokNext:
+			// this|next <day> [<time>]
+			d, err := tokens.GetToken(TokDay)
+			if err != nil {
+				if perr, ok := err.(*ParseError); ok && perr.NotFound() {
+					return nil, &ParseError{
+						msg:     fmt.Sprintf("expect day name after %s", t.Val),
+						invalid: true,
+						token:   d,
+					}
+				}
+				return nil, err
+			}
 
-			timespec = NewTime(start).AddDays(dist)
+			timespec = NewTime(start).AddDays(dayDistance(start, Days[d.Val], t.Type == TokNext))
 
 			if _, err := timespec.Parse(tokens, TimeAndNumber); err != nil {
 				if perr, ok := err.(*ParseError); ok && !perr.EndOfInput() {
@@ -965,10 +1083,10 @@ loop:
 			// <number> [<am|pm>]
 			timespec = NewTime(start)
 
-			// handle original timespec - numeric count in hours
+			// handle original timespec - numeric count of unit
 			if _, err := tokens.Peek(); err != nil {
 				if perr, ok := err.(*ParseError); ok && perr.EndOfInput() {
-					timespec.AddHours(t.Num)
+					timespec.AddUnits(t.Num, unit)
 					break loop
 				}
 			}
@@ -1023,6 +1141,13 @@ loop:
 }
 
 func ParseRange(now time.Time, args []string) (time.Time, time.Time, error) {
+	return ParseRangeUnit(now, args, DefaultUnit)
+}
+
+// ParseRangeUnit parses a range as ParseRange does, with a bare number
+// counting unit rather than hours - "2" with a 30 minute unit is an
+// hour from now
+func ParseRangeUnit(now time.Time, args []string, unit time.Duration) (time.Time, time.Time, error) {
 	var (
 		start    time.Time
 		end      time.Time
@@ -1050,7 +1175,7 @@ again:
 		tokens.Pop()
 	}
 
-	tval, err := parseTimeSpec(now, now, tokens)
+	tval, err := parseTimeSpec(now, now, tokens, unit)
 	if err != nil {
 		return timespec, end, err
 	}
@@ -1087,7 +1212,7 @@ again:
 		tokens.Pop()
 	}
 
-	tval, err = parseTimeSpec(now, start, tokens)
+	tval, err = parseTimeSpec(now, start, tokens, unit)
 	if err != nil {
 		return start, end, err
 	}
@@ -1114,6 +1239,54 @@ again:
 	return start, end, nil
 }
 
+// BusinessHours is a daily window, as offsets from midnight, that
+// reservations must start and end within
+type BusinessHours struct {
+	Open  time.Duration
+	Close time.Duration
+}
+
+func (h BusinessHours) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return clock(h.Open) + "-" + clock(h.Close)
+}
+
+// check the time of day of t falls within the window
+func (h BusinessHours) check(what string, t time.Time) error {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	tod := t.Sub(midnight)
+
+	if tod < h.Open || tod > h.Close {
+		return &ParseError{
+			msg:     fmt.Sprintf("%s %s outside business hours %s", what, t.Format("15:04"), h),
+			invalid: true,
+		}
+	}
+
+	return nil
+}
+
+// ParseRangeWithin parses a range as ParseRange does, additionally
+// rejecting a start or end falling outside the business hours
+func ParseRangeWithin(now time.Time, args []string, hours BusinessHours) (time.Time, time.Time, error) {
+	start, end, err := ParseRange(now, args)
+	if err != nil {
+		return start, end, err
+	}
+
+	if err := hours.check("start", start); err != nil {
+		return start, end, err
+	}
+
+	if err := hours.check("end", end); err != nil {
+		return start, end, err
+	}
+
+	return start, end, nil
+}
+
 func ParseDuration(now time.Time, args []string) (time.Time, error) {
 	var end time.Time
 
@@ -1134,7 +1307,7 @@ func ParseDuration(now time.Time, args []string) (time.Time, error) {
 		tokens.Pop()
 	}
 
-	tval, err := parseTimeSpec(now, now, tokens)
+	tval, err := parseTimeSpec(now, now, tokens, DefaultUnit)
 	if err != nil {
 		return end, err
 	}
@@ -1147,3 +1320,282 @@ func ParseDuration(now time.Time, args []string) (time.Time, error) {
 
 	return end, nil
 }
+
+// ParseRanges parses a time specification that may recur, returning
+// a start/end pair for each occurrence. Specifications that don't
+// recur return the single range from ParseRange.
+func ParseRanges(now time.Time, args []string) ([][2]time.Time, error) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+
+	tokens.GetToken(TokEvery)
+
+	if _, err := tokens.GetToken(TokWeekdays); err != nil {
+		start, end, err := ParseRange(now, args)
+		if err != nil {
+			return nil, err
+		}
+		return [][2]time.Time{{start, end}}, nil
+	}
+
+	first, err := NewTime(now).Parse(tokens, TimeAndNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := tokens.Pop()
+	if err != nil {
+		return nil, fmt.Errorf("missing end time")
+	}
+
+	if t.Type != TokTo && t.Type != TokUntil {
+		return nil, &ParseError{
+			msg:     "missing separator between start and end",
+			invalid: true,
+			token:   t,
+		}
+	}
+
+	last, err := NewTime(now).Parse(tokens, TimeAndNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	days := 7
+
+	if t, err := tokens.Peek(); err == nil && (t.Type == TokFor || t.Type == TokPlus) {
+		tokens.Pop()
+
+		d, err := parseRelativeDuration(tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		days = int(d / (24 * time.Hour))
+		if days < 1 {
+			return nil, &ParseError{
+				msg:     "recurrence shorter than a day",
+				invalid: true,
+				token:   t,
+			}
+		}
+	}
+
+	if t, err := tokens.Peek(); err == nil {
+		return nil, &ParseError{
+			msg:     "extra arguments beyond timespec",
+			invalid: true,
+			token:   t,
+		}
+	}
+
+	from := first.Time()
+	to := last.Time()
+
+	if !to.After(from) {
+		return nil, fmt.Errorf("end before start")
+	}
+
+	ranges := make([][2]time.Time, 0)
+
+	for i := 0; i < days; i++ {
+		day := NewTime(now).AddDays(i)
+
+		switch day.Time().Weekday() {
+		case time.Saturday, time.Sunday:
+			continue
+		}
+
+		start := NewTime(day.Time()).Hour(from.Hour()).Minute(from.Minute()).Time()
+		end := NewTime(day.Time()).Hour(to.Hour()).Minute(to.Minute()).Time()
+
+		// today's range already started
+		if start.Before(now) {
+			continue
+		}
+
+		ranges = append(ranges, [2]time.Time{start, end})
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no weekdays in range")
+	}
+
+	return ranges, nil
+}
+
+// name the grammar rule a time specification is parsed with, following
+// the same leading tokens ParseRanges and ParseRange dispatch on
+func specRule(args []string) string {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return ""
+	}
+
+	tokens.GetToken(TokEvery)
+
+	if _, err := tokens.GetToken(TokWeekdays); err == nil {
+		return "weekdays"
+	}
+
+	for {
+		if _, err := tokens.GetToken(TokFrom); err != nil {
+			break
+		}
+	}
+
+	t, err := tokens.Pop()
+	if err != nil {
+		return ""
+	}
+
+	switch t.Type {
+	case TokPlus:
+		return "plustime"
+	case TokTo, TokUntil:
+		return "explicit_end"
+	}
+
+	for _, t := range tokens.tokens {
+		switch t.Type {
+		case TokTo, TokUntil:
+			return "start_end"
+		case TokPlus, TokFor:
+			return "start_plus"
+		}
+	}
+
+	return "timespec"
+}
+
+// show the arguments as tokens with the one a parse error refers to
+// in brackets, errors without a token print nothing
+func highlightToken(w io.Writer, args []string, err error) {
+	perr, ok := err.(*ParseError)
+	if !ok || perr.token == nil {
+		return
+	}
+
+	tokens, _ := tokenize(args)
+	for i, t := range tokens.tokens {
+		if perr.token.count == i+1 {
+			fmt.Fprintf(w, "[%s] ", t.Val)
+		} else {
+			fmt.Fprintf(w, "%s ", t.Val)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// ParseTime parses a single time specification. Unlike ParseRange the
+// time may be in the past.
+func ParseTime(now time.Time, args []string) (time.Time, error) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v", err)
+	}
+
+	tval, err := parseTimeSpec(now, now, tokens, DefaultUnit)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if t, err := tokens.Peek(); err == nil {
+		return time.Time{}, &ParseError{
+			msg:     "extra arguments beyond timespec",
+			invalid: true,
+			token:   t,
+		}
+	}
+
+	return tval.Time(), nil
+}
+
+// ParsePastTime parses a pastspec, a time looking back from now. Any
+// other time specification is handled as by ParseTime.
+func ParsePastTime(now time.Time, args []string) (time.Time, error) {
+	tokens, err := tokenize(args)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v", err)
+	}
+
+	t, err := tokens.Peek()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	var tval *Time
+
+	switch t.Type {
+	case TokLast:
+		// last <day> [<time>]
+		tokens.Pop()
+
+		d, err := tokens.GetToken(TokDay)
+		if err != nil {
+			if perr, ok := err.(*ParseError); ok && perr.NotFound() {
+				return time.Time{}, &ParseError{
+					msg:     "expect day name after last",
+					invalid: true,
+					token:   d,
+				}
+			}
+			return time.Time{}, err
+		}
+
+		dist := int(now.Weekday()) - Days[d.Val]
+		if dist <= 0 {
+			dist += 7
+		}
+
+		tval = NewTime(midnight).AddDays(-dist)
+
+		if _, err := tval.Parse(tokens, TimeAndNumber); err != nil {
+			if perr, ok := err.(*ParseError); ok && !perr.EndOfInput() {
+				return time.Time{}, err
+			}
+		}
+
+	case TokYesterday:
+		// yesterday [<time>]
+		tokens.Pop()
+
+		tval = NewTime(midnight).AddDays(-1)
+
+		if _, err := tval.Parse(tokens, TimeAndNumber); err != nil {
+			if perr, ok := err.(*ParseError); ok && !perr.EndOfInput() {
+				return time.Time{}, err
+			}
+		}
+
+	default:
+		last := tokens.tokens[len(tokens.tokens)-1]
+		if last.Type != TokAgo {
+			return ParseTime(now, args)
+		}
+
+		// <duration> ago
+		d, err := parseRelativeDuration(tokens)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		tokens.GetToken(TokAgo)
+
+		tval = NewTime(now.Add(-d))
+	}
+
+	if t, err := tokens.Peek(); err == nil {
+		return time.Time{}, &ParseError{
+			msg:     "extra arguments beyond timespec",
+			invalid: true,
+			token:   t,
+		}
+	}
+
+	return tval.Time(), nil
+}
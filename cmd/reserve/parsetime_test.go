@@ -40,6 +40,23 @@ func TestParseTime(t *testing.T) {
 			args: "now + 1 hour",
 			time: "2017-04-02 01:00:00 -0400 EDT",
 		},
+		{
+			name: "now minute increment",
+			args: "now + 90 minutes",
+			time: "2017-04-02 01:30:00 -0400 EDT",
+		},
+		{
+			name: "short minute increment",
+			args: "for 45m",
+			now:  "2017-04-01 08:00:00 -0400 EDT",
+			time: "2017-04-01 09:00:00 -0400 EDT",
+		},
+		{
+			name: "minute increment rounds up",
+			args: "+20min",
+			now:  "2017-04-01 08:05:00 -0400 EDT",
+			time: "2017-04-01 08:30:00 -0400 EDT",
+		},
 		{
 			name: "increment default to hour increment",
 			args: "6",
@@ -112,6 +129,16 @@ func TestParseTime(t *testing.T) {
 			args: "september 2nd 11:59pm",
 			time: "2017-09-02 23:59:00 -0400 EDT",
 		},
+		{
+			name: "month day ordinal hour am",
+			args: "october 15th 9am",
+			time: "2017-10-15 09:00:00 -0400 EDT",
+		},
+		{
+			name: "month day hour pm",
+			args: "april 1 9pm",
+			time: "2017-04-01 21:00:00 -0400 EDT",
+		},
 		{
 			name: "month day ordinal time year",
 			args: "july 4rd 11:59 2018",
@@ -181,7 +208,7 @@ func TestParseTime(t *testing.T) {
 				t.Fatalf("tokenize: %v", err)
 			}
 
-			tval, err := parseTimeSpec(now, now, tokens)
+			tval, err := parseTimeSpec(now, now, tokens, DefaultUnit)
 			if err != nil {
 				if perr, ok := err.(*ParseError); ok {
 					tokens, _ := tokenize(strings.Split(tc.args, " "))
@@ -359,7 +386,8 @@ func TestParseArgs(t *testing.T) {
 		{
 			name:  "October 15th 9am",
 			args:  "October 15th 9am",
-			error: `expected time, got "9"`,
+			start: "2017-04-01 23:47:00 -0400 EDT",
+			end:   "2017-10-15 09:00:00 -0400 EDT",
 		},
 		{
 			name:  "May 2nd 9:00 for 7 hours",
@@ -429,6 +457,217 @@ func TestParseArgs(t *testing.T) {
 	}
 }
 
+func TestParseRanges(t *testing.T) {
+	const DefaultNow = "2017-04-01 23:47:00.000000000 -0400 EDT"
+
+	tests := []struct {
+		name  string
+		args  string
+		now   string
+		count int
+		start string
+		end   string
+		error string
+	}{
+		{
+			name:  "not recurring",
+			args:  "+1day",
+			count: 1,
+			start: "2017-04-01 23:47:00 -0400 EDT",
+			end:   "2017-04-03 00:00:00 -0400 EDT",
+		},
+		{
+			name:  "weekdays from monday",
+			now:   "2017-04-03 08:00:00 -0400 EDT",
+			args:  "weekdays 9am to 5pm for 2 weeks",
+			count: 10,
+			start: "2017-04-03 09:00:00 -0400 EDT",
+			end:   "2017-04-14 17:00:00 -0400 EDT",
+		},
+		{
+			name:  "every weekday from saturday",
+			args:  "every weekday 08:00 until noon",
+			count: 5,
+			start: "2017-04-03 08:00:00 -0400 EDT",
+			end:   "2017-04-07 12:00:00 -0400 EDT",
+		},
+		{
+			name:  "today already started",
+			now:   "2017-04-03 10:00:00 -0400 EDT",
+			args:  "weekdays 9am to 5pm",
+			count: 4,
+			start: "2017-04-04 09:00:00 -0400 EDT",
+			end:   "2017-04-07 17:00:00 -0400 EDT",
+		},
+		{
+			name:  "end before start",
+			args:  "weekdays 5pm to 9am",
+			error: "end before start",
+		},
+		{
+			name:  "missing separator",
+			args:  "weekdays 9am 5pm",
+			error: "missing separator between start and end",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.now == "" {
+				tc.now = DefaultNow
+			}
+
+			now, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", tc.now)
+			if err != nil {
+				t.Fatalf("time parse: %v", err)
+			}
+
+			ranges, err := ParseRanges(now, strings.Split(tc.args, " "))
+			if err != nil {
+				if tc.error != err.Error() {
+					t.Fatalf("Error exp \"%s\" got \"%s\"\n", tc.error, err.Error())
+				}
+				return
+			}
+
+			if tc.error != "" {
+				t.Fatalf("Error exp \"%s\" got none\n", tc.error)
+			}
+
+			if len(ranges) != tc.count {
+				t.Fatalf("Count exp %d got %d\n", tc.count, len(ranges))
+			}
+
+			for _, r := range ranges {
+				switch r[0].Weekday() {
+				case time.Saturday, time.Sunday:
+					if tc.count > 1 {
+						t.Fatalf("weekend range %s", r[0])
+					}
+				}
+			}
+
+			startstr := ranges[0][0].String()
+			if tc.start != startstr {
+				t.Fatalf("Start exp \"%s\" got \"%s\"\n", tc.start, startstr)
+			}
+
+			endstr := ranges[len(ranges)-1][1].String()
+			if tc.end != endstr {
+				t.Fatalf("End exp \"%s\" got \"%s\"\n", tc.end, endstr)
+			}
+		})
+	}
+}
+
+func TestParseTimeSpecOnly(t *testing.T) {
+	now, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2017-04-01 23:47:00 -0400 EDT")
+
+	tests := []struct {
+		args  string
+		time  string
+		error string
+	}{
+		{args: "2017-03-01 08:00", time: "2017-03-01 08:00:00 -0500 EST"},
+		{args: "tomorrow 8am", time: "2017-04-02 08:00:00 -0400 EDT"},
+		{args: "8am tomorrow for", error: "extra arguments beyond timespec"},
+	}
+
+	for _, tc := range tests {
+		tval, err := ParseTime(now, strings.Split(tc.args, " "))
+		if err != nil {
+			if tc.error != err.Error() {
+				t.Fatalf("Error exp \"%s\" got \"%s\"\n", tc.error, err.Error())
+			}
+			continue
+		}
+
+		if tc.time != tval.String() {
+			t.Fatalf("Time exp \"%s\" got \"%s\"\n", tc.time, tval.String())
+		}
+	}
+}
+
+func TestParseTimeNextDay(t *testing.T) {
+	// a wednesday
+	now, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2017-04-05 10:20:00 -0400 EDT")
+
+	tests := []struct {
+		args  string
+		time  string
+		error string
+	}{
+		{args: "friday", time: "2017-04-07 10:20:00 -0400 EDT"},
+		{args: "this friday", time: "2017-04-07 10:20:00 -0400 EDT"},
+		{args: "next friday", time: "2017-04-14 10:20:00 -0400 EDT"},
+		{args: "next friday 9am", time: "2017-04-14 09:00:00 -0400 EDT"},
+		{args: "wednesday", time: "2017-04-05 10:20:00 -0400 EDT"},
+		{args: "next wednesday", time: "2017-04-12 10:20:00 -0400 EDT"},
+		{args: "monday", time: "2017-04-10 10:20:00 -0400 EDT"},
+		{args: "next monday", time: "2017-04-10 10:20:00 -0400 EDT"},
+		{args: "next sunday", time: "2017-04-09 10:20:00 -0400 EDT"},
+		{args: "next noon", error: "expect day name after next"},
+	}
+
+	for _, tc := range tests {
+		tval, err := ParseTime(now, strings.Split(tc.args, " "))
+		if err != nil {
+			if tc.error != err.Error() {
+				t.Fatalf("%s: error exp \"%s\" got \"%s\"\n", tc.args, tc.error, err.Error())
+			}
+			continue
+		}
+
+		if tc.error != "" {
+			t.Fatalf("%s: expected error \"%s\"", tc.args, tc.error)
+		}
+
+		if tc.time != tval.String() {
+			t.Fatalf("%s: time exp \"%s\" got \"%s\"\n", tc.args, tc.time, tval.String())
+		}
+	}
+}
+
+func TestParsePastTime(t *testing.T) {
+	// a wednesday
+	now, _ := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", "2017-04-05 10:20:00 -0400 EDT")
+
+	tests := []struct {
+		args  string
+		time  string
+		error string
+	}{
+		{args: "last monday", time: "2017-04-03 00:00:00 -0400 EDT"},
+		{args: "last wed", time: "2017-03-29 00:00:00 -0400 EDT"},
+		{args: "last friday 3pm", time: "2017-03-31 15:00:00 -0400 EDT"},
+		{args: "yesterday", time: "2017-04-04 00:00:00 -0400 EDT"},
+		{args: "yesterday noon", time: "2017-04-04 12:00:00 -0400 EDT"},
+		{args: "3 days ago", time: "2017-04-02 10:20:00 -0400 EDT"},
+		{args: "2h ago", time: "2017-04-05 08:20:00 -0400 EDT"},
+		{args: "2017-03-01 08:00", time: "2017-03-01 08:00:00 -0500 EST"},
+		{args: "last week", error: "expect day name after last"},
+		{args: "yesterday 3pm please", error: "extra arguments beyond timespec"},
+	}
+
+	for _, tc := range tests {
+		tval, err := ParsePastTime(now, strings.Split(tc.args, " "))
+		if err != nil {
+			if tc.error != err.Error() {
+				t.Fatalf("%s: error exp \"%s\" got \"%s\"\n", tc.args, tc.error, err.Error())
+			}
+			continue
+		}
+
+		if tc.error != "" {
+			t.Fatalf("%s: expected error \"%s\"", tc.args, tc.error)
+		}
+
+		if tc.time != tval.String() {
+			t.Fatalf("%s: time exp \"%s\" got \"%s\"\n", tc.args, tc.time, tval.String())
+		}
+	}
+}
+
 func TestLeapYear(t *testing.T) {
 	years := []struct {
 		year int
@@ -484,3 +723,258 @@ func TestDateValid(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTimeDST(t *testing.T) {
+	tests := []struct {
+		now  string
+		args string
+		time string
+		dur  time.Duration
+	}{
+		{now: "2017-11-05 01:30:00 -0400 EDT", args: "2", time: "2017-11-05 02:30:00 -0500 EST", dur: 2 * time.Hour},
+		{now: "2017-11-05 01:30:00 -0400 EDT", args: "now + 2 hours", time: "2017-11-05 02:30:00 -0500 EST", dur: 2 * time.Hour},
+		{now: "2017-11-05 00:30:00 -0400 EDT", args: "+90m", time: "2017-11-05 01:00:00 -0500 EST", dur: 90 * time.Minute},
+		{now: "2017-03-12 01:30:00 -0500 EST", args: "2", time: "2017-03-12 04:30:00 -0400 EDT", dur: 2 * time.Hour},
+		{now: "2017-03-12 01:30:00 -0500 EST", args: "now + 2 hours", time: "2017-03-12 04:30:00 -0400 EDT", dur: 2 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.now+" "+tc.args, func(t *testing.T) {
+			now, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", tc.now)
+			if err != nil {
+				t.Fatalf("time parse: %v", err)
+			}
+
+			tokens, err := tokenize(strings.Split(tc.args, " "))
+			if err != nil {
+				t.Fatalf("tokenize: %v", err)
+			}
+
+			tval, err := parseTimeSpec(now, now, tokens, DefaultUnit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tval.String() != tc.time {
+				t.Fatalf("Time exp \"%s\" got \"%s\"\n", tc.time, tval.String())
+			}
+
+			if d := tval.Time().Sub(now); d != tc.dur {
+				t.Fatalf("Duration exp %s got %s\n", tc.dur, d)
+			}
+		})
+	}
+}
+
+func TestParseRangeWithin(t *testing.T) {
+	const DefaultNow = "2017-04-03 06:30:00.000000000 -0400 EDT"
+
+	hours := BusinessHours{Open: 7 * time.Hour, Close: 19 * time.Hour}
+
+	tests := []struct {
+		name  string
+		args  string
+		start string
+		end   string
+		error string
+	}{
+		{
+			name:  "inside",
+			args:  "9am to 5pm",
+			start: "2017-04-03 09:00:00 -0400 EDT",
+			end:   "2017-04-03 17:00:00 -0400 EDT",
+		},
+		{
+			name:  "edges",
+			args:  "7am to 7pm",
+			start: "2017-04-03 07:00:00 -0400 EDT",
+			end:   "2017-04-03 19:00:00 -0400 EDT",
+		},
+		{
+			name:  "synonyms",
+			args:  "noon to eod",
+			start: "2017-04-03 12:00:00 -0400 EDT",
+			end:   "2017-04-03 17:00:00 -0400 EDT",
+		},
+		{
+			name:  "start before open",
+			args:  "06:45 to noon",
+			error: "start 06:45 outside business hours 07:00-19:00",
+		},
+		{
+			name:  "end after close",
+			args:  "noon + 8 hours",
+			error: "end 20:00 outside business hours 07:00-19:00",
+		},
+		{
+			name:  "midnight end",
+			args:  "eod to midnight tomorrow",
+			error: "end 00:00 outside business hours 07:00-19:00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			now, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", DefaultNow)
+			if err != nil {
+				t.Fatalf("time parse: %v", err)
+			}
+
+			start, end, err := ParseRangeWithin(now, strings.Split(tc.args, " "), hours)
+			if err != nil {
+				if tc.error != err.Error() {
+					t.Fatalf("Error exp \"%s\" got \"%s\"\n", tc.error, err.Error())
+				}
+				return
+			}
+
+			if tc.error != "" {
+				t.Fatalf("Error exp \"%s\" got none\n", tc.error)
+			}
+
+			if tc.start != start.String() {
+				t.Fatalf("Start exp \"%s\" got \"%s\"\n", tc.start, start.String())
+			}
+
+			if tc.end != end.String() {
+				t.Fatalf("End exp \"%s\" got \"%s\"\n", tc.end, end.String())
+			}
+		})
+	}
+}
+
+func TestParseRangeUnit(t *testing.T) {
+	const DefaultNow = "2017-04-01 07:58:00.000000000 -0400 EDT"
+
+	tests := []struct {
+		name  string
+		args  string
+		unit  time.Duration
+		start string
+		end   string
+	}{
+		{
+			name:  "default hours",
+			args:  "6",
+			unit:  DefaultUnit,
+			start: "2017-04-01 07:58:00 -0400 EDT",
+			end:   "2017-04-01 14:00:00 -0400 EDT",
+		},
+		{
+			name:  "half hour slots",
+			args:  "2",
+			unit:  30 * time.Minute,
+			start: "2017-04-01 07:58:00 -0400 EDT",
+			end:   "2017-04-01 09:00:00 -0400 EDT",
+		},
+		{
+			name:  "one slot",
+			args:  "1",
+			unit:  30 * time.Minute,
+			start: "2017-04-01 07:58:00 -0400 EDT",
+			end:   "2017-04-01 08:30:00 -0400 EDT",
+		},
+		{
+			name:  "slots after a start",
+			args:  "10am to 2",
+			unit:  30 * time.Minute,
+			start: "2017-04-01 10:00:00 -0400 EDT",
+			end:   "2017-04-01 11:00:00 -0400 EDT",
+		},
+		{
+			name:  "hours after a start",
+			args:  "10am to 2",
+			unit:  DefaultUnit,
+			start: "2017-04-01 10:00:00 -0400 EDT",
+			end:   "2017-04-01 12:00:00 -0400 EDT",
+		},
+		{
+			name:  "explicit duration",
+			args:  "9am + 2 hours",
+			unit:  30 * time.Minute,
+			start: "2017-04-01 09:00:00 -0400 EDT",
+			end:   "2017-04-01 11:00:00 -0400 EDT",
+		},
+		{
+			name:  "number with pm",
+			args:  "3pm",
+			unit:  30 * time.Minute,
+			start: "2017-04-01 07:58:00 -0400 EDT",
+			end:   "2017-04-01 15:00:00 -0400 EDT",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			now, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", DefaultNow)
+			if err != nil {
+				t.Fatalf("time parse: %v", err)
+			}
+
+			start, end, err := ParseRangeUnit(now, strings.Split(tc.args, " "), tc.unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.start != start.String() {
+				t.Fatalf("Start exp \"%s\" got \"%s\"\n", tc.start, start.String())
+			}
+
+			if tc.end != end.String() {
+				t.Fatalf("End exp \"%s\" got \"%s\"\n", tc.end, end.String())
+			}
+
+			if tc.unit != DefaultUnit {
+				return
+			}
+
+			// the default unit leaves ParseRange as it was
+			s, e, err := ParseRange(now, strings.Split(tc.args, " "))
+			if err != nil || !s.Equal(start) || !e.Equal(end) {
+				t.Fatalf("ParseRange gave %s - %s, %v", s, e, err)
+			}
+		})
+	}
+}
+
+func TestParseRoundTo(t *testing.T) {
+	defer func(r time.Duration) { RoundTo = r }(RoundTo)
+
+	const DefaultNow = "2017-04-01 08:07:00.000000000 -0400 EDT"
+
+	tests := []struct {
+		name    string
+		args    string
+		roundTo time.Duration
+		end     string
+	}{
+		{name: "unset plus", args: "now + 1 hour", end: "2017-04-01 09:30:00 -0400 EDT"},
+		{name: "unset for", args: "for 7 hours", end: "2017-04-01 15:30:00 -0400 EDT"},
+		{name: "half hour plus", args: "now + 1 hour", roundTo: 30 * time.Minute, end: "2017-04-01 09:30:00 -0400 EDT"},
+		{name: "quarter plus", args: "now + 1 hour", roundTo: 15 * time.Minute, end: "2017-04-01 09:15:00 -0400 EDT"},
+		{name: "quarter for", args: "for 7 hours", roundTo: 15 * time.Minute, end: "2017-04-01 15:15:00 -0400 EDT"},
+		{name: "quarter bare number", args: "2", roundTo: 15 * time.Minute, end: "2017-04-01 10:15:00 -0400 EDT"},
+		{name: "hour for", args: "for 7 hours", roundTo: time.Hour, end: "2017-04-01 16:00:00 -0400 EDT"},
+		{name: "hour plus minutes", args: "now + 90 minutes", roundTo: time.Hour, end: "2017-04-01 10:00:00 -0400 EDT"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			RoundTo = tc.roundTo
+
+			now, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", DefaultNow)
+			if err != nil {
+				t.Fatalf("time parse: %v", err)
+			}
+
+			_, end, err := ParseRange(now, strings.Split(tc.args, " "))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.end != end.String() {
+				t.Fatalf("End exp \"%s\" got \"%s\"\n", tc.end, end.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	renameCmd := &cobra.Command{
+		Use:   "rename <resource> <new resource name>",
+		Short: "Rename a resource across reservations",
+		Long: `Rename a resource across all active, future and loaned reservations
+
+The rename is refused if any reservation would conflict with one already
+held under the new name. Only admins may rename.
+`,
+		RunE: rename,
+	}
+
+	RootCmd.AddCommand(renameCmd)
+}
+
+func rename(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return errors.New("resource and/or new name not specified")
+	}
+
+	req := struct {
+		Command string `json:"command"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+	}{
+		Command: "rename",
+		From:    args[0],
+		To:      args[1],
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal %v", err)
+	}
+
+	b := bytes.NewReader(data)
+
+	service.Path = V3api + "command"
+
+	r, err := http.NewRequest(http.MethodPost, service.String(), b)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	setUser(cmd, r)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusBadRequest, http.StatusForbidden, http.StatusConflict:
+	default:
+		return fmt.Errorf("response status %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status       string         `json:"status"`
+		Error        string         `json:"error"`
+		Reservations []*Reservation `json:"reservations"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return fmt.Errorf("error: %s", rpy.Error)
+	}
+
+	for _, res := range rpy.Reservations {
+		fmt.Printf("Renamed reservation %d\n", res.ID)
+	}
+
+	fmt.Printf("Renamed %d reservations from %s to %s\n", len(rpy.Reservations), args[0], args[1])
+
+	return nil
+}
@@ -3,13 +3,19 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	. "github.com/dbulkow/reservations/api"
+	"github.com/dbulkow/reservations/internal/buildinfo"
 	"github.com/spf13/cobra"
 )
 
@@ -72,21 +78,93 @@ func main() {
 	RootCmd.PersistentFlags().StringVar(&addr, "url", addr, "URL for reservation service")
 	RootCmd.PersistentFlags().StringVar(&config, "config", config, "config file")
 
+	var withServer bool
+
 	versionCmd := &cobra.Command{
 		Use:   "version",
 		Short: "Display git hash and build data",
-		Long:  "Display git hash and build data",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("Git Commit Hash: %s\n", GitHash)
-			fmt.Printf("Build Time:      %s\n", BuildTime)
+		Long: `Display git hash and build data
+
+With --server the version of the reservation server is shown as well,
+with a warning when the client was built from a different commit.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hash, built := buildinfo.Version(GitHash, BuildTime)
+
+			fmt.Printf("Git Commit Hash: %s\n", hash)
+			fmt.Printf("Build Time:      %s\n", built)
+
+			if !withServer {
+				return nil
+			}
+
+			shash, sbuilt, err := serverVersion()
+			if err != nil {
+				return fmt.Errorf("server version: %v", err)
+			}
+
+			fmt.Println()
+			fmt.Printf("Server Commit Hash: %s\n", shash)
+			fmt.Printf("Server Build Time:  %s\n", sbuilt)
+
+			if versionsDiffer(hash, shash) {
+				fmt.Fprintln(os.Stderr, "warning: client and server were built from different commits")
+			}
+
+			return nil
 		},
 	}
 
+	versionCmd.Flags().BoolVar(&withServer, "server", false, "Show the server version too, warning on a mismatch")
+
 	RootCmd.AddCommand(versionCmd)
 
 	err := RootCmd.Execute()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return
+		os.Exit(1)
+	}
+}
+
+// fetch the git hash and build time of the server
+func serverVersion() (string, string, error) {
+	service.Path = V3version
+
+	resp, err := client.Get(service.String())
+	if err != nil {
+		return "", "", fmt.Errorf("http: %v", err)
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	rpy := struct {
+		GitHash   string `json:"gitHash"`
+		BuildTime string `json:"buildTime"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return "", "", fmt.Errorf("decode: %v", err)
 	}
+
+	if rpy.GitHash == "" {
+		return "", "", errors.New("server gave no version")
+	}
+
+	return rpy.GitHash, rpy.BuildTime, nil
+}
+
+// builds of unknown origin can't be compared, so aren't said to differ
+func versionsDiffer(client, server string) bool {
+	if client == "unknown" || server == "unknown" {
+		return false
+	}
+
+	return client != server
 }
@@ -0,0 +1,68 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/dbulkow/reservations/api"
+)
+
+func TestServerVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != V3version {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"gitHash":   "github.com/dbulkow/reservations/commit/abc",
+			"buildTime": "2021-04-05 10:00:00AM UTC",
+		})
+	}))
+	defer srv.Close()
+
+	saved := service
+	defer func() { service = saved }()
+	service, _ = url.Parse(srv.URL)
+
+	hash, built, err := serverVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != "github.com/dbulkow/reservations/commit/abc" || built != "2021-04-05 10:00:00AM UTC" {
+		t.Fatalf("unexpected server version %s %s", hash, built)
+	}
+
+	srv.Close()
+
+	_, _, err = serverVersion()
+	if err == nil {
+		t.Fatal("expected error with the server down")
+	}
+}
+
+func TestVersionsDiffer(t *testing.T) {
+	tests := []struct {
+		client string
+		server string
+		differ bool
+	}{
+		{"commit/abc", "commit/abc", false},
+		{"commit/abc", "commit/def", true},
+		{"unknown", "commit/def", false},
+		{"commit/abc", "unknown", false},
+	}
+
+	for _, tc := range tests {
+		if versionsDiffer(tc.client, tc.server) != tc.differ {
+			t.Fatalf("%s and %s: expected differ %t", tc.client, tc.server, tc.differ)
+		}
+	}
+}
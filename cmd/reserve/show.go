@@ -0,0 +1,101 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	. "github.com/dbulkow/reservations/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	showCmd := &cobra.Command{
+		Use:     "show <resource id number>",
+		Aliases: []string{"get"},
+		Short:   "Show a reservation",
+		Long:    "Show a single reservation in long format",
+		RunE:    show,
+	}
+
+	showCmd.Flags().BoolVarP(&jsonOutput, "json", "j", false, "JSON output")
+
+	RootCmd.AddCommand(showCmd)
+}
+
+func show(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return errors.New("resource id not specified")
+	}
+
+	resid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	service.Path = fmt.Sprintf("%s%d", V3api, resid)
+
+	r, err := http.NewRequest(http.MethodGet, service.String(), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	if resp == nil {
+		return fmt.Errorf("empty response")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MaxRead))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("reservation %d not found", resid)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	rpy := struct {
+		Status      string       `json:"status"`
+		Error       string       `json:"error"`
+		Reservation *Reservation `json:"reservation"`
+	}{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, MaxRead)).Decode(&rpy)
+	if err != nil {
+		return fmt.Errorf("decode: %v", err)
+	}
+
+	if rpy.Status != "Success" {
+		return errors.New(rpy.Error)
+	}
+
+	if rpy.Reservation == nil {
+		return errors.New("empty reservation in response")
+	}
+
+	if jsonOutput {
+		b, err := json.Marshal(rpy.Reservation)
+		if err != nil {
+			return fmt.Errorf("unable to marshal output %v", err)
+		}
+
+		fmt.Println(string(b))
+		return nil
+	}
+
+	printLong(rpy.Reservation, "Jan _2 15:04 2006", 0)
+
+	return nil
+}
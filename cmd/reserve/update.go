@@ -147,6 +147,10 @@ func update(cmd *cobra.Command, args []string) error {
 	}
 	r.Header.Set("Content-Type", "application/merge-patch+json")
 	r.Header.Set("If-Unmodified-Since", resp.Header.Get("Last-Modified"))
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-Match", etag)
+	}
+	setUser(cmd, r)
 
 	if false {
 		in, err := httputil.DumpRequest(r, true)
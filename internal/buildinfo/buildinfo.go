@@ -0,0 +1,50 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package buildinfo
+
+import (
+	"runtime/debug"
+)
+
+// Version returns the git hash and build time written by version.sh, or
+// when the script wasn't run, what the go tool recorded in the binary
+func Version(hash, built string) (string, string) {
+	return version(hash, built, debug.ReadBuildInfo)
+}
+
+func version(hash, built string, read func() (*debug.BuildInfo, bool)) (string, string) {
+	if hash != "" && built != "" {
+		return hash, built
+	}
+
+	info, ok := read()
+	if !ok {
+		return orUnknown(hash), orUnknown(built)
+	}
+
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if hash == "" {
+				hash = info.Main.Path + "/commit/" + s.Value
+			}
+		case "vcs.time":
+			if built == "" {
+				built = s.Value
+			}
+		}
+	}
+
+	if hash == "" && info.Main.Version != "" {
+		hash = info.Main.Path + "@" + info.Main.Version
+	}
+
+	return orUnknown(hash), orUnknown(built)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
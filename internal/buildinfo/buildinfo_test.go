@@ -0,0 +1,53 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package buildinfo
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	info := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/dbulkow/reservations", Version: "v1.2.3"},
+	}
+
+	vcs := &debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/dbulkow/reservations", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "5e266ff2826f7c4370c8de20a90054b07ae2431f"},
+			{Key: "vcs.time", Value: "2021-04-01T08:00:00Z"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		hash  string
+		built string
+		info  *debug.BuildInfo
+		exph  string
+		expb  string
+	}{
+		{name: "generated", hash: "abc", built: "yesterday", info: info, exph: "abc", expb: "yesterday"},
+		{name: "module", info: info, exph: "github.com/dbulkow/reservations@v1.2.3", expb: "unknown"},
+		{name: "vcs", info: vcs, exph: "github.com/dbulkow/reservations/commit/5e266ff2826f7c4370c8de20a90054b07ae2431f", expb: "2021-04-01T08:00:00Z"},
+		{name: "none", exph: "unknown", expb: "unknown"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			read := func() (*debug.BuildInfo, bool) { return tc.info, tc.info != nil }
+
+			hash, built := version(tc.hash, tc.built, read)
+
+			if hash != tc.exph || built != tc.expb {
+				t.Fatalf("expected \"%s\" \"%s\" got \"%s\" \"%s\"", tc.exph, tc.expb, hash, built)
+			}
+		})
+	}
+
+	hash, built := Version("", "")
+	if hash == "" || built == "" {
+		t.Fatal("empty version from build info")
+	}
+}
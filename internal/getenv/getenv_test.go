@@ -0,0 +1,33 @@
+/* Copyright (c) 2021 David Bulkow */
+
+package getenv
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetInt(t *testing.T) {
+	env := NewEnv("GETENVTEST")
+
+	tests := []struct {
+		value string
+		exp   int
+	}{
+		{"", 42},
+		{"1024", 1024},
+		{"-5", -5},
+		{"12k", 42},
+	}
+
+	for _, tc := range tests {
+		os.Setenv("GETENVTEST_INT", tc.value)
+
+		v := env.GetInt("INT", 42)
+		if v != tc.exp {
+			t.Fatalf("expected %d for \"%s\" got %d", tc.exp, tc.value, v)
+		}
+	}
+
+	os.Unsetenv("GETENVTEST_INT")
+}
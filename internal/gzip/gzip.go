@@ -4,30 +4,64 @@ package Gzip
 
 import (
 	"compress/gzip"
-	"io"
 	"net/http"
 	"strings"
 )
 
+// the body is compressed from the first WriteHeader, responses without
+// a body (HEAD, 204, 304) are passed through untouched
 type gzipResponseWriter struct {
-	io.Writer
 	http.ResponseWriter
+	gz      *gzip.Writer
+	written bool
 }
 
-func (w gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.written {
+		return
+	}
+	w.written = true
+
+	if code != http.StatusNoContent && code != http.StatusNotModified {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	return w.gz.Close()
 }
 
 func Gzip(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead {
 			next.ServeHTTP(w, r)
 			return
 		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		gzr := gzipResponseWriter{Writer: gz, ResponseWriter: w}
+
+		gzr := &gzipResponseWriter{ResponseWriter: w}
+		defer gzr.Close()
 		next.ServeHTTP(gzr, r)
 	})
 }